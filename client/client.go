@@ -0,0 +1,310 @@
+package client
+
+import (
+	"io"
+	"fmt"
+	"bytes"
+	"context"
+	"time"
+	"io/ioutil"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	mtr "github.com/n-ct/ct-monitor"
+	el "github.com/n-ct/ct-monitor/entitylist"
+	"github.com/n-ct/ct-monitor/utils"
+	"github.com/n-ct/ct-monitor/signature"
+	ctca "github.com/n-ct/ct-certificate-authority"
+	lgr "github.com/n-ct/ct-logger/logger"
+)
+
+// DefaultTimeout is used for every request when no http.Client is given to the constructor
+const DefaultTimeout = 30 * time.Second
+
+// LoggerClient talks to a single ct-logger over its HTTP API.
+// Every SRD returned by the logger is checked against the logger's public key before it is handed to the caller.
+type LoggerClient struct {
+	URL			string // base URL of the logger, e.g. https://logger.example.com/
+	LogID		string
+	PublicKey	string // base64 DER public key used to verify logger signatures, the key history must chain to it
+	// If set, only SRDs signed with this algorithm by PublicKey are accepted, see logger.LogSignatureAlgorithm
+	SignatureAlgorithm	string
+	// If set, requests are signed with this key, see logger.SignRequest: the key of a CA to post its SRDs,
+	// the key of an operator to get or post the state
+	RequestSigner	*signature.Signer
+	httpClient	*http.Client
+	keys		[]lgr.LoggerKey // verified key history of the logger, see FetchKeys
+	keysMu		sync.Mutex
+}
+
+// Create a new LoggerClient for the logger at url. If httpClient is nil a client with DefaultTimeout is used
+func NewLoggerClient(url, logID, publicKey string, httpClient *http.Client) *LoggerClient {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: DefaultTimeout}
+	}
+	return &LoggerClient{
+		URL:		url,
+		LogID:		logID,
+		PublicKey:	publicKey,
+		httpClient:	httpClient,
+	}
+}
+
+// Create a new LoggerClient using the url and key found for logID in the log list file
+func NewLoggerClientFromLogList(logListName, logID string, httpClient *http.Client) (*LoggerClient, error) {
+	logList, err := el.NewLogList(logListName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create LoggerClient: %w", err)
+	}
+	logInfo := logList.FindLogByLogID(logID)
+	if logInfo == nil {
+		return nil, fmt.Errorf("Logger with id: [%v] not found in log list at: [%v]", logID, logListName)
+	}
+	signatureAlgorithm, err := lgr.LogSignatureAlgorithm(logListName, logID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create LoggerClient: %w", err)
+	}
+	c := NewLoggerClient(logInfo.URL, logInfo.LogID, logInfo.Key, httpClient)
+	c.SignatureAlgorithm = signatureAlgorithm
+	return c, nil
+}
+
+// Post a CA signed SRDWithRevData to the logger
+func (c *LoggerClient) PostLogSRDWithRevData(ctx context.Context, data *mtr.SRDWithRevData) error {
+	jsonBytes, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal SRDWithRevData: %v", err)
+	}
+	_, err = c.do(ctx, http.MethodPost, lgr.PostLogSRDWithRevDataPath, jsonBytes, lgr.MaxSRDBodySize)
+	if err != nil {
+		return fmt.Errorf("failed to post SRDWithRevData: %w", err)
+	}
+	return nil
+}
+
+// Get the most recent logger signed SRDWithRevData of every CA as CTObjects named by the CA.
+// Every returned CTObject has had its signature verified
+func (c *LoggerClient) GetLogSRDWithRevData(ctx context.Context) ([]lgr.LogSRDCTObject, error) {
+	//one SRD per CA and revocation type, as large as the CRVs of the state
+	body, err := c.do(ctx, http.MethodGet, lgr.GetLogSRDWithRevDataPath, nil, lgr.MaxStateBodySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SRDWithRevData: %w", err)
+	}
+	var ctObjects []lgr.LogSRDCTObject
+	if err := json.Unmarshal(body, &ctObjects); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal CTObjects from logger: %v", err)
+	}
+	if err := c.fetchKeysIfNeeded(ctx, ctObjects...); err != nil {
+		return nil, err
+	}
+	for i := range ctObjects {
+		if _, err := c.VerifyCTObject(&ctObjects[i]); err != nil {
+			return nil, err
+		}
+	}
+	return ctObjects, nil
+}
+
+// Same as GetLogSRDWithRevData but returns the decoded SRDWithRevData instead of the CTObjects
+func (c *LoggerClient) GetLogSRDWithRevDataList(ctx context.Context) ([]*mtr.SRDWithRevData, error) {
+	ctObjects, err := c.GetLogSRDWithRevData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	srds := make([]*mtr.SRDWithRevData, 0, len(ctObjects))
+	for i := range ctObjects {
+		srd, err := DecodeSRDWithRevData(&ctObjects[i].CTObject)
+		if err != nil {
+			return nil, err
+		}
+		srds = append(srds, srd)
+	}
+	return srds, nil
+}
+
+// Ask the logger to have one of its CAs revoke certificates and return the logger signed SRD for the result
+func (c *LoggerClient) RevokeAndProduceSRD(ctx context.Context, req *ctca.RevokeAndProduceSRDRequest) (*lgr.LogSRDCTObject, error) {
+	jsonBytes, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal RevokeAndProduceSRDRequest: %v", err)
+	}
+	body, err := c.do(ctx, http.MethodPost, lgr.RevokeAndProduceSRDPath, jsonBytes, lgr.MaxSRDBodySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke and produce SRD: %w", err)
+	}
+	var ctObject lgr.LogSRDCTObject
+	if err := json.Unmarshal(body, &ctObject); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal CTObject from logger: %v", err)
+	}
+	if err := c.fetchKeysIfNeeded(ctx, ctObject); err != nil {
+		return nil, err
+	}
+	if _, err := c.VerifyCTObject(&ctObject); err != nil {
+		return nil, err
+	}
+	return &ctObject, nil
+}
+
+// Fetch the signed state archive of the logger. The archive is verified before it is returned
+func (c *LoggerClient) GetState(ctx context.Context) (*lgr.StateArchive, error) {
+	body, err := c.do(ctx, http.MethodGet, lgr.GetStatePath, nil, lgr.MaxStateBodySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get state: %w", err)
+	}
+	var archive lgr.StateArchive
+	if err := json.Unmarshal(body, &archive); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal state archive from logger: %v", err)
+	}
+	if err := lgr.VerifyStateArchive(&archive, c.LogID, c.PublicKey); err != nil {
+		return nil, err
+	}
+	return &archive, nil
+}
+
+// Import a state archive into a logger that does not hold any state yet
+func (c *LoggerClient) PostState(ctx context.Context, archive *lgr.StateArchive) error {
+	jsonBytes, err := json.Marshal(archive)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state archive: %v", err)
+	}
+	if _, err := c.do(ctx, http.MethodPost, lgr.PostStatePath, jsonBytes, lgr.MaxSRDBodySize); err != nil {
+		return fmt.Errorf("failed to post state: %w", err)
+	}
+	return nil
+}
+
+// Fetch the signed key history of the logger. It is verified to chain to PublicKey before it is returned
+func (c *LoggerClient) GetKeyHistory(ctx context.Context) (*lgr.KeyHistory, error) {
+	body, err := c.do(ctx, http.MethodGet, lgr.GetKeysPath, nil, lgr.MaxSRDBodySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get keys: %w", err)
+	}
+	var history lgr.KeyHistory
+	if err := json.Unmarshal(body, &history); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal key history from logger: %v", err)
+	}
+	if _, err := lgr.VerifyKeyHistory(&history, c.LogID, c.PublicKey); err != nil {
+		return nil, err
+	}
+	return &history, nil
+}
+
+// Fetch and verify the key history of the logger.
+// Once fetched, SRDs are verified against the key named in the KeyID of their CTObject
+func (c *LoggerClient) FetchKeys(ctx context.Context) ([]lgr.LoggerKey, error) {
+	history, err := c.GetKeyHistory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.keysMu.Lock()
+	c.keys = history.Keys.Keys
+	c.keysMu.Unlock()
+	return history.Keys.Keys, nil
+}
+
+// Fetch the key history when one of the CTObjects is signed by a key other than PublicKey that is not known yet
+func (c *LoggerClient) fetchKeysIfNeeded(ctx context.Context, ctObjects ...lgr.LogSRDCTObject) error {
+	publicKeyID, err := lgr.KeyIDFromPublicKey(c.PublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid logger public key: %v", err)
+	}
+	for i := range ctObjects {
+		keyID := ctObjects[i].KeyID
+		if keyID == "" || keyID == publicKeyID || c.knowsKey(keyID) {
+			continue
+		}
+		_, err := c.FetchKeys(ctx)
+		return err
+	}
+	return nil
+}
+
+func (c *LoggerClient) knowsKey(keyID string) bool {
+	c.keysMu.Lock()
+	defer c.keysMu.Unlock()
+	for _, key := range c.keys {
+		if key.KeyID == keyID {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify that the SRD inside the CTObject was signed by this client's logger.
+// After FetchKeys, SRDs signed by any key of the verified key history that was valid at their timestamp are accepted
+func (c *LoggerClient) VerifyCTObject(ctObject *lgr.LogSRDCTObject) (*mtr.SRDWithRevData, error) {
+	srd, err := DecodeSRDWithRevData(&ctObject.CTObject)
+	if err != nil {
+		return nil, err
+	}
+	if c.LogID != "" && srd.SRD.EntityID != c.LogID {
+		return nil, fmt.Errorf("SRD signed by (%v) instead of logger (%v)", srd.SRD.EntityID, c.LogID)
+	}
+	c.keysMu.Lock()
+	keys := c.keys
+	c.keysMu.Unlock()
+	if len(keys) > 0 {
+		if err := lgr.VerifySRDWithKeys(srd, keys, ctObject.KeyID); err != nil {
+			return nil, lgr.NewError(lgr.ErrorCodeInvalidSignature, err, "invalid logger signature on SRD")
+		}
+		return srd, nil
+	}
+	if c.SignatureAlgorithm != "" {
+		if name, err := lgr.AlgorithmName(srd.SRD.Signature.Algorithm); err != nil || name != c.SignatureAlgorithm {
+			return nil, lgr.NewError(lgr.ErrorCodeInvalidSignature, nil, "SRD is not signed with the advertised signature algorithm %v", c.SignatureAlgorithm)
+		}
+	}
+	if err := lgr.VerifyLogSRD(c.PublicKey, srd); err != nil {
+		return nil, lgr.NewError(lgr.ErrorCodeInvalidSignature, err, "invalid logger signature on SRD")
+	}
+	return srd, nil
+}
+
+// Decode the SRDWithRevData held in the blob of a CTObject
+func DecodeSRDWithRevData(ctObject *mtr.CTObject) (*mtr.SRDWithRevData, error) {
+	if ctObject.TypeID != mtr.SRDWithRevDataTypeID {
+		return nil, fmt.Errorf("CTObject of type %v is not a %v", ctObject.TypeID, mtr.SRDWithRevDataTypeID)
+	}
+	var srd mtr.SRDWithRevData
+	if err := json.Unmarshal(ctObject.Blob, &srd); err != nil {
+		return nil, fmt.Errorf("error deconstructing SRDWithRevData from %s CTObject: %v", ctObject.TypeID, err)
+	}
+	return &srd, nil
+}
+
+// Send a request to the logger and return the body of a successful response, failing if it is longer than maxRespSize bytes
+func (c *LoggerClient) do(ctx context.Context, method, path string, body []byte, maxRespSize int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, utils.CreateRequestURL(c.URL, path), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.RequestSigner != nil {
+		if err := lgr.SignRequest(req, body, c.RequestSigner); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRespSize + 1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	if int64(len(respBody)) > maxRespSize {
+		return nil, fmt.Errorf("logger response is larger than %v bytes", maxRespSize)
+	}
+	if resp.StatusCode != http.StatusOK {
+		//the *lgr.Error of the response is in the chain, so callers can branch on its Code
+		if respErr := lgr.ParseErrorResponse(respBody); respErr != nil {
+			return nil, fmt.Errorf("logger responded with %v: %w", resp.Status, respErr)
+		}
+		return nil, fmt.Errorf("logger responded with %v: %s", resp.Status, bytes.TrimSpace(respBody))
+	}
+	return respBody, nil
+}
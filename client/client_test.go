@@ -0,0 +1,186 @@
+package client
+
+import (
+	"testing"
+	"context"
+	"errors"
+	"encoding/json"
+	"time"
+	"strings"
+	"net/http"
+	"net/http/httptest"
+
+	ctca "github.com/n-ct/ct-certificate-authority"
+	lgr "github.com/n-ct/ct-logger/logger"
+	"github.com/n-ct/ct-logger/internal/testutil"
+)
+
+const (
+	config_filename  string = "../testdata/config.json"
+	caList_filename  string = "../testdata/ca_list.json"
+	logList_filename string = "../testdata/log_list.json"
+	ca_id			 string = testutil.CAID
+)
+
+//start a logger behind an httptest server and return a client pointed at it
+func mustCreateLoggerAndClient(t *testing.T) (*lgr.Logger, *httptest.Server, *LoggerClient) {
+	t.Helper()
+	logger, err := lgr.NewLogger(config_filename, caList_filename, logList_filename)
+	if err != nil {
+		t.Fatalf("failed to create Logger with config @ (%s): %v", config_filename, err)
+	}
+	serveMux, err := lgr.NewServeMux([]*lgr.Logger{logger}, nil)
+	if err != nil {
+		t.Fatalf("failed to create serve mux: %v", err)
+	}
+	server := httptest.NewServer(serveMux)
+	t.Cleanup(server.Close)
+	return logger, server, NewLoggerClient(server.URL, logger.LogID, logger.PublicKey, nil)
+}
+
+func TestPostAndGetLogSRDWithRevData(t *testing.T) {
+	logger, _, client := mustCreateLoggerAndClient(t)
+	ctx := context.Background()
+
+	if err := client.PostLogSRDWithRevData(ctx, testutil.CreateCASRD(t, []uint64{1, 3}, []uint64{1, 3}, time.Now())); err != nil {
+		t.Fatalf("failed to post SRD: %v", err)
+	}
+	srds, err := client.GetLogSRDWithRevDataList(ctx)
+	if err != nil {
+		t.Fatalf("failed to get SRDs: %v", err)
+	}
+	if len(srds) != 1 {
+		t.Fatalf("returned list should be of size 1 not %v", len(srds))
+	}
+	if srds[0].SRD.EntityID != logger.LogID {
+		t.Fatalf("SRD EntityID should be %v not %v", logger.LogID, srds[0].SRD.EntityID)
+	}
+}
+
+func TestGetLogSRDWithRevDataRejectsWrongKey(t *testing.T) {
+	_, server, client := mustCreateLoggerAndClient(t)
+	ctx := context.Background()
+	if err := client.PostLogSRDWithRevData(ctx, testutil.CreateCASRD(t, []uint64{2}, []uint64{2}, time.Now())); err != nil {
+		t.Fatalf("failed to post SRD: %v", err)
+	}
+
+	//the CA key is a valid key but not the logger's
+	caKey := "MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEmFk6QT48Ts4oxSkBPM4mQ/mnWICKVmZUP6urQVBH0vhDzJVYHc2ShvF2KjWzorVu2C+tY6lIU+61iiPLsGvZXw=="
+	badClient := NewLoggerClient(server.URL, client.LogID, caKey, nil)
+	if _, err := badClient.GetLogSRDWithRevData(ctx); err == nil {
+		t.Fatalf("client accepted SRD signed with a different key")
+	}
+}
+
+func TestGetLogSRDWithRevDataRejectsOtherAlgorithm(t *testing.T) {
+	_, server, client := mustCreateLoggerAndClient(t)
+	ctx := context.Background()
+	if err := client.PostLogSRDWithRevData(ctx, testutil.CreateCASRD(t, []uint64{2}, []uint64{2}, time.Now())); err != nil {
+		t.Fatalf("failed to post SRD: %v", err)
+	}
+	client.SignatureAlgorithm = lgr.AlgorithmECDSAP256SHA256
+	if _, err := client.GetLogSRDWithRevData(ctx); err != nil {
+		t.Fatalf("client rejected SRD signed with the advertised algorithm: %v", err)
+	}
+
+	//the log list advertises another algorithm than the logger signs with
+	badClient := NewLoggerClient(server.URL, client.LogID, client.PublicKey, nil)
+	badClient.SignatureAlgorithm = lgr.AlgorithmEd25519
+	if _, err := badClient.GetLogSRDWithRevData(ctx); err == nil {
+		t.Fatalf("client accepted SRD not signed with the advertised algorithm")
+	}
+}
+
+func TestGetLogSRDWithRevDataAfterKeyRotation(t *testing.T) {
+	configName := testutil.WriteConfig(t, config_filename, testutil.WithRotatedKey(testutil.RotatedPrivateKey))
+	logger, err := lgr.NewLogger(configName, caList_filename, logList_filename)
+	if err != nil {
+		t.Fatalf("failed to create Logger with rotated key: %v", err)
+	}
+	serveMux, err := lgr.NewServeMux([]*lgr.Logger{logger}, nil)
+	if err != nil {
+		t.Fatalf("failed to create serve mux: %v", err)
+	}
+	server := httptest.NewServer(serveMux)
+	defer server.Close()
+	client := NewLoggerClient(server.URL, logger.LogID, logger.PublicKey, nil)
+	ctx := context.Background()
+	newKey := logger.Keys[1]
+	if err := client.PostLogSRDWithRevData(ctx, testutil.CreateCASRD(t, []uint64{1, 3}, []uint64{1, 3}, time.Now())); err != nil {
+		t.Fatalf("failed to post SRD: %v", err)
+	}
+
+	//the client only trusts the key from the log list and has to fetch the key history to verify the SRD
+	ctObjects, err := client.GetLogSRDWithRevData(ctx)
+	if err != nil {
+		t.Fatalf("failed to get SRD signed with the rotated key: %v", err)
+	}
+	if len(ctObjects) != 1 || ctObjects[0].KeyID != newKey.KeyID {
+		t.Fatalf("SRD should be signed by key %v, got %v", newKey.KeyID, ctObjects)
+	}
+	keys, err := client.FetchKeys(ctx)
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("client should hold both keys, got %v: %v", keys, err)
+	}
+}
+
+func TestPostLogSRDWithRevDataRejected(t *testing.T) {
+	_, _, client := mustCreateLoggerAndClient(t)
+	srd := testutil.CreateCASRD(t, []uint64{1, 3}, []uint64{1, 3}, time.Now())
+	srd.SRD.RevDigest.Timestamp++ //invalidates the CA signature
+	err := client.PostLogSRDWithRevData(context.Background(), srd)
+	if err == nil {
+		t.Fatalf("logger accepted SRD with invalid signature")
+	}
+	if !errors.Is(err, lgr.ErrBadSignature) {
+		t.Fatalf("rejection should be %v, got %v", lgr.ErrBadSignature, err)
+	}
+}
+
+func TestRevokeAndProduceSRD(t *testing.T) {
+	logger, _, client := mustCreateLoggerAndClient(t)
+	caServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(res).Encode(testutil.CreateCASRD(t, []uint64{4, 5, 7}, []uint64{4, 5, 7}, time.Now()))
+	}))
+	defer caServer.Close()
+	logger.CAList.FindCAByCAID(ca_id).CAURL = caServer.URL
+
+	ctObject, err := client.RevokeAndProduceSRD(context.Background(), &ctca.RevokeAndProduceSRDRequest{PercentRevoked: 10, TotalCerts: 100})
+	if err != nil {
+		t.Fatalf("failed to revoke and produce SRD: %v", err)
+	}
+	if ctObject.Signer != logger.LogID {
+		t.Fatalf("CTObject Signer should be %v not %v", logger.LogID, ctObject.Signer)
+	}
+	if ctObject.CAID != ca_id {
+		t.Fatalf("CTObject should name CA %v not %v", ca_id, ctObject.CAID)
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	blocked := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		<-blocked
+	}))
+	defer server.Close()
+	defer close(blocked)
+
+	client := NewLoggerClient(server.URL, "", "", nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.GetLogSRDWithRevData(ctx); err == nil {
+		t.Fatalf("request did not fail after context deadline")
+	}
+}
+
+func TestResponseSizeIsLimited(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write(make([]byte, lgr.MaxSRDBodySize + 1))
+	}))
+	defer server.Close()
+
+	client := NewLoggerClient(server.URL, "", "", nil)
+	if _, err := client.GetKeyHistory(context.Background()); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Fatalf("response larger than %v bytes should be rejected, got %v", lgr.MaxSRDBodySize, err)
+	}
+}
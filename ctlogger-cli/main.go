@@ -0,0 +1,588 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"context"
+	"io/ioutil"
+	"encoding/json"
+	"encoding/base64"
+	"encoding/hex"
+
+	mtr "github.com/n-ct/ct-monitor"
+	el "github.com/n-ct/ct-monitor/entitylist"
+	"github.com/n-ct/ct-monitor/signature"
+	ca "github.com/n-ct/ct-certificate-authority/ca"
+	ctca "github.com/n-ct/ct-certificate-authority"
+	"github.com/n-ct/ct-logger/client"
+	lgr "github.com/n-ct/ct-logger/logger"
+	"github.com/n-ct/ct-logger/verifier"
+)
+
+const usage = `Usage: ctlogger-cli <command> [flags]
+
+Commands:
+  post        post a CA signed SRDWithRevData read from a file to a logger
+  get         fetch, verify and print the logger's SRDs
+  verify      verify the signatures of SRDs in a file against the ca list and log list
+  decode-crv  decode a compressed CRV into the list of revoked indices
+  revoke      ask the logger to trigger revoke-and-produce on one of its CAs
+  audit       verify dumped logger SRD bundles offline and report inconsistencies per CA
+  keys        fetch, verify and print the key history of a logger
+  export      save the signed state archive of a logger to a file
+  import      verify a state archive and load it into a logger that holds no state yet
+
+Run ctlogger-cli <command> -h for the flags of a command.
+`
+
+//where the commands print their results and errors, replaced by the tests
+var (
+	stdout	io.Writer = os.Stdout
+	stderr	io.Writer = os.Stderr
+)
+
+var commands = map[string]func([]string) error{
+	"post":			runPost,
+	"get":			runGet,
+	"verify":		runVerify,
+	"decode-crv":	runDecodeCRV,
+	"revoke":		runRevoke,
+	"audit":		runAudit,
+	"keys":			runKeys,
+	"export":		runExport,
+	"import":		runImport,
+}
+
+// Error of a command given flags it does not know or that do not parse, the flag package already printed the usage
+type usageError struct {
+	err	error
+}
+
+func (e *usageError) Error() string {
+	return e.err.Error()
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// Runs the command named by args[0] with the rest of args as its flags and returns the exit status:
+// 0 on success or -h, 1 when the command fails and 2 for a missing or unknown command or bad flags
+func run(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+	err := command(args[1:])
+	var usageErr *usageError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.As(err, &usageErr):
+		return 2
+	default:
+		fmt.Fprintf(stderr, "%s: %v\n", args[0], err)
+		return 1
+	}
+}
+
+// Creates the flag set of a command, parse it with parseFlags
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	return fs
+}
+
+// Parses the flags of a command, a -h is returned as flag.ErrHelp and other errors as a usageError
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err != nil && err != flag.ErrHelp {
+		return &usageError{err}
+	}
+	return err
+}
+
+// Flags shared by every command that talks to a running logger
+type loggerFlags struct {
+	logListName	*string
+	logID		*string
+	url			*string
+	timeout		*time.Duration
+}
+
+func addLoggerFlags(fs *flag.FlagSet) *loggerFlags {
+	return &loggerFlags{
+		logListName:	fs.String("loglist", "logger/log_list.json", "File containing log list file"),
+		logID:			fs.String("log_id", "", "Log ID of the logger to talk to"),
+		url:			fs.String("url", "", "URL of the logger, overrides the URL found in the log list"),
+		timeout:		fs.Duration("timeout", client.DefaultTimeout, "Timeout for the whole command"),
+	}
+}
+
+// Create the LoggerClient and a context carrying the command timeout
+func (f *loggerFlags) newClient() (*client.LoggerClient, context.Context, context.CancelFunc, error) {
+	var c *client.LoggerClient
+	if *f.logID == "" {
+		if *f.url == "" {
+			return nil, nil, nil, fmt.Errorf("either -log_id or -url must be given")
+		}
+		c = client.NewLoggerClient(*f.url, "", "", nil)
+	} else {
+		var err error
+		c, err = client.NewLoggerClientFromLogList(*f.logListName, *f.logID, nil)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if *f.url != "" {
+			c.URL = *f.url
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *f.timeout)
+	return c, ctx, cancel, nil
+}
+
+func runPost(args []string) error {
+	fs := newFlagSet("post")
+	lf := addLoggerFlags(fs)
+	fileName := fs.String("file", "", "File containing a JSON SRDWithRevData or SRD_REVDATA CTObject")
+	signKeyName := fs.String("sign_key", "", "File containing the base64 private key of the CA, used to sign the requests")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	srds, _, err := readSRDFile(*fileName)
+	if err != nil {
+		return err
+	}
+	c, ctx, cancel, err := lf.newClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	if err := setRequestSigner(c, *signKeyName); err != nil {
+		return err
+	}
+	for _, srd := range srds {
+		if err := c.PostLogSRDWithRevData(ctx, srd); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "posted SRD of %v at timestamp %v\n", srd.SRD.EntityID, srd.SRD.RevDigest.Timestamp)
+	}
+	return nil
+}
+
+// Sign the requests of c with the base64 private key in the file keyName, if one is given
+func setRequestSigner(c *client.LoggerClient, keyName string) error {
+	if keyName == "" {
+		return nil
+	}
+	keyBytes, err := ioutil.ReadFile(keyName)
+	if err != nil {
+		return fmt.Errorf("error reading sign key: %v", err)
+	}
+	c.RequestSigner, err = signature.NewSigner(strings.TrimSpace(string(keyBytes)))
+	if err != nil {
+		return fmt.Errorf("error creating request signer: %v", err)
+	}
+	return nil
+}
+
+func runGet(args []string) error {
+	fs := newFlagSet("get")
+	lf := addLoggerFlags(fs)
+	raw := fs.Bool("raw", false, "Print the CTObjects as returned by the logger instead of a summary")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	c, ctx, cancel, err := lf.newClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	if c.PublicKey == "" {
+		return fmt.Errorf("-log_id is required to verify the logger's signatures")
+	}
+	ctObjects, err := c.GetLogSRDWithRevData(ctx)
+	if err != nil {
+		return err
+	}
+	if *raw {
+		return printJSON(ctObjects)
+	}
+	summaries := []srdSummary{}
+	for i := range ctObjects {
+		srd, err := client.DecodeSRDWithRevData(&ctObjects[i].CTObject)
+		if err != nil {
+			return err
+		}
+		summary, err := summarizeSRD(srd)
+		if err != nil {
+			return err
+		}
+		summary.CAID = ctObjects[i].CAID
+		summaries = append(summaries, *summary)
+	}
+	return printJSON(summaries)
+}
+
+func runVerify(args []string) error {
+	fs := newFlagSet("verify")
+	caListName := fs.String("calist", "logger/ca_list.json", "File containing ca list file")
+	logListName := fs.String("loglist", "logger/log_list.json", "File containing log list file")
+	fileName := fs.String("file", "", "File containing a JSON SRDWithRevData, CTObject or list of CTObjects")
+	keysNames := fs.String("keys", "", "Comma separated files saved with the keys command, SRDs of those loggers may be signed by any key of the history")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	caList, err := el.NewCAList(*caListName)
+	if err != nil {
+		return err
+	}
+	logList, err := el.NewLogList(*logListName)
+	if err != nil {
+		return err
+	}
+	var keyHistories verifier.KeyHistories
+	if *keysNames != "" {
+		if keyHistories, err = verifier.LoadKeyHistories(strings.Split(*keysNames, ","), logList); err != nil {
+			return err
+		}
+	}
+	srds, keyIDs, err := readSRDFile(*fileName)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for i, srd := range srds {
+		signer, err := verifySRD(srd, keyIDs[i], caList, logList, keyHistories)
+		if err != nil {
+			failed++
+			fmt.Fprintf(stdout, "FAIL %v at timestamp %v: %v\n", srd.SRD.EntityID, srd.SRD.RevDigest.Timestamp, err)
+			continue
+		}
+		fmt.Fprintf(stdout, "OK   %v at timestamp %v signed by %v\n", srd.SRD.EntityID, srd.SRD.RevDigest.Timestamp, signer)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%v of %v SRDs failed verification", failed, len(srds))
+	}
+	return nil
+}
+
+func runDecodeCRV(args []string) error {
+	fs := newFlagSet("decode-crv")
+	crvB64 := fs.String("crv", "", "Base64 encoded compressed CRV")
+	fileName := fs.String("file", "", "File containing a JSON SRDWithRevData or CTObject, its CRVDelta is decoded")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	var compCRVs [][]byte
+	switch {
+	case *crvB64 != "":
+		compCRV, err := base64.StdEncoding.DecodeString(*crvB64)
+		if err != nil {
+			return fmt.Errorf("failed to base64 decode CRV: %v", err)
+		}
+		compCRVs = append(compCRVs, compCRV)
+	case *fileName != "":
+		srds, _, err := readSRDFile(*fileName)
+		if err != nil {
+			return err
+		}
+		for _, srd := range srds {
+			compCRVs = append(compCRVs, srd.RevData.CRVDelta)
+		}
+	default:
+		return fmt.Errorf("either -crv or -file must be given")
+	}
+	for _, compCRV := range compCRVs {
+		crv, err := ctca.DecompressCRV(compCRV)
+		if err != nil {
+			return fmt.Errorf("failed to decompress CRV: %v", err)
+		}
+		if err := printJSON((*crv).ToNums()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runRevoke(args []string) error {
+	fs := newFlagSet("revoke")
+	lf := addLoggerFlags(fs)
+	percent := fs.Uint("percent", 1, "Percentage of the certificates to revoke")
+	total := fs.Uint64("total", 1000, "Total number of certificates issued by the CA")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *percent > 100 {
+		return fmt.Errorf("-percent must be between 0 and 100")
+	}
+	c, ctx, cancel, err := lf.newClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	if c.PublicKey == "" {
+		return fmt.Errorf("-log_id is required to verify the logger's signatures")
+	}
+	req := &ctca.RevokeAndProduceSRDRequest{PercentRevoked: uint8(*percent), TotalCerts: *total}
+	ctObject, err := c.RevokeAndProduceSRD(ctx, req)
+	if err != nil {
+		return err
+	}
+	srd, err := client.DecodeSRDWithRevData(&ctObject.CTObject)
+	if err != nil {
+		return err
+	}
+	summary, err := summarizeSRD(srd)
+	if err != nil {
+		return err
+	}
+	summary.CAID = ctObject.CAID
+	return printJSON(summary)
+}
+
+func runAudit(args []string) error {
+	fs := newFlagSet("audit")
+	caListName := fs.String("calist", "logger/ca_list.json", "File containing ca list file")
+	logListName := fs.String("loglist", "logger/log_list.json", "File containing log list file")
+	keysNames := fs.String("keys", "", "Comma separated files saved with the keys command, SRDs of those loggers may be signed by any key of the history")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: ctlogger-cli audit [flags] <bundle file>...\n")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		return fmt.Errorf("at least one bundle file must be given")
+	}
+	var keyHistoryNames []string
+	if *keysNames != "" {
+		keyHistoryNames = strings.Split(*keysNames, ",")
+	}
+	report, err := verifier.VerifyBundleFiles(fs.Args(), keyHistoryNames, *caListName, *logListName)
+	if err != nil {
+		return err
+	}
+	if err := printJSON(report); err != nil {
+		return err
+	}
+	if !report.OK() {
+		return fmt.Errorf("bundle failed verification")
+	}
+	return nil
+}
+
+func runKeys(args []string) error {
+	fs := newFlagSet("keys")
+	lf := addLoggerFlags(fs)
+	out := fs.String("out", "", "File to save the signed key history to, for use with audit and verify -keys")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	c, ctx, cancel, err := lf.newClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	if c.PublicKey == "" {
+		return fmt.Errorf("-log_id is required to verify the key history")
+	}
+	history, err := c.GetKeyHistory(ctx)
+	if err != nil {
+		return err
+	}
+	if *out != "" {
+		jsonBytes, err := json.Marshal(history)
+		if err != nil {
+			return fmt.Errorf("failed to marshal key history: %v", err)
+		}
+		if err := ioutil.WriteFile(*out, jsonBytes, 0644); err != nil {
+			return fmt.Errorf("failed to write key history: %v", err)
+		}
+	}
+	return printJSON(history.Keys.Keys)
+}
+
+func runExport(args []string) error {
+	fs := newFlagSet("export")
+	lf := addLoggerFlags(fs)
+	outName := fs.String("out", "logger_state.json", "File to write the state archive to")
+	signKeyName := fs.String("sign_key", "", "File containing the base64 private key of an operator of the logger, used to sign the requests")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	c, ctx, cancel, err := lf.newClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	if c.PublicKey == "" {
+		return fmt.Errorf("-log_id is required to verify the state archive")
+	}
+	if err := setRequestSigner(c, *signKeyName); err != nil {
+		return err
+	}
+	archive, err := c.GetState(ctx)
+	if err != nil {
+		return err
+	}
+	jsonBytes, err := json.Marshal(archive)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state archive: %v", err)
+	}
+	if err := ioutil.WriteFile(*outName, jsonBytes, 0600); err != nil {
+		return fmt.Errorf("failed to write state archive: %v", err)
+	}
+	fmt.Fprintf(stdout, "exported %v CRVs and %v SRDs to %v\n", len(archive.State.CRVs), len(archive.State.SRDHistory), *outName)
+	return nil
+}
+
+func runImport(args []string) error {
+	fs := newFlagSet("import")
+	lf := addLoggerFlags(fs)
+	fileName := fs.String("file", "logger_state.json", "File containing the state archive")
+	signKeyName := fs.String("sign_key", "", "File containing the base64 private key of an operator of the logger, used to sign the requests")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	byteData, err := ioutil.ReadFile(*fileName)
+	if err != nil {
+		return fmt.Errorf("error reading file: %v", err)
+	}
+	var archive lgr.StateArchive
+	if err := json.Unmarshal(byteData, &archive); err != nil {
+		return fmt.Errorf("failed to unmarshal state archive: %v", err)
+	}
+	c, ctx, cancel, err := lf.newClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	if c.PublicKey == "" {
+		return fmt.Errorf("-log_id is required to verify the state archive")
+	}
+	if err := lgr.VerifyStateArchive(&archive, c.LogID, c.PublicKey); err != nil {
+		return err
+	}
+	if err := setRequestSigner(c, *signKeyName); err != nil {
+		return err
+	}
+	if err := c.PostState(ctx, &archive); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "imported %v CRVs and %v SRDs from %v\n", len(archive.State.CRVs), len(archive.State.SRDHistory), *fileName)
+	return nil
+}
+
+// Human readable view of an SRDWithRevData
+type srdSummary struct {
+	EntityID		string
+	CAID			string `json:",omitempty"` // CA of a logger signed SRD
+	RevocationType	string
+	Timestamp		uint64
+	Time			string
+	CRVHash			string
+	CRVDeltaHash	string
+	DeltaRevoked	[]uint64
+}
+
+func summarizeSRD(srd *mtr.SRDWithRevData) (*srdSummary, error) {
+	deltaCRV, err := ctca.DecompressCRV(srd.RevData.CRVDelta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress delta CRV: %v", err)
+	}
+	return &srdSummary{
+		EntityID:		srd.SRD.EntityID,
+		RevocationType:	srd.RevData.RevocationType,
+		Timestamp:		srd.SRD.RevDigest.Timestamp,
+		Time:			time.Unix(int64(srd.SRD.RevDigest.Timestamp), 0).UTC().Format(time.RFC3339),
+		CRVHash:		hex.EncodeToString(srd.SRD.RevDigest.CRVHash),
+		CRVDeltaHash:	hex.EncodeToString(srd.SRD.RevDigest.CRVDeltaHash),
+		DeltaRevoked:	(*deltaCRV).ToNums(),
+	}, nil
+}
+
+// Verify the SRD against the key of its signer, which may be either a CA or a logger.
+// Returns the kind of entity that signed it
+func verifySRD(srd *mtr.SRDWithRevData, keyID string, caList *el.CAList, logList *el.LogList, keyHistories verifier.KeyHistories) (string, error) {
+	entityID := srd.SRD.EntityID
+	if caInfo := caList.FindCAByCAID(entityID); caInfo != nil {
+		return "CA", ca.VerifySRDSignature(&srd.SRD, caInfo.CAKey)
+	}
+	if keys, ok := keyHistories[entityID]; ok {
+		return "logger", lgr.VerifySRDWithKeys(srd, keys, keyID)
+	}
+	if logInfo := logList.FindLogByLogID(entityID); logInfo != nil {
+		return "logger", lgr.VerifyLogSRD(logInfo.Key, srd)
+	}
+	return "", fmt.Errorf("entity (%v) not found in ca list or log list", entityID)
+}
+
+// Read SRDs from a file holding a JSON SRDWithRevData, a single CTObject or a list of CTObjects.
+// Also returns the KeyID the logger named for each SRD, empty for SRDs without one
+func readSRDFile(fileName string) ([]*mtr.SRDWithRevData, []string, error) {
+	if fileName == "" {
+		return nil, nil, fmt.Errorf("-file must be given")
+	}
+	byteData, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading file: %v", err)
+	}
+
+	var ctObjects []lgr.LogSRDCTObject
+	if err := json.Unmarshal(byteData, &ctObjects); err == nil {
+		return decodeCTObjects(ctObjects)
+	}
+	var ctObject lgr.LogSRDCTObject
+	if err := json.Unmarshal(byteData, &ctObject); err == nil && ctObject.TypeID != "" {
+		return decodeCTObjects([]lgr.LogSRDCTObject{ctObject})
+	}
+	var srd mtr.SRDWithRevData
+	if err := json.Unmarshal(byteData, &srd); err != nil {
+		return nil, nil, fmt.Errorf("%v does not contain an SRDWithRevData or CTObject: %v", fileName, err)
+	}
+	return []*mtr.SRDWithRevData{&srd}, []string{""}, nil
+}
+
+func decodeCTObjects(ctObjects []lgr.LogSRDCTObject) ([]*mtr.SRDWithRevData, []string, error) {
+	srds := make([]*mtr.SRDWithRevData, 0, len(ctObjects))
+	keyIDs := make([]string, 0, len(ctObjects))
+	for i := range ctObjects {
+		srd, err := client.DecodeSRDWithRevData(&ctObjects[i].CTObject)
+		if err != nil {
+			return nil, nil, err
+		}
+		srds = append(srds, srd)
+		keyIDs = append(keyIDs, ctObjects[i].KeyID)
+	}
+	return srds, keyIDs, nil
+}
+
+func printJSON(i interface{}) error {
+	jsonBytes, err := json.MarshalIndent(i, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal output: %v", err)
+	}
+	fmt.Fprintln(stdout, string(jsonBytes))
+	return nil
+}
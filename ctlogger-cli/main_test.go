@@ -0,0 +1,259 @@
+package main
+
+import (
+	"testing"
+	"bytes"
+	"strings"
+	"time"
+	"encoding/json"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+
+	mtr "github.com/n-ct/ct-monitor"
+	ctca "github.com/n-ct/ct-certificate-authority"
+	lgr "github.com/n-ct/ct-logger/logger"
+	"github.com/n-ct/ct-logger/internal/testutil"
+)
+
+const (
+	config_filename  string = "../testdata/config.json"
+	caList_filename  string = "../testdata/ca_list.json"
+	logList_filename string = "../testdata/log_list.json"
+	ca_id			 string = testutil.CAID
+)
+
+//function that runs the command line args and returns the exit status and what was printed to stdout
+func mustRun(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	oldStdout, oldStderr := stdout, stderr
+	stdout, stderr = &out, &errOut
+	defer func() {
+		stdout, stderr = oldStdout, oldStderr
+	}()
+	status := run(args)
+	t.Logf("%v: status %v, stderr %s", args, status, errOut.String())
+	return status, out.String()
+}
+
+//function that marshals v as JSON into a file in dir and returns its name
+func mustWriteJSON(t *testing.T, dir, fileName string, v interface{}) string {
+	t.Helper()
+	jsonBytes, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("failed to marshal %v: %v", fileName, err)
+	}
+	fileName = filepath.Join(dir, fileName)
+	if err := ioutil.WriteFile(fileName, jsonBytes, 0600); err != nil {
+		t.Fatalf("failed to write %v: %v", fileName, err)
+	}
+	return fileName
+}
+
+//feed the test logger two MMDs of revocations and return the dump taken after each of them
+func mustCreateBundles(t *testing.T) ([]lgr.LogSRDCTObject, []lgr.LogSRDCTObject) {
+	t.Helper()
+	logger, err := lgr.NewLogger(config_filename, caList_filename, logList_filename)
+	if err != nil {
+		t.Fatalf("failed to create Logger: %v", err)
+	}
+	return mustCreateLoggerBundles(t, logger)
+}
+
+//feed logger two MMDs of revocations and return the dump taken after each of them
+func mustCreateLoggerBundles(t *testing.T, logger *lgr.Logger) ([]lgr.LogSRDCTObject, []lgr.LogSRDCTObject) {
+	t.Helper()
+	now := time.Now()
+	var bundles [][]lgr.LogSRDCTObject
+	for i, srd := range []*mtr.SRDWithRevData{
+		testutil.CreateCASRD(t, []uint64{1, 3}, []uint64{1, 3}, now),
+		testutil.CreateCASRD(t, []uint64{1, 3, 4, 5}, []uint64{4, 5}, now.Add(time.Second)),
+	} {
+		if err := logger.UpdateLogSRDWithRevData(srd); err != nil {
+			t.Fatalf("failed to update logger with SRD %v: %v", i, err)
+		}
+		jsonBytes, err := logger.GetAllLogSrdWithRevDataAsJSONBytes()
+		if err != nil {
+			t.Fatalf("failed to dump logger: %v", err)
+		}
+		var bundle []lgr.LogSRDCTObject
+		if err := json.Unmarshal(jsonBytes, &bundle); err != nil {
+			t.Fatalf("failed to unmarshal bundle: %v", err)
+		}
+		bundles = append(bundles, bundle)
+	}
+	return bundles[0], bundles[1]
+}
+
+//function that returns a copy of the logger signed SRD in bundle with its timestamp changed after signing
+func mustTamper(t *testing.T, bundle []lgr.LogSRDCTObject) *lgr.LogSRDCTObject {
+	t.Helper()
+	var srd mtr.SRDWithRevData
+	if err := json.Unmarshal(bundle[0].Blob, &srd); err != nil {
+		t.Fatalf("failed to unmarshal SRD: %v", err)
+	}
+	srd.SRD.RevDigest.Timestamp++
+	tampered, err := mtr.ConstructCTObject(&srd)
+	if err != nil {
+		t.Fatalf("failed to construct CTObject: %v", err)
+	}
+	return &lgr.LogSRDCTObject{CTObject: *tampered, CAID: bundle[0].CAID}
+}
+
+func TestRunParsesArguments(t *testing.T) {
+	tests := []struct {
+		name	string
+		args	[]string
+		want	int
+	}{
+		{"no command", nil, 2},
+		{"unknown command", []string{"list"}, 2},
+		{"help", []string{"decode-crv", "-h"}, 0},
+		{"unknown flag", []string{"decode-crv", "-bogus"}, 2},
+		{"flag without value", []string{"post", "-url"}, 2},
+		{"bad flag value", []string{"revoke", "-percent", "many"}, 2},
+		{"bad duration", []string{"get", "-timeout", "soon"}, 2},
+		{"missing file", []string{"post", "-url", "http://localhost:1"}, 1},
+		{"missing logger", []string{"keys"}, 1},
+		{"percent out of range", []string{"revoke", "-url", "http://localhost:1", "-percent", "101"}, 1},
+		{"decode without input", []string{"decode-crv"}, 1},
+		{"audit without bundles", []string{"audit"}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if status, _ := mustRun(t, test.args...); status != test.want {
+				t.Fatalf("expected exit status %v for %q, got %v", test.want, test.args, status)
+			}
+		})
+	}
+}
+
+func TestDecodeCRV(t *testing.T) {
+	compCRV, err := ctca.CompressCRV(ctca.GetCRVDelta([]uint64{1, 3, 64}))
+	if err != nil {
+		t.Fatalf("failed to compress CRV: %v", err)
+	}
+	srdName := mustWriteJSON(t, t.TempDir(), "srd.json", testutil.CreateCASRD(t, []uint64{1, 3, 4, 5}, []uint64{4, 5}, time.Now()))
+	tests := []struct {
+		name	string
+		args	[]string
+		want	[]uint64
+		status	int
+	}{
+		{"crv", []string{"-crv", base64.StdEncoding.EncodeToString(compCRV)}, []uint64{1, 3, 64}, 0},
+		{"file", []string{"-file", srdName}, []uint64{4, 5}, 0},
+		{"not base64", []string{"-crv", "not base64!"}, nil, 1},
+		{"not compressed", []string{"-crv", base64.StdEncoding.EncodeToString([]byte("not a CRV"))}, nil, 1},
+		{"missing file", []string{"-file", filepath.Join(t.TempDir(), "missing.json")}, nil, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, out := mustRun(t, append([]string{"decode-crv"}, test.args...)...)
+			if status != test.status {
+				t.Fatalf("expected exit status %v, got %v", test.status, status)
+			}
+			if test.status != 0 {
+				return
+			}
+			var revoked []uint64
+			if err := json.Unmarshal([]byte(out), &revoked); err != nil {
+				t.Fatalf("failed to unmarshal output %q: %v", out, err)
+			}
+			if len(revoked) != len(test.want) {
+				t.Fatalf("expected %v, got %v", test.want, revoked)
+			}
+			for i := range revoked {
+				if revoked[i] != test.want[i] {
+					t.Fatalf("expected %v, got %v", test.want, revoked)
+				}
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	_, bundle := mustCreateBundles(t)
+	unknownSRD := testutil.CreateCASRD(t, []uint64{1}, []uint64{1}, time.Now())
+	unknownSRD.SRD.EntityID = "unknown"
+	tests := []struct {
+		name	string
+		file	interface{}
+		status	int
+		want	string
+	}{
+		{"ca signed", testutil.CreateCASRD(t, []uint64{1}, []uint64{1}, time.Now()), 0, "signed by CA"},
+		{"logger signed", bundle, 0, "signed by logger"},
+		{"single ctobject", bundle[0], 0, "signed by logger"},
+		{"tampered", []lgr.LogSRDCTObject{bundle[0], *mustTamper(t, bundle)}, 1, "FAIL"},
+		{"unknown entity", unknownSRD, 1, "not found in ca list or log list"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileName := mustWriteJSON(t, dir, strings.Replace(test.name, " ", "_", -1) + ".json", test.file)
+			status, out := mustRun(t, "verify", "-calist", caList_filename, "-loglist", logList_filename, "-file", fileName)
+			if status != test.status || !strings.Contains(out, test.want) {
+				t.Fatalf("expected exit status %v and %q in the output, got %v: %s", test.status, test.want, status, out)
+			}
+		})
+	}
+}
+
+func TestAudit(t *testing.T) {
+	dir := t.TempDir()
+	first, second := mustCreateBundles(t)
+	firstName := mustWriteJSON(t, dir, "first.json", first)
+	secondName := mustWriteJSON(t, dir, "second.json", second)
+	tamperedName := mustWriteJSON(t, dir, "tampered.json", []lgr.LogSRDCTObject{*mustTamper(t, second)})
+	tests := []struct {
+		name	string
+		files	[]string
+		status	int
+	}{
+		{"history", []string{firstName, secondName}, 0},
+		{"single bundle", []string{secondName}, 0},
+		{"tampered", []string{firstName, tamperedName}, 1},
+		{"missing bundle", []string{filepath.Join(dir, "missing.json")}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := append([]string{"audit", "-calist", caList_filename, "-loglist", logList_filename}, test.files...)
+			status, out := mustRun(t, args...)
+			if status != test.status {
+				t.Fatalf("expected exit status %v, got %v: %s", test.status, status, out)
+			}
+			if test.status == 0 && !strings.Contains(out, ca_id) {
+				t.Fatalf("report should list the CA, got %s", out)
+			}
+		})
+	}
+}
+
+func TestVerifyWithKeyHistory(t *testing.T) {
+	dir := t.TempDir()
+	//the key of the log list was rotated out before the SRDs were signed
+	configName := testutil.WriteConfig(t, config_filename, testutil.WithRotatedKey(testutil.RotatedPrivateKey))
+	logger, err := lgr.NewLogger(configName, caList_filename, logList_filename)
+	if err != nil {
+		t.Fatalf("failed to create Logger: %v", err)
+	}
+	_, bundle := mustCreateLoggerBundles(t, logger)
+	history, err := logger.GetKeyHistory()
+	if err != nil {
+		t.Fatalf("failed to get key history: %v", err)
+	}
+	bundleName := mustWriteJSON(t, dir, "bundle.json", bundle)
+	keysName := mustWriteJSON(t, dir, "keys.json", history)
+
+	args := []string{"verify", "-calist", caList_filename, "-loglist", logList_filename, "-file", bundleName}
+	if status, out := mustRun(t, args...); status != 1 || !strings.Contains(out, "FAIL") {
+		t.Fatalf("SRDs signed with a rotated key should not verify against the log list key alone, got %v: %s", status, out)
+	}
+	if status, out := mustRun(t, append(args, "-keys", keysName)...); status != 0 || !strings.Contains(out, "signed by logger") {
+		t.Fatalf("SRDs signed with a key of the history should verify with -keys, got %v: %s", status, out)
+	}
+	if status, _ := mustRun(t, append(args, "-keys", filepath.Join(dir, "missing.json"))...); status != 1 {
+		t.Fatalf("missing key history should fail, got %v", status)
+	}
+}
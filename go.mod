@@ -8,4 +8,5 @@ require (
 	github.com/google/certificate-transparency-go v1.1.1
 	github.com/n-ct/ct-certificate-authority v0.0.0-20210408003514-086e14235d37
 	github.com/n-ct/ct-monitor v0.0.0-20210407172231-18516bf4180d
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 )
@@ -0,0 +1,120 @@
+package testutil
+
+import (
+	"testing"
+	"time"
+	"io/ioutil"
+	"encoding/json"
+	"path/filepath"
+
+	"github.com/google/certificate-transparency-go/tls"
+	mtr "github.com/n-ct/ct-monitor"
+	"github.com/n-ct/ct-monitor/signature"
+	ca "github.com/n-ct/ct-certificate-authority/ca"
+	ctca "github.com/n-ct/ct-certificate-authority"
+)
+
+// The CA of testdata/ca_list.json
+const (
+	CAID			string = "LeYXK29QzQV9RxvgMw+hnOeyZV85A6a5quOLltev9H0="
+	CAPrivateKey	string = "MHcCAQEEIOWK47/9gxKjcpTe8UhL4PyXZS1lPcnqChRvlw/Jpnh0oAoGCCqGSM49AwEHoUQDQgAEmFk6QT48Ts4oxSkBPM4mQ/mnWICKVmZUP6urQVBH0vhDzJVYHc2ShvF2KjWzorVu2C+tY6lIU+61iiPLsGvZXw=="
+)
+
+// Key a logger rotates to, not the key of any other entity in the test lists
+const RotatedPrivateKey string = "MHcCAQEEILgQXnYYh0sNaAozBn1v4w1QLvVXdGDTf0aKV1t+rdW+oAoGCCqGSM49AwEHoUQDQgAEeRIhl6i/zkzY8SF0VRvgL/OytZvbleYKpGTSXouL6GJ1Du2Q/oIPHQ9WNriycUDS9lKMc0ZzqbUOAJM8Vt29Bg=="
+
+// Returns an SRD of the test CA revoking revoked, with a delta of delta, made at timestamp
+func CreateCASRD(t *testing.T, revoked, delta []uint64, timestamp time.Time) *mtr.SRDWithRevData {
+	t.Helper()
+	signer, err := signature.NewSigner(CAPrivateKey)
+	if err != nil {
+		t.Fatalf("failed to create CA signer: %v", err)
+	}
+	srd, err := ca.CreateSRDWithRevData(ctca.CreateCRV(revoked, 0), ctca.GetCRVDelta(delta), uint64(timestamp.Unix()), CAID, tls.SHA256, signer)
+	if err != nil {
+		t.Fatalf("failed to create CA SRD: %v", err)
+	}
+	return srd
+}
+
+// ConfigOption changes the logger config written by WriteConfig. config is its JSON object, dir the directory it is written to
+type ConfigOption func(t *testing.T, config map[string]interface{}, dir string)
+
+// Sets the config field key to value
+func WithField(key string, value interface{}) ConfigOption {
+	return func(t *testing.T, config map[string]interface{}, dir string) {
+		config[key] = value
+	}
+}
+
+// Sets every field v marshals to, e.g. all fields of a logger.KeySource
+func WithFields(v interface{}) ConfigOption {
+	return func(t *testing.T, config map[string]interface{}, dir string) {
+		t.Helper()
+		jsonBytes, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("failed to marshal config fields: %v", err)
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(jsonBytes, &fields); err != nil {
+			t.Fatalf("config fields are not a JSON object: %v", err)
+		}
+		for key, value := range fields {
+			config[key] = value
+		}
+	}
+}
+
+// Rotates the private_key of the config out: newPrivKey took over an hour ago and the old key retires in an hour
+func WithRotatedKey(newPrivKey string) ConfigOption {
+	return func(t *testing.T, config map[string]interface{}, dir string) {
+		rotatedAt, retireAt := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+		config["keys"] = []map[string]interface{}{
+			{"private_key": config["private_key"], "not_after": retireAt},
+			{"private_key": newPrivKey, "not_before": rotatedAt},
+		}
+		delete(config, "private_key")
+	}
+}
+
+// Copies the files next to the config, so relative paths in it find them
+func WithFiles(fileNames ...string) ConfigOption {
+	return func(t *testing.T, config map[string]interface{}, dir string) {
+		t.Helper()
+		for _, fileName := range fileNames {
+			data, err := ioutil.ReadFile(fileName)
+			if err != nil {
+				t.Fatalf("failed to read %v: %v", fileName, err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(dir, filepath.Base(fileName)), data, 0600); err != nil {
+				t.Fatalf("failed to copy %v: %v", fileName, err)
+			}
+		}
+	}
+}
+
+// Writes a copy of the logger config baseName changed by options to a temporary directory and returns its file name
+func WriteConfig(t *testing.T, baseName string, options ...ConfigOption) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(baseName)
+	if err != nil {
+		t.Fatalf("failed to read logger config: %v", err)
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatalf("failed to unmarshal logger config: %v", err)
+	}
+	dir := t.TempDir()
+	for _, option := range options {
+		option(t, config, dir)
+	}
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		t.Fatalf("failed to marshal logger config: %v", err)
+	}
+	fileName := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(fileName, configBytes, 0600); err != nil {
+		t.Fatalf("failed to write logger config: %v", err)
+	}
+	return fileName
+}
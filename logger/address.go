@@ -0,0 +1,92 @@
+package logger
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const (
+	//prefix of a listen address or log URL naming a unix socket, e.g. unix:/run/ct-logger.sock
+	UnixSocketPrefix	= "unix:"
+)
+
+//default ports used when a log URL does not name one, map[scheme]port
+var defaultPorts = map[string]string{
+	"http":		"80",
+	"https":	"443",
+}
+
+//ResolveListenAddress turns a log URL or listen address into the network and address to pass to net.Listen.
+//It accepts full URLs (https://host:port/path/), bare host:port pairs, :port, bracketed IPv6 hosts
+//and unix sockets written as unix:/path or unix:///path.
+func ResolveListenAddress(rawAddress string) (network, address string, err error) {
+	rawAddress = strings.TrimSpace(rawAddress)
+	if rawAddress == "" {
+		return "", "", fmt.Errorf("empty listen address")
+	}
+	if strings.HasPrefix(rawAddress, UnixSocketPrefix) {
+		path := strings.TrimPrefix(rawAddress, UnixSocketPrefix)
+		path = strings.TrimPrefix(path, "//")
+		if path == "" {
+			return "", "", fmt.Errorf("unix socket address %q has no path", rawAddress)
+		}
+		return "unix", path, nil
+	}
+
+	//without a scheme url.Parse would take the host for the scheme, so parse it as a network path reference
+	if !strings.Contains(rawAddress, "://") {
+		rawAddress = "//" + rawAddress
+	}
+	u, err := url.Parse(rawAddress)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid listen address %q: %w", rawAddress, err)
+	}
+	if u.Scheme != "" {
+		if _, ok := defaultPorts[u.Scheme]; !ok {
+			return "", "", fmt.Errorf("unsupported scheme %q in listen address %q", u.Scheme, rawAddress)
+		}
+	}
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return "", "", fmt.Errorf("listen address %q must not contain user info, a query or a fragment", rawAddress)
+	}
+
+	host := u.Hostname()
+	port := u.Port()
+	if port == "" {
+		if strings.HasSuffix(u.Host, ":") {
+			return "", "", fmt.Errorf("listen address %q has an empty port", rawAddress)
+		}
+		port = defaultPorts[u.Scheme]
+	}
+	if port == "" {
+		return "", "", fmt.Errorf("listen address %q has no port", rawAddress)
+	}
+	if host == "" && u.Host != ":"+port {
+		return "", "", fmt.Errorf("listen address %q has no host", rawAddress)
+	}
+	return "tcp", net.JoinHostPort(host, port), nil
+}
+
+//ResolvePathPrefix returns the path of a log URL without its trailing slash, e.g. /logs/argon2020 for
+//https://ct.googleapis.com/logs/argon2020/. The endpoints of the logger are served under this prefix, it is empty for a root URL.
+func ResolvePathPrefix(rawURL string) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if strings.HasPrefix(rawURL, UnixSocketPrefix) {
+		return "", nil
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "//" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid log URL %q: %w", rawURL, err)
+	}
+	prefix := path.Clean("/" + u.Path)
+	if prefix == "/" {
+		return "", nil
+	}
+	return prefix, nil
+}
@@ -0,0 +1,105 @@
+package logger
+
+import (
+	"testing"
+	"github.com/n-ct/ct-logger/internal/testutil"
+)
+
+func TestResolveListenAddress(t *testing.T) {
+	tests := []struct {
+		in		string
+		network	string
+		address	string
+		wantErr	bool
+	}{
+		{in: "https://ct.googleapis.com:6966/argon2020/", network: "tcp", address: "ct.googleapis.com:6966"},
+		{in: "https://ct.googleapis.com:6966/argon2020", network: "tcp", address: "ct.googleapis.com:6966"},
+		{in: "http://localhost:8080", network: "tcp", address: "localhost:8080"},
+		{in: "http://localhost:8080/", network: "tcp", address: "localhost:8080"},
+		{in: "https://ct.googleapis.com/logs/argon2020/", network: "tcp", address: "ct.googleapis.com:443"},
+		{in: "http://ct.example.com", network: "tcp", address: "ct.example.com:80"},
+		{in: "localhost:6966", network: "tcp", address: "localhost:6966"},
+		{in: ":6966", network: "tcp", address: ":6966"},
+		{in: "0.0.0.0:6966", network: "tcp", address: "0.0.0.0:6966"},
+		{in: "[::1]:6966", network: "tcp", address: "[::1]:6966"},
+		{in: "[::]:6966", network: "tcp", address: "[::]:6966"},
+		{in: "https://[2001:db8::1]:6966/log/", network: "tcp", address: "[2001:db8::1]:6966"},
+		{in: "http://[::1]/", network: "tcp", address: "[::1]:80"},
+		{in: " http://localhost:8080 ", network: "tcp", address: "localhost:8080"},
+		{in: "unix:/run/ct-logger.sock", network: "unix", address: "/run/ct-logger.sock"},
+		{in: "unix:///run/ct-logger.sock", network: "unix", address: "/run/ct-logger.sock"},
+		{in: "unix:ct-logger.sock", network: "unix", address: "ct-logger.sock"},
+		{in: "", wantErr: true},
+		{in: "unix:", wantErr: true},
+		{in: "localhost", wantErr: true},
+		{in: "localhost:", wantErr: true},
+		{in: "http://", wantErr: true},
+		{in: "ftp://localhost:21", wantErr: true},
+		{in: "http://user@localhost:8080", wantErr: true},
+		{in: "http://localhost:8080/?q=1", wantErr: true},
+		{in: "[::1", wantErr: true},
+	}
+	for _, test := range tests {
+		network, address, err := ResolveListenAddress(test.in)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ResolveListenAddress(%q) = %q %q, want error", test.in, network, address)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ResolveListenAddress(%q) failed: %v", test.in, err)
+			continue
+		}
+		if network != test.network || address != test.address {
+			t.Errorf("ResolveListenAddress(%q) = %q %q, want %q %q", test.in, network, address, test.network, test.address)
+		}
+	}
+}
+
+func TestListenAddressOverride(t *testing.T) {
+	logger, err := mustCreateLogger(t)
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+	if logger.Network != "tcp" || logger.Address != "ct.googleapis.com:443" {
+		t.Fatalf("logger should listen on the address of its log URL, got %v %v", logger.Network, logger.Address)
+	}
+
+	logger, err = NewLogger(testutil.WriteConfig(t, config_filename, testutil.WithField("listen_address", "unix:/run/ct-logger.sock")), caList_filename, logList_filename)
+	if err != nil {
+		t.Fatalf("failed to create logger with listen_address: %v", err)
+	}
+	if logger.Network != "unix" || logger.Address != "/run/ct-logger.sock" {
+		t.Fatalf("listen_address should override the log URL, got %v %v", logger.Network, logger.Address)
+	}
+
+	if _, err := NewLogger(testutil.WriteConfig(t, config_filename, testutil.WithField("listen_address", "localhost")), caList_filename, logList_filename); err == nil {
+		t.Fatalf("logger should not be created with a listen_address without a port")
+	}
+}
+
+func TestResolvePathPrefix(t *testing.T) {
+	tests := []struct {
+		in		string
+		prefix	string
+	}{
+		{in: "https://ct.googleapis.com/logs/argon2020/", prefix: "/logs/argon2020"},
+		{in: "https://ct.googleapis.com:6966/argon2020", prefix: "/argon2020"},
+		{in: "https://ct.googleapis.com:6966/", prefix: ""},
+		{in: "https://ct.googleapis.com:6966", prefix: ""},
+		{in: "http://[::1]:6966/log//", prefix: "/log"},
+		{in: "localhost:6966/log/", prefix: "/log"},
+		{in: "unix:/run/ct-logger.sock", prefix: ""},
+	}
+	for _, test := range tests {
+		prefix, err := ResolvePathPrefix(test.in)
+		if err != nil {
+			t.Errorf("ResolvePathPrefix(%q) failed: %v", test.in, err)
+			continue
+		}
+		if prefix != test.prefix {
+			t.Errorf("ResolvePathPrefix(%q) = %q, want %q", test.in, prefix, test.prefix)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package logger
+
+import (
+	"fmt"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/certificate-transparency-go/tls"
+	"github.com/n-ct/ct-monitor/signature"
+	"github.com/n-ct/ct-monitor/utils"
+)
+
+//signature algorithms besides tls.RSA and tls.ECDSA in the one byte TLS 1.2 SignatureAlgorithm field.
+//Ed25519 is the value RFC 8422 registers for ed25519. RSA-PSS has no value in this field, it uses one of the
+//private use range 224-255 of the registry.
+//RFC 8422 pairs ed25519 with the Intrinsic(8) hash, Ed25519 signatures here carry SHA256 instead: the hash byte also
+//names the hash of the CRV digests in the signed SRD, which CAs and monitors recompute with Signature.Algorithm.Hash
+const (
+	Ed25519	tls.SignatureAlgorithm = 7
+	RSAPSS	tls.SignatureAlgorithm = 224
+)
+
+//names of the signature algorithms a logger can sign with, used in the logger config and the log list
+const (
+	AlgorithmECDSAP256SHA256	= "ecdsa-p256-sha256"
+	AlgorithmECDSAP384SHA384	= "ecdsa-p384-sha384"
+	AlgorithmEd25519			= "ed25519"
+	AlgorithmRSAPSSSHA256		= "rsa-pss-sha256"
+	AlgorithmRSAPKCS1SHA256		= "rsa-pkcs1-sha256"
+)
+
+//the algorithm of a signature is its SignatureAndHashAlgorithm. The hash is also the hash of the digests in the signed object,
+//which is why Ed25519 signatures carry one even though Ed25519 signs the serialized object itself
+var signatureAlgorithms = map[string] tls.SignatureAndHashAlgorithm{
+	AlgorithmECDSAP256SHA256:	{Hash: tls.SHA256, Signature: tls.ECDSA},
+	AlgorithmECDSAP384SHA384:	{Hash: tls.SHA384, Signature: tls.ECDSA},
+	AlgorithmEd25519:			{Hash: tls.SHA256, Signature: Ed25519},
+	AlgorithmRSAPSSSHA256:		{Hash: tls.SHA256, Signature: RSAPSS},
+	AlgorithmRSAPKCS1SHA256:	{Hash: tls.SHA256, Signature: tls.RSA},
+}
+
+//returns the name of the algorithm of a signature, or an error if the logger does not support it
+func AlgorithmName(algorithm tls.SignatureAndHashAlgorithm) (string, error) {
+	for name, a := range signatureAlgorithms {
+		if a == algorithm {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported signature algorithm %v with hash %v", algorithm.Signature, algorithm.Hash)
+}
+
+//returns the algorithm used for a key when the config does not name one
+func defaultAlgorithm(publicKey crypto.PublicKey) (string, error) {
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		if key.Curve == elliptic.P384() {
+			return AlgorithmECDSAP384SHA384, nil
+		}
+		return AlgorithmECDSAP256SHA256, nil
+	case ed25519.PublicKey:
+		return AlgorithmEd25519, nil
+	case *rsa.PublicKey:
+		return AlgorithmRSAPKCS1SHA256, nil
+	}
+	return "", fmt.Errorf("unsupported public key type %T", publicKey)
+}
+
+//checks that the key can make signatures of the named algorithm
+func checkKeyAlgorithm(name string, publicKey crypto.PublicKey) error {
+	algorithm, ok := signatureAlgorithms[name]
+	if !ok {
+		return fmt.Errorf("unknown signature algorithm %q", name)
+	}
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		if algorithm.Signature == tls.ECDSA {
+			if (key.Curve == elliptic.P256()) == (name == AlgorithmECDSAP256SHA256) && (key.Curve == elliptic.P384()) == (name == AlgorithmECDSAP384SHA384) {
+				return nil
+			}
+			return fmt.Errorf("ECDSA key on curve %v can not sign with %v", key.Curve.Params().Name, name)
+		}
+	case ed25519.PublicKey:
+		if algorithm.Signature == Ed25519 {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if algorithm.Signature == tls.RSA || algorithm.Signature == RSAPSS {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+	return fmt.Errorf("%T key can not sign with %v", publicKey, name)
+}
+
+//Verifies a signature made by a logger Signer, dispatching on the algorithm of the signature.
+//publicKey is a base64 DER public key, it must be of the type the algorithm uses
+func VerifySignature(publicKey string, data interface{}, sig ct.DigitallySigned) error {
+	name, err := AlgorithmName(sig.Algorithm)
+	if err != nil {
+		return err
+	}
+	der, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return fmt.Errorf("error decoding public key for signature verification: %v", err)
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return fmt.Errorf("error parsing public key for signature verification: %v", err)
+	}
+	if err := checkKeyAlgorithm(name, key); err != nil {
+		return err
+	}
+	switch sig.Algorithm.Signature {
+	case tls.ECDSA, tls.RSA:
+		return signature.VerifySignature(publicKey, data, sig)
+	}
+	byteData, err := signature.SerializeData(data)
+	if err != nil {
+		return fmt.Errorf("error serializing %T type struct for signature verification: %w", data, err)
+	}
+	switch sig.Algorithm.Signature {
+	case Ed25519:
+		if !ed25519.Verify(key.(ed25519.PublicKey), byteData, sig.Signature) {
+			return fmt.Errorf("failed to verify Ed25519 signature")
+		}
+	case RSAPSS:
+		digest, hash, err := signature.GenerateHash(sig.Algorithm.Hash, byteData)
+		if err != nil {
+			return err
+		}
+		if err := rsa.VerifyPSS(key.(*rsa.PublicKey), hash, digest, sig.Signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}); err != nil {
+			return fmt.Errorf("failed to verify RSA-PSS signature: %v", err)
+		}
+	}
+	return nil
+}
+
+//the part of a log list entry that the entitylist package does not parse
+type logListAlgorithms struct {
+	Operators []struct {
+		Logs []struct {
+			LogID				string	`json:"log_id"`
+			SignatureAlgorithm	string	`json:"signature_algorithm"`
+		}	`json:"logs"`
+	}	`json:"operators"`
+}
+
+//Returns the signature algorithm advertised for logID in the log list, empty if the entry does not name one
+func LogSignatureAlgorithm(logListName, logID string) (string, error) {
+	byteData, err := utils.FiletoBytes(logListName)
+	if err != nil {
+		return "", err
+	}
+	var logList logListAlgorithms
+	if err := json.Unmarshal(byteData, &logList); err != nil {
+		return "", fmt.Errorf("failed to parse log list %v: %v", logListName, err)
+	}
+	for _, operator := range logList.Operators {
+		for _, log := range operator.Logs {
+			if log.LogID != logID || log.SignatureAlgorithm == "" {
+				continue
+			}
+			if _, ok := signatureAlgorithms[log.SignatureAlgorithm]; !ok {
+				return "", fmt.Errorf("log list advertises unknown signature algorithm %q for logger (%v)", log.SignatureAlgorithm, logID)
+			}
+			return log.SignatureAlgorithm, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,169 @@
+package logger
+
+import (
+	"testing"
+	"strings"
+	"time"
+	"io/ioutil"
+	"path/filepath"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	mtr "github.com/n-ct/ct-monitor"
+	"github.com/google/certificate-transparency-go/tls"
+	"github.com/n-ct/ct-logger/internal/testutil"
+)
+
+//function that generates a key for the named signature algorithm
+func mustGenerateKey(t *testing.T, algorithm string) crypto.Signer {
+	t.Helper()
+	var key crypto.Signer
+	var err error
+	switch algorithm {
+	case AlgorithmECDSAP256SHA256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgorithmECDSAP384SHA384:
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case AlgorithmEd25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case AlgorithmRSAPSSSHA256, AlgorithmRSAPKCS1SHA256:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil || key == nil {
+		t.Fatalf("failed to generate key for %v: %v", algorithm, err)
+	}
+	return key
+}
+
+func TestSignatureAlgorithms(t *testing.T) {
+	revDigest := mtr.RevocationDigest{Timestamp: 1, CRVHash: []byte{1}, CRVDeltaHash: []byte{2}}
+	for name, algorithm := range signatureAlgorithms {
+		key := mustGenerateKey(t, name)
+		signer, err := NewCryptoSigner(key, name)
+		if err != nil {
+			t.Fatalf("failed to create %v signer: %v", name, err)
+		}
+		sig, err := signer.CreateSignature(revDigest)
+		if err != nil {
+			t.Fatalf("failed to sign with %v: %v", name, err)
+		}
+		if sig.Algorithm != algorithm {
+			t.Fatalf("%v signature has algorithm %v", name, sig.Algorithm)
+		}
+		publicKey := mustPublicKey(t, key)
+		if err := VerifySignature(publicKey, revDigest, *sig); err != nil {
+			t.Fatalf("%v signature does not verify: %v", name, err)
+		}
+		tampered := revDigest
+		tampered.Timestamp = 2
+		if err := VerifySignature(publicKey, tampered, *sig); err == nil {
+			t.Fatalf("%v signature should not verify over other data", name)
+		}
+	}
+}
+
+func TestSignatureAlgorithmMismatch(t *testing.T) {
+	p256Key := mustGenerateKey(t, AlgorithmECDSAP256SHA256)
+	if _, err := NewCryptoSigner(p256Key, AlgorithmECDSAP384SHA384); err == nil {
+		t.Fatalf("P-256 key should not sign with %v", AlgorithmECDSAP384SHA384)
+	}
+	if _, err := NewCryptoSigner(p256Key, AlgorithmEd25519); err == nil {
+		t.Fatalf("ECDSA key should not sign with %v", AlgorithmEd25519)
+	}
+	if _, err := NewCryptoSigner(p256Key, "ecdsa-p521-sha512"); err == nil {
+		t.Fatalf("unknown signature algorithms should be rejected")
+	}
+
+	signer, _ := NewCryptoSigner(p256Key, "")
+	sig, err := signer.CreateSignature("sign this")
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	relabeled := *sig
+	relabeled.Algorithm.Hash = tls.SHA384
+	if err := VerifySignature(mustPublicKey(t, p256Key), "sign this", relabeled); err == nil {
+		t.Fatalf("signature should not verify under another algorithm")
+	}
+	key := LoggerKey{PublicKey: mustPublicKey(t, p256Key), SignatureAlgorithm: AlgorithmRSAPKCS1SHA256}
+	if err := key.verifySignature("sign this", *sig); err == nil {
+		t.Fatalf("key should only accept signatures of its recorded algorithm")
+	}
+}
+
+func TestLoggerWithSignatureAlgorithms(t *testing.T) {
+	for _, name := range []string{AlgorithmEd25519, AlgorithmECDSAP384SHA384, AlgorithmRSAPSSSHA256} {
+		key := mustGenerateKey(t, name)
+		var der []byte
+		var err error
+		if ecKey, ok := key.(*ecdsa.PrivateKey); ok {
+			der, err = x509.MarshalECPrivateKey(ecKey)
+		} else {
+			der, err = x509.MarshalPKCS8PrivateKey(key)
+		}
+		if err != nil {
+			t.Fatalf("failed to marshal %v key: %v", name, err)
+		}
+		keySource := KeySource{PrivKey: base64.StdEncoding.EncodeToString(der)}
+		if name == AlgorithmRSAPSSSHA256 {
+			keySource.SignatureAlgorithm = name //RSA keys default to PKCS#1 v1.5
+		}
+		logListName := mustWriteLogListWithKey(t, mustPublicKey(t, key), name)
+		logger, err := NewLogger(testutil.WriteConfig(t, config_filename, testutil.WithFields(keySource)), caList_filename, logListName)
+		if err != nil {
+			t.Fatalf("failed to create logger signing with %v: %v", name, err)
+		}
+		if logger.Keys[0].SignatureAlgorithm != name {
+			t.Fatalf("logger key should sign with %v, got %v", name, logger.Keys[0].SignatureAlgorithm)
+		}
+
+		srd := mustUpdateAt(t, logger, []uint64{1,3}, []uint64{1,3}, time.Now())
+		if err := VerifySRDWithKeys(srd, logger.Keys, ""); err != nil {
+			t.Fatalf("%v SRD does not verify: %v", name, err)
+		}
+		if name == AlgorithmECDSAP384SHA384 && len(srd.SRD.RevDigest.CRVHash) != 48 {
+			t.Fatalf("%v SRD should hash its CRV with SHA-384", name)
+		}
+		archive, err := logger.ExportState()
+		if err != nil {
+			t.Fatalf("failed to export state of %v logger: %v", name, err)
+		}
+		if err := VerifyStateArchive(archive, logger.LogID, logger.Keys[0].PublicKey); err != nil {
+			t.Fatalf("state archive of %v logger does not verify: %v", name, err)
+		}
+	}
+}
+
+func TestAdvertisedSignatureAlgorithm(t *testing.T) {
+	config, err := parseLoggerConfig(config_filename)
+	if err != nil {
+		t.Fatalf("failed to parse logger config: %v", err)
+	}
+	algorithm, err := LogSignatureAlgorithm(logList_filename, config.LogID)
+	if err != nil || algorithm != AlgorithmECDSAP256SHA256 {
+		t.Fatalf("log list should advertise %v, got %q: %v", AlgorithmECDSAP256SHA256, algorithm, err)
+	}
+	logger, err := mustCreateLogger(t)
+	if err != nil || logger.SignatureAlgorithm != AlgorithmECDSAP256SHA256 {
+		t.Fatalf("logger should take the advertised signature algorithm: %v", err)
+	}
+
+	logListBytes, err := ioutil.ReadFile(logList_filename)
+	if err != nil {
+		t.Fatalf("failed to read log list: %v", err)
+	}
+	for _, advertised := range []string{AlgorithmEd25519, "ecdsa-p521-sha512"} {
+		logListName := filepath.Join(t.TempDir(), "log_list.json")
+		modified := strings.Replace(string(logListBytes), `"signature_algorithm": "ecdsa-p256-sha256"`, `"signature_algorithm": "` + advertised + `"`, 1)
+		if err := ioutil.WriteFile(logListName, []byte(modified), 0600); err != nil {
+			t.Fatalf("failed to write log list: %v", err)
+		}
+		if _, err := NewLogger(config_filename, caList_filename, logListName); err == nil {
+			t.Fatalf("logger should not start when the log list advertises %v for its ecdsa-p256-sha256 key", advertised)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"github.com/golang/glog"
+	mtr "github.com/n-ct/ct-monitor"
+)
+
+//events of the audit log
+const (
+	AuditAccept	= "accept" //a CA signed SRD was verified and applied to the CRV of the CA
+	AuditReject	= "reject" //a CA signed SRD was refused, Reason holds the error code
+	AuditSign	= "sign" //the logger signed an SRD for an accepted CA SRD
+)
+
+//at most one record of SRDs rejected before their poster was authenticated is written per interval, see AppendUnauthenticated
+const AuditUnauthenticatedInterval = time.Second
+
+//levels of the audit records
+const (
+	AuditLevelInfo		= "info"
+	AuditLevelWarning	= "warning"
+)
+
+//one line of the audit log. Hash is the SHA-256 of the JSON of the record with Hash unset,
+//and PrevHash the Hash of the record before it, so changing, dropping or reordering records breaks the chain
+type AuditRecord struct {
+	Seq				uint64		`json:"seq"` //position in the audit log, starting at 1
+	Time			time.Time	`json:"time"`
+	Level			string		`json:"level"`
+	Event			string		`json:"event"`
+	LogID			string		`json:"log_id"`
+	CAID			string		`json:"ca_id,omitempty"`
+	RevocationType	string		`json:"revocation_type,omitempty"`
+	Timestamp		uint64		`json:"timestamp,omitempty"` //timestamp of the SRD
+	CRVHash			[]byte		`json:"crv_hash,omitempty"`
+	CRVDeltaHash	[]byte		`json:"crv_delta_hash,omitempty"`
+	Signature		[]byte		`json:"signature,omitempty"` //CA signature on accept and reject, logger signature on sign
+	KeyID			string		`json:"key_id,omitempty"` //logger key of a sign event
+	Caller			string		`json:"caller,omitempty"` //who sent the SRD, see describeCaller
+	Reason			string		`json:"reason,omitempty"` //error code of a reject event
+	Message			string		`json:"message,omitempty"`
+	//unauthenticated rejects left out of the log since the previous one written, see AppendUnauthenticated
+	Suppressed		uint64		`json:"suppressed,omitempty"`
+	PrevHash		[]byte		`json:"prev_hash"`
+	Hash			[]byte		`json:"hash"`
+}
+
+func (r *AuditRecord) computeHash() ([]byte, error) {
+	unhashed := *r
+	unhashed.Hash = nil
+	recordBytes, err := json.Marshal(&unhashed)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(recordBytes)
+	return hash[:], nil
+}
+
+//file the audit log is written to, an *os.File unless replaced in tests
+type auditFile interface {
+	io.Writer
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+//append-only file of hash-chained AuditRecords, one JSON record per line
+type AuditLog struct {
+	mu			sync.Mutex
+	file		auditFile
+	size		int64 //length of the file up to the end of the last record, see append
+	seq			uint64
+	lastHash	[]byte
+	lastUnauthenticated	time.Time //when the last unauthenticated reject was written
+	suppressed			uint64 //unauthenticated rejects left out since then
+}
+
+// AuditHead is the latest record of an audit log. The logger signs it with its state archive,
+// so records before it can not be changed or dropped later without breaking the chain to a signed hash
+type AuditHead struct {
+	Seq		uint64
+	Hash	[]byte
+}
+
+// Opens the audit log at fileName, creating it if needed. Records already in the file must form an
+// unbroken chain, new records continue it. An unterminated last line is a record whose write never completed,
+// so no SRD was signed for it, and is cut off
+func OpenAuditLog(fileName string) (*AuditLog, error) {
+	file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	size, err := completeLength(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read audit log at [%v]: %w", fileName, err)
+	}
+	seq, lastHash, err := VerifyAuditLog(io.NewSectionReader(file, 0, size))
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("audit log at [%v] is not intact, refusing to append to it. Keep it as evidence and move it aside " +
+			"to start a new audit log: %w", fileName, err)
+	}
+	if info, err := file.Stat(); err == nil && info.Size() > size {
+		glog.Warningf("audit log at [%v] ends in an incomplete record, cutting off its last %v bytes", fileName, info.Size() - size)
+		if err := file.Truncate(size); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to cut the incomplete record off audit log at [%v]: %w", fileName, err)
+		}
+	}
+	return &AuditLog{file: file, size: size, seq: seq, lastHash: lastHash}, nil
+}
+
+//length of file up to and including its last newline
+func completeLength(file *os.File) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	end := info.Size()
+	buf := make([]byte, 64 * 1024)
+	for end > 0 {
+		n := int64(len(buf))
+		if n > end {
+			n = end
+		}
+		if _, err := file.ReadAt(buf[:n], end - n); err != nil {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return end - n + int64(i) + 1, nil
+		}
+		end -= n
+	}
+	return 0, nil
+}
+
+// Checks the hash chain of the audit records read from r. Returns the number of records and the hash of the last one
+func VerifyAuditLog(r io.Reader) (uint64, []byte, error) {
+	var seq uint64
+	var lastHash []byte
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64 * 1024), MaxSRDBodySize)
+	for scanner.Scan() {
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return 0, nil, fmt.Errorf("record %v is not valid JSON: %v", seq + 1, err)
+		}
+		if record.Seq != seq + 1 {
+			return 0, nil, fmt.Errorf("record %v has sequence number %v", seq + 1, record.Seq)
+		}
+		if !bytes.Equal(record.PrevHash, lastHash) {
+			return 0, nil, fmt.Errorf("record %v does not chain to the record before it", record.Seq)
+		}
+		hash, err := record.computeHash()
+		if err != nil {
+			return 0, nil, err
+		}
+		if !bytes.Equal(record.Hash, hash) {
+			return 0, nil, fmt.Errorf("record %v does not match its hash", record.Seq)
+		}
+		seq, lastHash = record.Seq, record.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return seq, lastHash, nil
+}
+
+// Checks the hash chain of the audit records read from r and that it holds head, e.g. from a signed state archive
+func VerifyAuditLogHead(r io.Reader, head *AuditHead) error {
+	var seq uint64
+	var lastHash []byte
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64 * 1024), MaxSRDBodySize)
+	for scanner.Scan() && seq < head.Seq {
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("record %v is not valid JSON: %v", seq + 1, err)
+		}
+		hash, err := record.computeHash()
+		if err != nil {
+			return err
+		}
+		if record.Seq != seq + 1 || !bytes.Equal(record.PrevHash, lastHash) || !bytes.Equal(record.Hash, hash) {
+			return fmt.Errorf("record %v does not chain to the record before it", seq + 1)
+		}
+		seq, lastHash = record.Seq, record.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+	if seq != head.Seq || !bytes.Equal(lastHash, head.Hash) {
+		return fmt.Errorf("audit log does not hold record %v with the hash of the head", head.Seq)
+	}
+	return nil
+}
+
+// Returns the latest record of the log, nil when it holds none
+func (a *AuditLog) Head() *AuditHead {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.seq == 0 {
+		return nil
+	}
+	return &AuditHead{Seq: a.seq, Hash: a.lastHash}
+}
+
+// Appends a reject of an SRD whose poster was not authenticated yet. Anyone can send those, so only one is
+// written per AuditUnauthenticatedInterval; the next one written counts the rejects left out in Suppressed
+func (a *AuditLog) AppendUnauthenticated(record *AuditRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	if now.Sub(a.lastUnauthenticated) < AuditUnauthenticatedInterval {
+		a.suppressed++
+		return nil
+	}
+	record.Suppressed = a.suppressed
+	if err := a.append(record); err != nil {
+		return err
+	}
+	a.lastUnauthenticated, a.suppressed = now, 0
+	return nil
+}
+
+// Chains record to the log and writes it to disk, filling in Seq, Time, PrevHash and Hash
+func (a *AuditLog) Append(record *AuditRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.append(record)
+}
+
+//caller must hold mu
+func (a *AuditLog) append(record *AuditRecord) error {
+	if a.file == nil {
+		return fmt.Errorf("audit log is closed")
+	}
+	record.Seq = a.seq + 1
+	if record.Time.IsZero() {
+		record.Time = time.Now().UTC()
+	}
+	record.PrevHash = a.lastHash
+	hash, err := record.computeHash()
+	if err != nil {
+		return err
+	}
+	record.Hash = hash
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err := a.file.Write(line); err != nil {
+		return a.rollBack(fmt.Errorf("failed to write audit record: %w", err))
+	}
+	if err := a.file.Sync(); err != nil {
+		return a.rollBack(fmt.Errorf("failed to sync audit log: %w", err))
+	}
+	a.size += int64(len(line))
+	a.seq, a.lastHash = record.Seq, hash
+	return nil
+}
+
+//cuts a partly written record off the file so the next record starts on a line of its own, and returns err.
+//If that fails too the log is closed, OpenAuditLog cuts the record off when it is opened again. Caller must hold mu
+func (a *AuditLog) rollBack(err error) error {
+	if truncErr := a.file.Truncate(a.size); truncErr != nil {
+		glog.Errorf("failed to cut the failed record off the audit log, closing it: %v", truncErr)
+		a.file.Close()
+		a.file = nil
+	}
+	return err
+}
+
+// Closes the file of the audit log, records appended after fail
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
+
+//describes who sent req for the audit log: the remote address and the subject of the client certificate, if any
+func describeCaller(req *http.Request) string {
+	if req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+		return fmt.Sprintf("%v (%v)", req.RemoteAddr, req.TLS.PeerCertificates[0].Subject.String())
+	}
+	return req.RemoteAddr
+}
+
+//counts the outcome of the CA signed SRD data and writes it to the audit log, if the logger has one.
+//logSRD is the SRD the logger signed for it with the key keyID when err is nil.
+//Returns an error if a record could not be written, the logger then does not sign the SRD, see updateLogSRDWithRevDataFrom
+func (this *Logger) recordSRD(data *mtr.SRDWithRevData, logSRD *mtr.SRDWithRevData, keyID, caller string, err error) error {
+	this.metrics.countSRD(data.SRD.EntityID, err)
+	if this.audit == nil {
+		return nil
+	}
+	for _, r := range this.auditRecords(data, logSRD, keyID, caller, err) {
+		if err := this.audit.Append(r); err != nil {
+			glog.Errorf("failed to write %v of SRD of CA (%v) to the audit log of logger (%v): %v", r.Event, r.CAID, this.LogID, err)
+			return err
+		}
+	}
+	return nil
+}
+
+//counts an SRD rejected before its poster was authenticated and writes it to the audit log at a limited rate, see AppendUnauthenticated
+func (this *Logger) recordUnauthenticated(data *mtr.SRDWithRevData, caller string, err error) {
+	this.metrics.countSRD(data.SRD.EntityID, err)
+	if this.audit == nil {
+		return
+	}
+	for _, r := range this.auditRecords(data, nil, "", caller, err) {
+		if err := this.audit.AppendUnauthenticated(r); err != nil {
+			glog.Errorf("failed to write %v of SRD of CA (%v) to the audit log of logger (%v): %v", r.Event, r.CAID, this.LogID, err)
+		}
+	}
+}
+
+//the records describing the outcome of the CA signed SRD data: a reject when err is set, otherwise an accept and a sign
+func (this *Logger) auditRecords(data *mtr.SRDWithRevData, logSRD *mtr.SRDWithRevData, keyID, caller string, err error) []*AuditRecord {
+	record := func(level, event string, srd *mtr.SRDWithRevData) *AuditRecord {
+		return &AuditRecord{
+			Level:			level,
+			Event:			event,
+			LogID:			this.LogID,
+			CAID:			data.SRD.EntityID,
+			RevocationType:	srd.RevData.RevocationType,
+			Timestamp:		srd.SRD.RevDigest.Timestamp,
+			CRVHash:		srd.SRD.RevDigest.CRVHash,
+			CRVDeltaHash:	srd.SRD.RevDigest.CRVDeltaHash,
+			Signature:		srd.SRD.Signature.Signature,
+			Caller:			caller,
+		}
+	}
+	if err != nil {
+		rejected := record(AuditLevelWarning, AuditReject, data)
+		rejected.Reason = AsError(err).Code
+		rejected.Message = err.Error()
+		return []*AuditRecord{rejected}
+	}
+	signed := record(AuditLevelInfo, AuditSign, logSRD)
+	signed.KeyID = keyID
+	return []*AuditRecord{record(AuditLevelInfo, AuditAccept, data), signed}
+}
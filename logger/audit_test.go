@@ -0,0 +1,254 @@
+package logger
+
+import (
+	"testing"
+	"bytes"
+	"errors"
+	"os"
+	"time"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	mtr "github.com/n-ct/ct-monitor"
+	"github.com/n-ct/ct-logger/internal/testutil"
+)
+
+//function that creates the test logger with an audit log in a temporary directory and returns the audit log file name
+func mustCreateLoggerWithAuditLog(t *testing.T) (*Logger, string) {
+	t.Helper()
+	configName := testutil.WriteConfig(t, config_filename, testutil.WithField("audit_log", "audit.log"))
+	logger, err := NewLogger(configName, caList_filename, logList_filename)
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+	t.Cleanup(func() { logger.audit.Close() })
+	return logger, filepath.Join(filepath.Dir(configName), "audit.log")
+}
+
+//function that reads the records of the audit log at fileName
+func mustReadAuditRecords(t *testing.T, fileName string) []AuditRecord {
+	t.Helper()
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	var records []AuditRecord
+	for _, line := range bytes.Split(bytes.TrimSpace(content), []byte("\n")) {
+		var record AuditRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("audit record %s is not JSON: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAuditLog(t *testing.T) {
+	logger, fileName := mustCreateLoggerWithAuditLog(t)
+	res := httptest.NewRecorder()
+	logger.OnPostLogSRDWithRevData(res, newPostRequest(mustCreateCASRDBody(t)))
+	if res.Code != 200 {
+		t.Fatalf("post failed with %v: %s", res.Code, res.Body.Bytes())
+	}
+	res = httptest.NewRecorder()
+	logger.OnPostLogSRDWithRevData(res, newPostRequest(mustCreateModifiedCASRDBody(t, func(data *mtr.SRDWithRevData) {
+		data.SRD.RevDigest.Timestamp++ //invalidates the CA signature
+	})))
+
+	records := mustReadAuditRecords(t, fileName)
+	if len(records) != 3 {
+		t.Fatalf("expected accept, sign and reject records, got %+v", records)
+	}
+	accepted, signed, rejected := records[0], records[1], records[2]
+	if accepted.Event != AuditAccept || accepted.CAID != ca_id || accepted.Caller == "" || len(accepted.CRVHash) == 0 || len(accepted.Signature) == 0 {
+		t.Fatalf("accept record should describe the CA SRD and its caller, got %+v", accepted)
+	}
+	if signed.Event != AuditSign || signed.KeyID != logger.Keys[0].KeyID || bytes.Equal(signed.Signature, accepted.Signature) {
+		t.Fatalf("sign record should hold the logger signature and key, got %+v", signed)
+	}
+	if rejected.Event != AuditReject || rejected.Level != AuditLevelWarning || rejected.Reason != ErrorCodeInvalidSignature {
+		t.Fatalf("reject record should hold the error code, got %+v", rejected)
+	}
+	if !bytes.Equal(signed.PrevHash, accepted.Hash) || !bytes.Equal(rejected.PrevHash, signed.Hash) {
+		t.Fatalf("records are not chained")
+	}
+
+	//reopening continues the chain
+	logger.audit.Close()
+	audit, err := OpenAuditLog(fileName)
+	if err != nil {
+		t.Fatalf("failed to reopen audit log: %v", err)
+	}
+	if err := audit.Append(&AuditRecord{Level: AuditLevelInfo, Event: AuditAccept, LogID: logger.LogID}); err != nil {
+		t.Fatalf("failed to append to audit log: %v", err)
+	}
+	audit.Close()
+	records = mustReadAuditRecords(t, fileName)
+	if len(records) != 4 || records[3].Seq != 4 || !bytes.Equal(records[3].PrevHash, rejected.Hash) {
+		t.Fatalf("appended record should continue the chain, got %+v", records[len(records) - 1])
+	}
+}
+
+func TestAuditLogDetectsTampering(t *testing.T) {
+	logger, fileName := mustCreateLoggerWithAuditLog(t)
+	logger.OnPostLogSRDWithRevData(httptest.NewRecorder(), newPostRequest(mustCreateCASRDBody(t)))
+	logger.audit.Close()
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	lines := bytes.SplitAfter(content, []byte("\n"))
+
+	tests := []struct {
+		name	string
+		content	[]byte
+	}{
+		{"changed record", bytes.Replace(content, []byte(`"event":"accept"`), []byte(`"event":"reject"`), 1)},
+		{"dropped record", bytes.Join(lines[1:], nil)},
+		{"reordered records", bytes.Join([][]byte{lines[1], lines[0]}, nil)},
+	}
+	if _, _, err := VerifyAuditLog(bytes.NewReader(content)); err != nil {
+		t.Fatalf("untouched audit log should verify: %v", err)
+	}
+	for _, test := range tests {
+		if _, _, err := VerifyAuditLog(bytes.NewReader(test.content)); err == nil {
+			t.Fatalf("%v: tampered audit log should not verify", test.name)
+		}
+	}
+	if err := ioutil.WriteFile(fileName, tests[0].content, 0600); err != nil {
+		t.Fatalf("failed to write audit log: %v", err)
+	}
+	if _, err := OpenAuditLog(fileName); err == nil {
+		t.Fatalf("tampered audit log should not be opened for appending")
+	}
+}
+
+func TestAuditLogCutsIncompleteRecord(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := OpenAuditLog(fileName)
+	if err != nil {
+		t.Fatalf("failed to open audit log: %v", err)
+	}
+	if err := audit.Append(&AuditRecord{Level: AuditLevelInfo, Event: AuditAccept}); err != nil {
+		t.Fatalf("failed to append to audit log: %v", err)
+	}
+	audit.Close()
+	content, _ := ioutil.ReadFile(fileName)
+	if err := ioutil.WriteFile(fileName, append(content, []byte(`{"seq":2,"time":`)...), 0600); err != nil {
+		t.Fatalf("failed to write audit log: %v", err)
+	}
+
+	audit, err = OpenAuditLog(fileName)
+	if err != nil {
+		t.Fatalf("audit log with an incomplete last record should open: %v", err)
+	}
+	defer audit.Close()
+	if err := audit.Append(&AuditRecord{Level: AuditLevelInfo, Event: AuditSign}); err != nil {
+		t.Fatalf("failed to append to audit log: %v", err)
+	}
+	records := mustReadAuditRecords(t, fileName)
+	if len(records) != 2 || records[1].Event != AuditSign {
+		t.Fatalf("incomplete record should be replaced by the next one, got %+v", records)
+	}
+}
+
+//file that writes only half of what it is given and fails
+type shortWriteFile struct {
+	*os.File
+}
+
+func (f shortWriteFile) Write(p []byte) (int, error) {
+	n, _ := f.File.Write(p[:len(p) / 2])
+	return n, errors.New("disk full")
+}
+
+func TestAuditLogRollsBackFailedWrite(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := OpenAuditLog(fileName)
+	if err != nil {
+		t.Fatalf("failed to open audit log: %v", err)
+	}
+	defer audit.Close()
+	if err := audit.Append(&AuditRecord{Level: AuditLevelInfo, Event: AuditAccept}); err != nil {
+		t.Fatalf("failed to append to audit log: %v", err)
+	}
+	file := audit.file
+	audit.file = shortWriteFile{file.(*os.File)}
+	if err := audit.Append(&AuditRecord{Level: AuditLevelInfo, Event: AuditSign}); err == nil {
+		t.Fatalf("failed write should be reported")
+	}
+	audit.file = file
+	if err := audit.Append(&AuditRecord{Level: AuditLevelInfo, Event: AuditReject}); err != nil {
+		t.Fatalf("failed to append after a failed write: %v", err)
+	}
+	records := mustReadAuditRecords(t, fileName)
+	if len(records) != 2 || records[1].Seq != 2 || records[1].Event != AuditReject {
+		t.Fatalf("failed record should be cut off the audit log, got %+v", records)
+	}
+}
+
+func TestAuditLogLimitsUnauthenticatedRejects(t *testing.T) {
+	logger, fileName := mustCreateLoggerWithAuditLog(t)
+	malformed := func() {
+		logger.OnPostLogSRDWithRevData(httptest.NewRecorder(), newPostRequest(mustCreateModifiedCASRDBody(t, func(data *mtr.SRDWithRevData) {
+			data.SRD.Signature.Signature = nil
+		})))
+	}
+	for i := 0; i < 5; i++ {
+		malformed()
+	}
+	if records := mustReadAuditRecords(t, fileName); len(records) != 1 {
+		t.Fatalf("expected one record of the unauthenticated rejects, got %v", len(records))
+	}
+
+	//the next one written after the interval counts the ones left out
+	logger.audit.lastUnauthenticated = time.Now().Add(-AuditUnauthenticatedInterval)
+	malformed()
+	records := mustReadAuditRecords(t, fileName)
+	if len(records) != 2 || records[1].Event != AuditReject || records[1].Suppressed != 4 {
+		t.Fatalf("second reject record should count 4 suppressed rejects, got %+v", records)
+	}
+	//SRDs of authenticated posters are all recorded
+	logger.OnPostLogSRDWithRevData(httptest.NewRecorder(), newPostRequest(mustCreateCASRDBody(t)))
+	if records := mustReadAuditRecords(t, fileName); len(records) != 4 {
+		t.Fatalf("accept and sign records should not be limited, got %v records", len(records))
+	}
+}
+
+func TestAuditHeadInStateArchive(t *testing.T) {
+	logger, fileName := mustCreateLoggerWithAuditLog(t)
+	logger.OnPostLogSRDWithRevData(httptest.NewRecorder(), newPostRequest(mustCreateCASRDBody(t)))
+	archive, err := logger.ExportState()
+	if err != nil {
+		t.Fatalf("failed to export state: %v", err)
+	}
+	head := archive.State.AuditHead
+	records := mustReadAuditRecords(t, fileName)
+	if head == nil || head.Seq != 2 || !bytes.Equal(head.Hash, records[1].Hash) {
+		t.Fatalf("archive should hold the head of the audit log, got %+v", head)
+	}
+	logger.audit.Append(&AuditRecord{Level: AuditLevelInfo, Event: AuditAccept, LogID: logger.LogID})
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	if err := VerifyAuditLogHead(bytes.NewReader(content), head); err != nil {
+		t.Fatalf("audit log should hold the archived head: %v", err)
+	}
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	if err := VerifyAuditLogHead(bytes.NewReader(bytes.Join(lines[1:], nil)), head); err == nil {
+		t.Fatalf("audit log without its first record should not hold the archived head")
+	}
+}
+
+func TestSigningFailsClosedOnAuditError(t *testing.T) {
+	logger, _ := mustCreateLoggerWithAuditLog(t)
+	logger.audit.Close()
+	if err := logger.UpdateLogSRDWithRevData(testutil.CreateCASRD(t, []uint64{1,3}, []uint64{1,3}, time.Now())); !errors.Is(err, ErrInternal) {
+		t.Fatalf("SRD should not be signed when the audit log fails, got %v", err)
+	}
+	if len(logger.CurrentCRVMap) != 0 || logger.LogSRDWithRevDataMap != nil || len(logger.SRDHistory) != 0 {
+		t.Fatalf("SRD that failed to be audited should leave the logger unchanged")
+	}
+}
@@ -0,0 +1,229 @@
+package logger
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/golang/glog"
+	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/certificate-transparency-go/tls"
+	"github.com/n-ct/ct-monitor/signature"
+)
+
+// Modes for authenticating the caller that posts a CA's SRD, set with ca_auth_mode in the logger config
+const (
+	CAAuthNone		= "none"		// only the SRD signature and the CA allowlist are checked
+	CAAuthMTLS		= "mtls"		// the caller must present a verified client certificate bound to the CA
+	CAAuthSignature	= "signature"	// the request must carry a signature made with the CA's key
+	CAAuthAny		= "any"			// either a client certificate or a request signature is accepted
+)
+
+// Headers carrying the signed request envelope
+const (
+	RequestTimestampHeader	= "X-CT-Request-Timestamp"
+	RequestSignatureHeader	= "X-CT-Request-Signature"
+	// Maximum difference between the request timestamp and the logger's clock
+	MaxRequestSkew			= 5 * time.Minute
+)
+
+// RequestSigningInput is what a CA signs to authenticate a request to the logger
+type RequestSigningInput struct {
+	Method		string
+	Path		string
+	Timestamp	uint64
+	BodyHash	[]byte
+}
+
+func validCAAuthMode(mode string) bool {
+	switch mode {
+	case CAAuthNone, CAAuthMTLS, CAAuthSignature, CAAuthAny:
+		return true
+	}
+	return false
+}
+
+// Sign the request with the given CA signer by setting the request envelope headers.
+// body must be the exact bytes sent as the request body
+func SignRequest(req *http.Request, body []byte, signer *signature.Signer) error {
+	timestamp := uint64(time.Now().Unix())
+	bodyHash := sha256.Sum256(body)
+	input := RequestSigningInput{req.Method, req.URL.Path, timestamp, bodyHash[:]}
+	sig, err := signer.CreateSignature(tls.SHA256, input)
+	if err != nil {
+		return fmt.Errorf("failed to sign request: %v", err)
+	}
+	sigB64, err := sig.Base64String()
+	if err != nil {
+		return fmt.Errorf("failed to encode request signature: %v", err)
+	}
+	req.Header.Set(RequestTimestampHeader, strconv.FormatUint(timestamp, 10))
+	req.Header.Set(RequestSignatureHeader, sigB64)
+	return nil
+}
+
+// Check that the caller of req is allowed to post SRDs of the CA with the given ID.
+// body is the request body the caller sent. A CA missing from the ca list is reported as ErrorCodeUnknownCA
+func (this *Logger) authenticateCAPoster(req *http.Request, body []byte, caID string) error {
+	this.RLock()
+	mode := this.CAAuthMode
+	known := this.CAList.FindCAByCAID(caID) != nil
+	this.RUnlock()
+	if !known {
+		return NewError(ErrorCodeUnknownCA, nil, "caID (%v) not found in caInfoMap", caID).With("ca_id", caID)
+	}
+	if !this.isAllowedCA(caID) {
+		return NewError(ErrorCodeCANotAllowed, nil, "caID (%v) is not allowed to post to this logger", caID).With("ca_id", caID)
+	}
+
+	switch mode {
+	case CAAuthNone, "":
+		return nil
+	case CAAuthMTLS:
+		return this.verifyClientCert(req, caID)
+	case CAAuthSignature:
+		return this.verifyRequestSignature(req, body, caID)
+	case CAAuthAny:
+		certErr := this.verifyClientCert(req, caID)
+		if certErr == nil {
+			return nil
+		}
+		sigErr := this.verifyRequestSignature(req, body, caID)
+		if sigErr == nil {
+			return nil
+		}
+		return fmt.Errorf("%v; %v", certErr, sigErr)
+	}
+	return fmt.Errorf("unknown ca_auth_mode %q", mode)
+}
+
+// Returns true if caID is in the logger's CAIDs allowlist
+func (this *Logger) isAllowedCA(caID string) bool {
+	this.RLock()
+	defer this.RUnlock()
+	return this.allowsCA(caID)
+}
+
+// Same as isAllowedCA for callers that already hold the lock
+func (this *Logger) allowsCA(caID string) bool {
+	for _, id := range this.CAIDs {
+		if id == caID {
+			return true
+		}
+	}
+	return false
+}
+
+// Check that the caller of req is an operator, the only callers that may export or import the logger state.
+// An operator presents a client certificate listed in operator_cert_ids or signs the request with a key of operator_keys.
+// Nobody is an operator unless the config lists one, the state endpoints are not served to unauthenticated callers
+func (this *Logger) authenticateOperator(req *http.Request, body []byte) error {
+	this.RLock()
+	keys, certIDs := this.OperatorKeys, this.OperatorCertIDs
+	this.RUnlock()
+	if len(keys) == 0 && len(certIDs) == 0 {
+		return fmt.Errorf("no operator_keys or operator_cert_ids are configured")
+	}
+	certID, certErr := clientCertID(req)
+	if certErr == nil {
+		for _, id := range certIDs {
+			if id == certID {
+				return nil
+			}
+		}
+		certErr = fmt.Errorf("client certificate (%v) is not an operator certificate", certID)
+	}
+	input, sig, sigErr := parseRequestSignature(req, body)
+	if sigErr == nil {
+		for _, key := range keys {
+			if signature.VerifySignature(key, *input, *sig) == nil {
+				return nil
+			}
+		}
+		sigErr = fmt.Errorf("request signature does not match an operator key")
+	}
+	return fmt.Errorf("%v; %v", certErr, sigErr)
+}
+
+// Returns the base64 SHA-256 of the public key of the verified client certificate of req
+func clientCertID(req *http.Request) (string, error) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return "", fmt.Errorf("no verified client certificate")
+	}
+	spkiHash := sha256.Sum256(req.TLS.VerifiedChains[0][0].RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(spkiHash[:]), nil
+}
+
+// The client certificate is bound to the CA if the SHA-256 of its public key is the CA ID, meaning it uses the CA key,
+// or if it is one of the certificate IDs configured for the CA in ca_client_cert_ids
+func (this *Logger) verifyClientCert(req *http.Request, caID string) error {
+	certID, err := clientCertID(req)
+	if err != nil {
+		return err
+	}
+	if certID == caID {
+		return nil
+	}
+	this.RLock()
+	defer this.RUnlock()
+	for _, id := range this.CAClientCertIDs[caID] {
+		if id == certID {
+			return nil
+		}
+	}
+	return fmt.Errorf("client certificate (%v) is not bound to caID (%v)", certID, caID)
+}
+
+// Returns what the caller signed for req and its signature, checking the timestamp against the logger's clock
+func parseRequestSignature(req *http.Request, body []byte) (*RequestSigningInput, *ct.DigitallySigned, error) {
+	timestampHeader := req.Header.Get(RequestTimestampHeader)
+	sigHeader := req.Header.Get(RequestSignatureHeader)
+	if timestampHeader == "" || sigHeader == "" {
+		return nil, nil, fmt.Errorf("no request signature")
+	}
+	timestamp, err := strconv.ParseUint(timestampHeader, 10, 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid request timestamp: %v", err)
+	}
+	skew := time.Since(time.Unix(int64(timestamp), 0))
+	if skew > MaxRequestSkew || skew < -MaxRequestSkew {
+		return nil, nil, fmt.Errorf("request timestamp is %v away from the logger's clock", skew)
+	}
+	var sig ct.DigitallySigned
+	if err := sig.FromBase64String(sigHeader); err != nil {
+		return nil, nil, fmt.Errorf("invalid request signature: %v", err)
+	}
+	bodyHash := sha256.Sum256(body)
+	return &RequestSigningInput{req.Method, req.URL.Path, timestamp, bodyHash[:]}, &sig, nil
+}
+
+func (this *Logger) verifyRequestSignature(req *http.Request, body []byte, caID string) error {
+	input, sig, err := parseRequestSignature(req, body)
+	if err != nil {
+		return err
+	}
+
+	this.RLock()
+	caInfo := this.CAList.FindCAByCAID(caID)
+	this.RUnlock()
+	if caInfo == nil {
+		return fmt.Errorf("caID (%v) not found in caInfoMap", caID)
+	}
+	if err := signature.VerifySignature(caInfo.CAKey, *input, *sig); err != nil {
+		return fmt.Errorf("request signature does not match caID (%v): %v", caID, err)
+	}
+	return nil
+}
+
+// Log a rejected caller with enough detail to find it
+func logRejectedCaller(req *http.Request, caID string, err error) {
+	glog.Warningf("rejected %v %v from %v for caID (%v): %v", req.Method, req.URL.Path, describeCaller(req), caID, err)
+}
+
+// Same as logRejectedCaller for callers of the operator endpoints
+func logRejectedOperator(req *http.Request, err error) {
+	glog.Warningf("rejected %v %v from %v, not an operator: %v", req.Method, req.URL.Path, describeCaller(req), err)
+}
@@ -0,0 +1,206 @@
+package logger
+
+import (
+	"testing"
+	"bytes"
+	"time"
+	"encoding/base64"
+	"encoding/json"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	mtr "github.com/n-ct/ct-monitor"
+	"github.com/n-ct/ct-monitor/signature"
+	"github.com/n-ct/ct-logger/internal/testutil"
+)
+
+const (
+	ca_key					string = "MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEmFk6QT48Ts4oxSkBPM4mQ/mnWICKVmZUP6urQVBH0vhDzJVYHc2ShvF2KjWzorVu2C+tY6lIU+61iiPLsGvZXw=="
+	operator_key			string = "MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEJbGtvYT9k5HIV+3d7UeC1/5d+SNqOxrgYC1crNx7RH6nOLDZt0JjFSLTFO/CSS8ezkj8RYntgP1/ngfOAi1Nmg=="
+	operator_private_key	string = "MHcCAQEEIGzYeqJRHAw+d1Kk/toFvQVX0vrwl0Z3uiPYsThWh1rHoAoGCCqGSM49AwEHoUQDQgAEJbGtvYT9k5HIV+3d7UeC1/5d+SNqOxrgYC1crNx7RH6nOLDZt0JjFSLTFO/CSS8ezkj8RYntgP1/ngfOAi1Nmg=="
+)
+
+//function that returns the JSON of a CA signed SRDWithRevData revoking 1 and 3
+func mustCreateCASRDBody(t *testing.T) []byte {
+	t.Helper()
+	body, err := json.Marshal(testutil.CreateCASRD(t, []uint64{1,3}, []uint64{1,3}, time.Now()))
+	if err != nil {
+		t.Fatalf("failed to marshal CA SRD: %v", err)
+	}
+	return body
+}
+
+//function that posts body to the logger and returns the status code
+func mustPostSRD(t *testing.T, logger *Logger, req *http.Request) int {
+	t.Helper()
+	res := httptest.NewRecorder()
+	logger.OnPostLogSRDWithRevData(res, req)
+	return res.Code
+}
+
+func newPostRequest(body []byte) *http.Request {
+	return httptest.NewRequest("POST", PostLogSRDWithRevDataPath, bytes.NewReader(body))
+}
+
+func TestCAAllowlist(t *testing.T) {
+	logger, _ := mustCreateLogger(t)
+	logger.CAIDs = []string{}
+	if code := mustPostSRD(t, logger, newPostRequest(mustCreateCASRDBody(t))); code != http.StatusForbidden {
+		t.Fatalf("SRD of CA outside of CAIDs should be rejected with %v not %v", http.StatusForbidden, code)
+	}
+	var data mtr.SRDWithRevData
+	json.Unmarshal(mustCreateCASRDBody(t), &data)
+	if err := logger.UpdateLogSRDWithRevData(&data); err == nil {
+		t.Fatalf("logger accepted SRD of CA outside of CAIDs")
+	}
+}
+
+func TestSignedRequestAuthentication(t *testing.T) {
+	logger, _ := mustCreateLogger(t)
+	logger.CAAuthMode = CAAuthSignature
+	signer, _ := mustCreateSigner(t)
+	body := mustCreateCASRDBody(t)
+
+	if code := mustPostSRD(t, logger, newPostRequest(body)); code != http.StatusUnauthorized {
+		t.Fatalf("unsigned request should be rejected with %v not %v", http.StatusUnauthorized, code)
+	}
+
+	//a signature over a different body must not be accepted
+	req := newPostRequest(body)
+	SignRequest(req, []byte("{}"), signer)
+	if code := mustPostSRD(t, logger, req); code != http.StatusUnauthorized {
+		t.Fatalf("request signed over another body should be rejected with %v not %v", http.StatusUnauthorized, code)
+	}
+
+	req = newPostRequest(body)
+	SignRequest(req, body, signer)
+	req.Header.Set(RequestTimestampHeader, "1")
+	if code := mustPostSRD(t, logger, req); code != http.StatusUnauthorized {
+		t.Fatalf("request with old timestamp should be rejected with %v not %v", http.StatusUnauthorized, code)
+	}
+
+	req = newPostRequest(body)
+	if err := SignRequest(req, body, signer); err != nil {
+		t.Fatalf("failed to sign request: %v", err)
+	}
+	if code := mustPostSRD(t, logger, req); code != http.StatusOK {
+		t.Fatalf("signed request should be accepted not rejected with %v", code)
+	}
+}
+
+func TestClientCertAuthentication(t *testing.T) {
+	logger, _ := mustCreateLogger(t)
+	logger.CAAuthMode = CAAuthMTLS
+	body := mustCreateCASRDBody(t)
+	withCert := func(spki []byte) *http.Request {
+		req := newPostRequest(body)
+		cert := &x509.Certificate{RawSubjectPublicKeyInfo: spki}
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+		return req
+	}
+
+	if code := mustPostSRD(t, logger, newPostRequest(body)); code != http.StatusUnauthorized {
+		t.Fatalf("request without client certificate should be rejected with %v not %v", http.StatusUnauthorized, code)
+	}
+	if code := mustPostSRD(t, logger, withCert([]byte("some other key"))); code != http.StatusUnauthorized {
+		t.Fatalf("client certificate not bound to the CA should be rejected with %v not %v", http.StatusUnauthorized, code)
+	}
+
+	//a certificate for the CA key itself is bound to the CA ID
+	caSPKI, _ := base64.StdEncoding.DecodeString(ca_key)
+	if code := mustPostSRD(t, logger, withCert(caSPKI)); code != http.StatusOK {
+		t.Fatalf("client certificate with the CA key should be accepted not rejected with %v", code)
+	}
+}
+
+//function that sends req to the state endpoint of the logger it is for and returns the status code
+func mustServeState(t *testing.T, logger *Logger, req *http.Request) int {
+	t.Helper()
+	res := httptest.NewRecorder()
+	if req.Method == http.MethodGet {
+		logger.OnGetState(res, req)
+	} else {
+		logger.OnPostState(res, req)
+	}
+	return res.Code
+}
+
+func TestStateRequiresOperator(t *testing.T) {
+	logger := mustCreateLoggerWithState(t)
+	operator, err := signature.NewSigner(operator_private_key)
+	if err != nil {
+		t.Fatalf("failed to create operator signer: %v", err)
+	}
+	caSigner, _ := mustCreateSigner(t)
+	signedGet := func(signer *signature.Signer) *http.Request {
+		req := httptest.NewRequest("GET", GetStatePath, nil)
+		if err := SignRequest(req, nil, signer); err != nil {
+			t.Fatalf("failed to sign request: %v", err)
+		}
+		return req
+	}
+
+	//without operators configured nobody may read the state
+	if code := mustServeState(t, logger, signedGet(operator)); code != http.StatusUnauthorized {
+		t.Fatalf("state should not be served without configured operators, got %v", code)
+	}
+	logger.OperatorKeys = []string{operator_key}
+	if code := mustServeState(t, logger, httptest.NewRequest("GET", GetStatePath, nil)); code != http.StatusUnauthorized {
+		t.Fatalf("unsigned request should be rejected with %v not %v", http.StatusUnauthorized, code)
+	}
+	if code := mustServeState(t, logger, signedGet(caSigner)); code != http.StatusUnauthorized {
+		t.Fatalf("request signed by a CA should be rejected with %v not %v", http.StatusUnauthorized, code)
+	}
+	if code := mustServeState(t, logger, signedGet(operator)); code != http.StatusOK {
+		t.Fatalf("request signed by an operator should be accepted not rejected with %v", code)
+	}
+
+	//an operator client certificate is accepted as well
+	logger.OperatorKeys = nil
+	operatorSPKI, _ := base64.StdEncoding.DecodeString(operator_key)
+	spkiHash := sha256.Sum256(operatorSPKI)
+	logger.OperatorCertIDs = []string{base64.StdEncoding.EncodeToString(spkiHash[:])}
+	req := httptest.NewRequest("GET", GetStatePath, nil)
+	cert := &x509.Certificate{RawSubjectPublicKeyInfo: operatorSPKI}
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+	if code := mustServeState(t, logger, req); code != http.StatusOK {
+		t.Fatalf("request with an operator certificate should be accepted not rejected with %v", code)
+	}
+}
+
+func TestPostStateRefusedWhileStatePending(t *testing.T) {
+	archive, err := mustCreateLoggerWithState(t).ExportState()
+	if err != nil {
+		t.Fatalf("failed to export state: %v", err)
+	}
+	body, _ := json.Marshal(archive)
+	operator, _ := signature.NewSigner(operator_private_key)
+	signedPost := func() *http.Request {
+		req := httptest.NewRequest("POST", PostStatePath, bytes.NewReader(body))
+		if err := SignRequest(req, body, operator); err != nil {
+			t.Fatalf("failed to sign request: %v", err)
+		}
+		return req
+	}
+
+	standby, _ := mustCreateLogger(t)
+	standby.OperatorKeys = []string{operator_key}
+	standby.AwaitState()
+	if code := mustServeState(t, standby, signedPost()); code != http.StatusConflict {
+		t.Fatalf("state should not be posted while the -state archive is imported, got %v", code)
+	}
+	if err := standby.ImportState(archive); err != nil {
+		t.Fatalf("failed to import state: %v", err)
+	}
+
+	empty, _ := mustCreateLogger(t)
+	empty.OperatorKeys = []string{operator_key}
+	if code := mustServeState(t, empty, signedPost()); code != http.StatusOK {
+		t.Fatalf("state posted by an operator should be imported, got %v", code)
+	}
+	if !empty.IsReady() || len(empty.SRDHistory) != len(archive.State.SRDHistory) {
+		t.Fatalf("posted state was not imported")
+	}
+}
@@ -0,0 +1,181 @@
+package logger
+
+import (
+	"fmt"
+	"errors"
+	"net/http"
+	"encoding/json"
+	"github.com/golang/glog"
+)
+
+//codes of the errors returned by the logger, sent as "code" in the JSON body of every failed request
+const (
+	ErrorCodeInvalidRequest		= "invalid_request" //body or parameters of the request are malformed
+	ErrorCodeMethodNotAllowed	= "method_not_allowed"
+	ErrorCodeTooLarge			= "request_too_large" //body is larger than the endpoint accepts, see MaxSRDBodySize
+	ErrorCodeUnsupportedMediaType	= "unsupported_media_type" //body is not application/json
+	ErrorCodeUnauthorized		= "unauthorized" //caller could not be authenticated as the CA it posts for or as an operator
+	ErrorCodeCANotAllowed		= "ca_not_allowed" //CA is in the ca list but not in the ca_ids of the logger
+	ErrorCodeUnknownCA			= "unknown_ca" //CA is not in the ca list
+	ErrorCodeInconsistentDelta	= "inconsistent_delta" //delta CRV applied to the current CRV does not match the CRV hash of the SRD
+	ErrorCodeStale				= "stale" //SRD is older than the one the logger holds for the CA
+	ErrorCodeConflict			= "conflict" //request conflicts with the state of the logger, e.g. importing state twice
+	ErrorCodeInvalidSignature	= "invalid_signature"
+	ErrorCodeInvalidCompression	= "invalid_compression" //delta CRV does not decompress
+	ErrorCodeOutsideInterval	= "outside_temporal_interval" //SRD timestamp is outside the temporal interval of the logger
+	ErrorCodeTimestampOutOfRange	= "timestamp_out_of_range" //SRD timestamp is older than the MMD of the CA or too far in the future
+	ErrorCodeNotSigning			= "not_signing" //logger is readonly, retired or rejected in the log list
+	ErrorCodeCAUnavailable		= "ca_unavailable" //forwarding a request to a CA failed
+	ErrorCodeNotReady			= "not_ready" //logger holds no SRDs yet
+	ErrorCodeFollower			= "follower" //logger replicates from a leader and takes no posts
+	ErrorCodeShuttingDown		= "shutting_down" //logger is draining before it stops, see Drain
+	ErrorCodeHistoryCompacted	= "history_compacted" //requested SRD updates were compacted away, see MaxSRDHistory
+	ErrorCodeInternal			= "internal_error"
+)
+
+var errorStatus = map[string] int{
+	ErrorCodeInvalidRequest:		http.StatusBadRequest,
+	ErrorCodeMethodNotAllowed:		http.StatusMethodNotAllowed,
+	ErrorCodeTooLarge:				http.StatusRequestEntityTooLarge,
+	ErrorCodeUnsupportedMediaType:	http.StatusUnsupportedMediaType,
+	ErrorCodeUnauthorized:			http.StatusUnauthorized,
+	ErrorCodeCANotAllowed:			http.StatusForbidden,
+	ErrorCodeNotSigning:			http.StatusForbidden,
+	ErrorCodeUnknownCA:				http.StatusNotFound,
+	ErrorCodeInconsistentDelta:		http.StatusConflict,
+	ErrorCodeStale:					http.StatusConflict,
+	ErrorCodeConflict:				http.StatusConflict,
+	ErrorCodeInvalidSignature:		http.StatusUnprocessableEntity,
+	ErrorCodeInvalidCompression:	http.StatusUnprocessableEntity,
+	ErrorCodeOutsideInterval:		http.StatusUnprocessableEntity,
+	ErrorCodeTimestampOutOfRange:	http.StatusUnprocessableEntity,
+	ErrorCodeCAUnavailable:			http.StatusBadGateway,
+	ErrorCodeNotReady:				http.StatusServiceUnavailable,
+	ErrorCodeFollower:				http.StatusServiceUnavailable,
+	ErrorCodeShuttingDown:			http.StatusServiceUnavailable,
+	ErrorCodeHistoryCompacted:		http.StatusGone,
+	ErrorCodeInternal:				http.StatusInternalServerError,
+}
+
+// Error is a failure of the logger with a machine-readable code, see ErrorCodeInvalidRequest.
+// Details hold the values the failure is about, e.g. the CA ID, Err the cause if there is one
+type Error struct {
+	Code	string
+	Message	string
+	Details	map[string]string
+	Err		error
+}
+
+// Sentinels of the failures of the logger, one per code. errors.Is(err, ErrUnknownCA) reports whether err
+// holds an Error with that code, errors.As with an *Error gives its message, details and cause.
+// The sentinels are shared, use NewError and With to create an Error rather than changing one
+var (
+	ErrInvalidRequest		= &Error{Code: ErrorCodeInvalidRequest, Message: "invalid request"}
+	ErrMethodNotAllowed		= &Error{Code: ErrorCodeMethodNotAllowed, Message: "method not allowed"}
+	ErrTooLarge				= &Error{Code: ErrorCodeTooLarge, Message: "request too large"}
+	ErrUnsupportedMediaType	= &Error{Code: ErrorCodeUnsupportedMediaType, Message: "unsupported media type"}
+	ErrUnauthorized			= &Error{Code: ErrorCodeUnauthorized, Message: "unauthorized"}
+	ErrCANotAllowed			= &Error{Code: ErrorCodeCANotAllowed, Message: "CA not allowed"}
+	ErrUnknownCA			= &Error{Code: ErrorCodeUnknownCA, Message: "unknown CA"}
+	ErrInconsistentDelta	= &Error{Code: ErrorCodeInconsistentDelta, Message: "inconsistent delta CRV"}
+	ErrStale				= &Error{Code: ErrorCodeStale, Message: "stale SRD"}
+	ErrConflict				= &Error{Code: ErrorCodeConflict, Message: "conflict"}
+	ErrBadSignature			= &Error{Code: ErrorCodeInvalidSignature, Message: "invalid signature"}
+	ErrBadCompression		= &Error{Code: ErrorCodeInvalidCompression, Message: "invalid compression"}
+	ErrOutsideInterval		= &Error{Code: ErrorCodeOutsideInterval, Message: "outside temporal interval"}
+	ErrTimestampOutOfRange	= &Error{Code: ErrorCodeTimestampOutOfRange, Message: "timestamp out of range"}
+	ErrNotSigning			= &Error{Code: ErrorCodeNotSigning, Message: "not signing"}
+	ErrCAUnavailable		= &Error{Code: ErrorCodeCAUnavailable, Message: "CA unavailable"}
+	ErrNotReady				= &Error{Code: ErrorCodeNotReady, Message: "not ready"}
+	ErrFollower				= &Error{Code: ErrorCodeFollower, Message: "follower"}
+	ErrShuttingDown			= &Error{Code: ErrorCodeShuttingDown, Message: "shutting down"}
+	ErrHistoryCompacted		= &Error{Code: ErrorCodeHistoryCompacted, Message: "history compacted"}
+	ErrInternal				= &Error{Code: ErrorCodeInternal, Message: "internal error"}
+)
+
+// Creates an Error with code, wrapping err when it is not nil
+func NewError(code string, err error, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...), Err: err}
+}
+
+// Returns a copy of the error with the detail added, e is left unchanged so With is safe on the sentinels
+func (e *Error) With(key, value string) *Error {
+	copied := *e
+	copied.Details = make(map[string]string, len(e.Details)+1)
+	for k, v := range e.Details {
+		copied.Details[k] = v
+	}
+	copied.Details[key] = value
+	return &copied
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%v: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Errors with the same code match, so errors.Is(err, ErrUnknownCA) holds for every unknown CA error
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// HTTP status code of the error, 500 for unknown codes
+func (e *Error) Status() int {
+	if status, ok := errorStatus[e.Code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// JSON body of a failed request
+type ErrorResponse struct {
+	Code	string				`json:"code"`
+	Message	string				`json:"message"`
+	Details	map[string]string	`json:"details,omitempty"`
+}
+
+// Returns the Error in the chain of err, errors without one are internal errors
+func AsError(err error) *Error {
+	var loggerErr *Error
+	if errors.As(err, &loggerErr) {
+		return loggerErr
+	}
+	return NewError(ErrorCodeInternal, err, "internal error")
+}
+
+// Writes err as an ErrorResponse with the status code of its Error. The message is the whole error chain,
+// except for internal errors which are only logged, so their causes are not shown to the caller
+func WriteError(res http.ResponseWriter, err error) {
+	loggerErr := AsError(err)
+	message := err.Error()
+	if loggerErr.Code == ErrorCodeInternal {
+		glog.Errorf("internal error: %v", err)
+		message = ErrInternal.Message
+	}
+	jsonBytes, marshalErr := json.Marshal(&ErrorResponse{Code: loggerErr.Code, Message: message, Details: loggerErr.Details})
+	if marshalErr != nil {
+		glog.Errorf("failed to Marshal error response: %v", marshalErr)
+		http.Error(res, message, loggerErr.Status())
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.WriteHeader(loggerErr.Status())
+	res.Write(jsonBytes)
+}
+
+// Parses the ErrorResponse of a failed request back into an Error, nil if body is not one
+func ParseErrorResponse(body []byte) *Error {
+	var response ErrorResponse
+	if err := json.Unmarshal(body, &response); err != nil || response.Code == "" {
+		return nil
+	}
+	return &Error{Code: response.Code, Message: response.Message, Details: response.Details}
+}
@@ -0,0 +1,189 @@
+package logger
+
+import (
+	"testing"
+	"errors"
+	"fmt"
+	"time"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	mtr "github.com/n-ct/ct-monitor"
+	"github.com/n-ct/ct-logger/internal/testutil"
+)
+
+//function that decodes the ErrorResponse written to res and checks its status and code
+func mustGetErrorResponse(t *testing.T, res *httptest.ResponseRecorder, status int, code string) *ErrorResponse {
+	t.Helper()
+	if res.Code != status {
+		t.Fatalf("expected status %v, got %v: %s", status, res.Code, res.Body.Bytes())
+	}
+	if contentType := res.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("error response should be JSON, got Content-Type %q", contentType)
+	}
+	var response ErrorResponse
+	if err := json.Unmarshal(res.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to unmarshal error response %s: %v", res.Body.Bytes(), err)
+	}
+	if response.Code != code || response.Message == "" {
+		t.Fatalf("expected code %v with a message, got %+v", code, response)
+	}
+	return &response
+}
+
+//function that returns the JSON of a CA signed SRD changed by modify after signing
+func mustCreateModifiedCASRDBody(t *testing.T, modify func(*mtr.SRDWithRevData)) []byte {
+	t.Helper()
+	var data mtr.SRDWithRevData
+	if err := json.Unmarshal(mustCreateCASRDBody(t), &data); err != nil {
+		t.Fatalf("failed to unmarshal CA SRD: %v", err)
+	}
+	modify(&data)
+	body, err := json.Marshal(&data)
+	if err != nil {
+		t.Fatalf("failed to marshal CA SRD: %v", err)
+	}
+	return body
+}
+
+func TestPostErrorResponses(t *testing.T) {
+	logger, _ := mustCreateLogger(t)
+
+	res := httptest.NewRecorder()
+	logger.OnPostLogSRDWithRevData(res, newPostRequest([]byte("{")))
+	mustGetErrorResponse(t, res, http.StatusBadRequest, ErrorCodeInvalidRequest)
+
+	res = httptest.NewRecorder()
+	logger.OnPostLogSRDWithRevData(res, newPostRequest(mustCreateModifiedCASRDBody(t, func(data *mtr.SRDWithRevData) {
+		data.SRD.EntityID = "unknown"
+		data.RevData.EntityID = "unknown"
+	})))
+	response := mustGetErrorResponse(t, res, http.StatusNotFound, ErrorCodeUnknownCA)
+	if response.Details["ca_id"] != "unknown" {
+		t.Fatalf("unknown CA error should name the CA, got %+v", response.Details)
+	}
+
+	res = httptest.NewRecorder()
+	logger.OnPostLogSRDWithRevData(res, newPostRequest(mustCreateModifiedCASRDBody(t, func(data *mtr.SRDWithRevData) {
+		data.SRD.RevDigest.Timestamp++ //invalidates the CA signature
+	})))
+	mustGetErrorResponse(t, res, http.StatusUnprocessableEntity, ErrorCodeInvalidSignature)
+
+	//the CA signs the hash of CRV {1} but sends the delta to CRV {3}
+	srd := testutil.CreateCASRD(t, []uint64{1}, []uint64{3}, time.Now())
+	body, _ := json.Marshal(srd)
+	res = httptest.NewRecorder()
+	logger.OnPostLogSRDWithRevData(res, newPostRequest(body))
+	mustGetErrorResponse(t, res, http.StatusConflict, ErrorCodeInconsistentDelta)
+
+	logger.setFollower(true)
+	res = httptest.NewRecorder()
+	logger.OnPostLogSRDWithRevData(res, newPostRequest(mustCreateCASRDBody(t)))
+	mustGetErrorResponse(t, res, http.StatusServiceUnavailable, ErrorCodeFollower)
+}
+
+func TestGetErrorResponses(t *testing.T) {
+	logger, _ := mustCreateLogger(t)
+	res := httptest.NewRecorder()
+	logger.OnGetLogSRDWithRevData(res, httptest.NewRequest("GET", GetLogSRDWithRevDataPath, nil))
+	mustGetErrorResponse(t, res, http.StatusServiceUnavailable, ErrorCodeNotReady)
+
+	res = httptest.NewRecorder()
+	logger.OnGetSRDUpdates(res, httptest.NewRequest("GET", GetSRDUpdatesPath + "?start=x", nil))
+	mustGetErrorResponse(t, res, http.StatusBadRequest, ErrorCodeInvalidRequest)
+}
+
+func TestRevokeAndProduceSRDForwardingError(t *testing.T) {
+	logger, _ := mustCreateLogger(t)
+	caServer := httptest.NewServer(http.NotFoundHandler())
+	logger.CAList.FindCAByCAID(ca_id).CAURL = caServer.URL
+
+	res := httptest.NewRecorder()
+	logger.OnRevokeAndProduceSRD(res, httptest.NewRequest("POST", RevokeAndProduceSRDPath, nil))
+	mustGetErrorResponse(t, res, http.StatusBadRequest, ErrorCodeInvalidRequest)
+
+	for _, status := range []int{http.StatusNotFound, 0} {
+		if status == 0 {
+			caServer.Close() //the CA is down
+		}
+		res = httptest.NewRecorder()
+		logger.OnRevokeAndProduceSRD(res, newPostRequest([]byte(`{"percent_revoked": 10, "total_certs": 100}`)))
+		response := mustGetErrorResponse(t, res, http.StatusBadGateway, ErrorCodeCAUnavailable)
+		if response.Details["ca_id"] != ca_id {
+			t.Fatalf("forwarding error should name the CA, got %+v", response.Details)
+		}
+	}
+}
+
+func TestAsError(t *testing.T) {
+	err := fmt.Errorf("failed to update: %w", NewError(ErrorCodeUnknownCA, nil, "caID (x) not found").With("ca_id", "x"))
+	if loggerErr := AsError(err); loggerErr.Code != ErrorCodeUnknownCA || loggerErr.Status() != http.StatusNotFound {
+		t.Fatalf("wrapped Error should be found, got %+v", loggerErr)
+	}
+	if loggerErr := AsError(errors.New("plain")); loggerErr.Code != ErrorCodeInternal || loggerErr.Status() != http.StatusInternalServerError {
+		t.Fatalf("plain errors should be internal errors, got %+v", loggerErr)
+	}
+	if ParseErrorResponse([]byte("not json")) != nil {
+		t.Fatalf("body that is not an ErrorResponse should not parse")
+	}
+}
+
+func TestWithCopiesError(t *testing.T) {
+	err := ErrUnknownCA.With("ca_id", "x")
+	if ErrUnknownCA.Details != nil {
+		t.Fatalf("With should not change the sentinel, got %+v", ErrUnknownCA.Details)
+	}
+	other := err.With("ca_id", "y")
+	if err.Details["ca_id"] != "x" || other.Details["ca_id"] != "y" || !errors.Is(other, ErrUnknownCA) {
+		t.Fatalf("With should return a copy, got %+v and %+v", err.Details, other.Details)
+	}
+}
+
+func TestWriteErrorHidesInternalCause(t *testing.T) {
+	res := httptest.NewRecorder()
+	WriteError(res, fmt.Errorf("failed to open /var/lib/ct-logger/audit.log: %w", errors.New("permission denied")))
+	response := mustGetErrorResponse(t, res, http.StatusInternalServerError, ErrorCodeInternal)
+	if response.Message != ErrInternal.Message {
+		t.Fatalf("internal error should not show its cause, got %q", response.Message)
+	}
+
+	res = httptest.NewRecorder()
+	WriteError(res, NewError(ErrorCodeUnknownCA, nil, "caID (x) not found"))
+	if response = mustGetErrorResponse(t, res, http.StatusNotFound, ErrorCodeUnknownCA); response.Message != "caID (x) not found" {
+		t.Fatalf("other errors should keep their message, got %q", response.Message)
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	logger, _ := mustCreateLogger(t)
+	now := time.Now()
+	tests := []struct {
+		name	string
+		srd		*mtr.SRDWithRevData
+		want	error
+	}{
+		{"unknown CA", testutil.CreateCASRD(t, []uint64{1}, []uint64{1}, now), ErrUnknownCA},
+		{"bad signature", testutil.CreateCASRD(t, []uint64{1}, []uint64{1}, now), ErrBadSignature},
+		{"bad compression", testutil.CreateCASRD(t, []uint64{1}, []uint64{1}, now), ErrBadCompression},
+		{"inconsistent delta", testutil.CreateCASRD(t, []uint64{1}, []uint64{3}, now), ErrInconsistentDelta},
+		{"stale", testutil.CreateCASRD(t, []uint64{1}, []uint64{1}, now.Add(-time.Hour)), ErrStale},
+	}
+	tests[0].srd.SRD.EntityID = "unknown"
+	tests[1].srd.SRD.RevDigest.Timestamp++
+	tests[2].srd.RevData.CRVDelta = []byte("not a compressed CRV")
+	mustUpdateAt(t, logger, []uint64{1}, []uint64{1}, now)
+
+	for _, test := range tests {
+		err := logger.UpdateLogSRDWithRevData(test.srd)
+		if !errors.Is(err, test.want) {
+			t.Fatalf("%v: expected %v, got %v", test.name, test.want, err)
+		}
+		var loggerErr *Error
+		if !errors.As(err, &loggerErr) || loggerErr.Details["ca_id"] != test.srd.SRD.EntityID {
+			t.Fatalf("%v: error should name the CA, got %+v", test.name, loggerErr)
+		}
+		if errors.Is(err, ErrInternal) {
+			t.Fatalf("%v: error should only match its own sentinel", test.name)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package logger
+
+import (
+	"context"
+	"sync"
+	"time"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"github.com/golang/glog"
+)
+
+const (
+	HealthzPath	= "/healthz"
+	ReadyzPath	= "/readyz"
+)
+
+//time a readiness probe waits for a CA to answer before reporting it unreachable
+var caProbeTimeout = 2 * time.Second
+
+// How often RunProbes signs a probe and checks that the CAs answer, /readyz reports the latest results
+const DefaultProbeInterval = 15 * time.Second
+
+//status of a health check and of the whole readiness report
+const (
+	HealthOK			= "ok"
+	HealthDegraded		= "degraded" //the logger serves but a CA it depends on is unreachable or behind
+	HealthUnavailable	= "unavailable" //the logger should not get traffic
+)
+
+//names of the readiness checks
+const (
+	CheckState		= "state" //the persistent state of the logger is loaded, see AwaitState
+	CheckDraining	= "draining" //the logger is not shutting down, see Drain
+	CheckSigner		= "signer" //the current signing key makes signatures that verify
+	CheckSigning	= "signing" //the log list state and temporal interval let the logger sign now
+	CheckCAReachable	= "ca-reachable" //the CA answers on its URL
+	CheckCAMMD		= "ca-mmd" //the CA sent an SRD within its MMD
+)
+
+//outcome of one readiness check
+type HealthCheck struct {
+	Check	string	`json:"check"`
+	Status	string	`json:"status"`
+	LogID	string	`json:"log_id"`
+	Subject	string	`json:"subject,omitempty"` //key ID or CA ID the check is about
+	Message	string	`json:"message,omitempty"`
+}
+
+//latest results of the signer and CA probes, they have their own lock so readiness never waits for a probe
+type probeResults struct {
+	sync.Mutex
+	signer		*HealthCheck //nil until the first probe
+	reachable	map[string] HealthCheck //map[CA ID]
+}
+
+//readiness of the loggers of a server, served on ReadyzPath
+type Readiness struct {
+	Status	string			`json:"status"` //worst status of the checks
+	Checks	[]HealthCheck	`json:"checks"`
+}
+
+//Marks the logger as waiting for its persistent state: it is not ready and takes no SRDs until ImportState succeeds
+func (this *Logger) AwaitState() {
+	this.Lock()
+	this.statePending = true
+	this.Unlock()
+}
+
+// IsReady returns true once the persistent state of the logger is loaded
+func (this *Logger) IsReady() bool {
+	this.RLock()
+	defer this.RUnlock()
+	return !this.statePending
+}
+
+//Runs the readiness checks of the logger. The state, draining and signer checks fail readiness,
+//the others only degrade it. The signer and CA reachability are reported as last probed, see Probe
+func (this *Logger) CheckReadiness(now time.Time) []HealthCheck {
+	this.RLock()
+	defer this.RUnlock()
+	var checks []HealthCheck
+	report := func(check, status, subject, message string) {
+		checks = append(checks, HealthCheck{check, status, this.LogID, subject, message})
+	}
+	if this.statePending {
+		report(CheckState, HealthUnavailable, "", "persistent state is not loaded yet")
+	} else {
+		report(CheckState, HealthOK, "", "")
+	}
+	if this.draining {
+		report(CheckDraining, HealthUnavailable, "", "logger is shutting down")
+	} else {
+		report(CheckDraining, HealthOK, "", "")
+	}
+
+	this.probes.Lock()
+	signer, reachable := this.probes.signer, this.probes.reachable
+	this.probes.Unlock()
+	if signer != nil {
+		checks = append(checks, *signer)
+	} else {
+		report(CheckSigner, HealthUnavailable, "", "signer not probed yet")
+	}
+
+	//a readonly logger or one outside its temporal interval still serves what it signed
+	if err := this.checkCanSign(uint64(now.Unix())); err != nil {
+		report(CheckSigning, HealthDegraded, "", err.Error())
+	} else {
+		report(CheckSigning, HealthOK, "", "")
+	}
+
+	//a CA that never sent an SRD is behind once its MMD passed since the logger became ready
+	var caIDs []string
+	for _, caID := range this.CAIDs {
+		caInfo := this.CAList.FindCAByCAID(caID)
+		if caInfo == nil {
+			continue //reported by the ca-ids self-check
+		}
+		caIDs = append(caIDs, caID)
+		last := this.readySince
+		for _, srd := range this.LogSRDWithRevDataMap[caID] {
+			if t := time.Unix(int64(srd.SRD.RevDigest.Timestamp), 0); t.After(last) {
+				last = t
+			}
+		}
+		mmd := time.Duration(caInfo.MMD) * time.Second
+		if since := now.Sub(last); caInfo.MMD > 0 && since > mmd {
+			report(CheckCAMMD, HealthDegraded, caID, "no SRD for " + since.Truncate(time.Second).String() + ", past the MMD of " + mmd.String())
+		} else {
+			report(CheckCAMMD, HealthOK, caID, "")
+		}
+	}
+	for _, caID := range caIDs {
+		if check, ok := reachable[caID]; ok {
+			checks = append(checks, check)
+		} else {
+			report(CheckCAReachable, HealthDegraded, caID, "CA not probed yet")
+		}
+	}
+	return checks
+}
+
+// Probe signs a probe with the current signing key and checks that each CA answers on its URL, then keeps the results
+// for CheckReadiness. CAs are probed concurrently, each for at most caProbeTimeout
+func (this *Logger) Probe(ctx context.Context) {
+	now := time.Now()
+	this.RLock()
+	//sign a probe with the key SRDs are signed with right now, as the log list key is checked at startup
+	key, signer, err := this.signingKeyAt(now)
+	type caProbe struct {
+		caID, url	string
+	}
+	var probes []caProbe
+	for _, caID := range this.CAIDs {
+		if caInfo := this.CAList.FindCAByCAID(caID); caInfo != nil {
+			probes = append(probes, caProbe{caID, caInfo.CAURL})
+		}
+	}
+	this.RUnlock()
+
+	if err == nil {
+		err = probeSigner(signer, key.PublicKey, key.endorsement(this.LogID))
+	}
+	signerCheck := HealthCheck{CheckSigner, HealthOK, this.LogID, "", ""}
+	if key != nil {
+		signerCheck.Subject = key.KeyID
+	}
+	if err != nil {
+		signerCheck.Status, signerCheck.Message = HealthUnavailable, err.Error()
+	}
+	this.probes.Lock()
+	this.probes.signer = &signerCheck
+	this.probes.Unlock()
+
+	reachable := make([]HealthCheck, len(probes))
+	var wg sync.WaitGroup
+	for i, probe := range probes {
+		wg.Add(1)
+		go func(i int, caID, url string) {
+			defer wg.Done()
+			reachable[i] = HealthCheck{CheckCAReachable, HealthOK, this.LogID, caID, ""}
+			if err := probeURL(ctx, url); err != nil {
+				reachable[i].Status = HealthDegraded
+				reachable[i].Message = err.Error()
+			}
+		}(i, probe.caID, probe.url)
+	}
+	wg.Wait()
+	reachableMap := make(map[string] HealthCheck)
+	for _, check := range reachable {
+		reachableMap[check.Subject] = check
+	}
+	this.probes.Lock()
+	this.probes.reachable = reachableMap
+	this.probes.Unlock()
+}
+
+// Probes the logger at once and then every interval until ctx is done, see Probe
+func (this *Logger) RunProbes(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		this.Probe(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+//signs probe with signer and verifies it with publicKey
+func probeSigner(signer Signer, publicKey string, probe interface{}) error {
+	sig, err := signer.CreateSignature(probe)
+	if err != nil {
+		return err
+	}
+	return VerifySignature(publicKey, probe, *sig)
+}
+
+//returns nil when the server at url answers at all, any status code means it is up
+func probeURL(ctx context.Context, url string) error {
+	ctx, cancel := context.WithTimeout(ctx, caProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// Runs the readiness checks of the loggers, the report has the worst status of all checks
+func CheckReadiness(loggers []*Logger) *Readiness {
+	readiness := &Readiness{Status: HealthOK, Checks: []HealthCheck{}}
+	now := time.Now()
+	for _, l := range loggers {
+		readiness.Checks = append(readiness.Checks, l.CheckReadiness(now)...)
+	}
+	for _, check := range readiness.Checks {
+		if check.Status == HealthUnavailable || (check.Status == HealthDegraded && readiness.Status == HealthOK) {
+			readiness.Status = check.Status
+		}
+	}
+	return readiness
+}
+
+//handler serving liveness on HealthzPath, the process answers as long as it serves requests
+func healthzHandler(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	res.Write([]byte(`{"status":"ok"}`))
+}
+
+//handler serving the readiness of the loggers on ReadyzPath, 503 when unavailable and 200 otherwise, also when degraded
+func readyzHandler(loggers []*Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		readiness := CheckReadiness(loggers)
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(readiness); err != nil {
+			WriteError(res, NewError(ErrorCodeInternal, err, "failed to Marshal readiness"))
+			return
+		}
+		res.Header().Set("Content-Type", "application/json")
+		if readiness.Status == HealthUnavailable {
+			glog.Warningf("logger server is not ready: %s", bytes.TrimSpace(buf.Bytes()))
+			res.WriteHeader(http.StatusServiceUnavailable)
+		}
+		res.Write(buf.Bytes())
+	}
+}
@@ -0,0 +1,147 @@
+package logger
+
+import (
+	"testing"
+	"context"
+	"errors"
+	"time"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	ct "github.com/google/certificate-transparency-go"
+)
+
+//function that fetches the readiness served by server and checks its status code
+func mustGetReadiness(t *testing.T, server *httptest.Server, status int) *Readiness {
+	t.Helper()
+	res, err := http.Get(server.URL + ReadyzPath)
+	if err != nil {
+		t.Fatalf("failed to get readiness: %v", err)
+	}
+	defer res.Body.Close()
+	var readiness Readiness
+	if err := json.NewDecoder(res.Body).Decode(&readiness); err != nil {
+		t.Fatalf("failed to decode readiness: %v", err)
+	}
+	if res.StatusCode != status {
+		t.Fatalf("expected readiness status %v, got %v: %+v", status, res.StatusCode, readiness)
+	}
+	return &readiness
+}
+
+//returns the status of check about subject in readiness, empty if it is not reported
+func checkStatus(readiness *Readiness, check, subject string) string {
+	for _, c := range readiness.Checks {
+		if c.Check == check && c.Subject == subject {
+			return c.Status
+		}
+	}
+	return ""
+}
+
+//a Signer whose signatures always fail, as with an HSM that went away
+type failingSigner struct {
+	Signer
+}
+
+func (s *failingSigner) CreateSignature(toBeSigned interface{}) (*ct.DigitallySigned, error) {
+	return nil, errors.New("signing device unavailable")
+}
+
+func TestHealthz(t *testing.T) {
+	logger, _ := mustCreateLogger(t)
+	logger.AwaitState()
+	serveMux, err := NewServeMux([]*Logger{logger}, nil)
+	if err != nil {
+		t.Fatalf("failed to create serve mux: %v", err)
+	}
+	server := httptest.NewServer(serveMux)
+	defer server.Close()
+	//liveness does not depend on readiness
+	if code := mustGetStatus(t, "GET", server.URL + HealthzPath, nil); code != http.StatusOK {
+		t.Fatalf("healthz should be 200 while the state loads, got %v", code)
+	}
+	if code := mustGetStatus(t, "POST", server.URL + HealthzPath, []byte("{}")); code != http.StatusMethodNotAllowed {
+		t.Fatalf("healthz should only serve GET, got %v", code)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	logger, _ := mustCreateLogger(t)
+	caServer := httptest.NewServer(http.NotFoundHandler())
+	defer caServer.Close()
+	logger.CAList.FindCAByCAID(ca_id).CAURL = caServer.URL
+	serveMux, err := NewServeMux([]*Logger{logger}, nil)
+	if err != nil {
+		t.Fatalf("failed to create serve mux: %v", err)
+	}
+	server := httptest.NewServer(serveMux)
+	defer server.Close()
+
+	//the signer and CAs are reported as last probed
+	readiness := mustGetReadiness(t, server, http.StatusServiceUnavailable)
+	if checkStatus(readiness, CheckSigner, "") != HealthUnavailable || checkStatus(readiness, CheckCAReachable, ca_id) != HealthDegraded {
+		t.Fatalf("logger that was not probed yet should not be ready, got %+v", readiness)
+	}
+	logger.Probe(context.Background())
+	readiness = mustGetReadiness(t, server, http.StatusOK)
+	if readiness.Status != HealthOK {
+		t.Fatalf("logger with a reachable CA should be ready, got %+v", readiness)
+	}
+
+	//not ready and refusing SRDs until the state is imported
+	standby, _ := mustCreateLogger(t)
+	standby.CAList.FindCAByCAID(ca_id).CAURL = caServer.URL
+	standby.AwaitState()
+	standby.Probe(context.Background())
+	serveMux, _ = NewServeMux([]*Logger{standby}, nil)
+	standbyServer := httptest.NewServer(serveMux)
+	defer standbyServer.Close()
+	readiness = mustGetReadiness(t, standbyServer, http.StatusServiceUnavailable)
+	if readiness.Status != HealthUnavailable || checkStatus(readiness, CheckState, "") != HealthUnavailable {
+		t.Fatalf("logger awaiting its state should be unavailable, got %+v", readiness)
+	}
+	if code := mustGetStatus(t, "POST", standbyServer.URL + PostLogSRDWithRevDataPath, mustCreateCASRDBody(t)); code != http.StatusServiceUnavailable {
+		t.Fatalf("logger awaiting its state should refuse SRDs, got %v", code)
+	}
+	archive, err := logger.ExportState()
+	if err != nil {
+		t.Fatalf("failed to export state: %v", err)
+	}
+	if err := standby.ImportState(archive); err != nil {
+		t.Fatalf("failed to import state: %v", err)
+	}
+	if readiness = mustGetReadiness(t, standbyServer, http.StatusOK); readiness.Status != HealthOK {
+		t.Fatalf("logger should be ready once its state is imported, got %+v", readiness)
+	}
+
+	//an unreachable CA and one past its MMD only degrade readiness
+	caServer.Close()
+	logger.Lock()
+	logger.readySince = time.Now().Add(-time.Hour)
+	logger.Unlock()
+	if readiness = mustGetReadiness(t, server, http.StatusOK); checkStatus(readiness, CheckCAReachable, ca_id) != HealthOK {
+		t.Fatalf("CA should stay reachable until the next probe, got %+v", readiness)
+	}
+	logger.Probe(context.Background())
+	readiness = mustGetReadiness(t, server, http.StatusOK)
+	if readiness.Status != HealthDegraded || checkStatus(readiness, CheckCAReachable, ca_id) != HealthDegraded ||
+		checkStatus(readiness, CheckCAMMD, ca_id) != HealthDegraded {
+		t.Fatalf("unreachable CA past its MMD should degrade readiness, got %+v", readiness)
+	}
+	mustUpdateAt(t, logger, []uint64{1}, []uint64{1}, time.Now())
+	if readiness = mustGetReadiness(t, server, http.StatusOK); checkStatus(readiness, CheckCAMMD, ca_id) != HealthOK {
+		t.Fatalf("CA that just sent an SRD is within its MMD, got %+v", readiness)
+	}
+
+	//a signer that cannot sign makes the logger unavailable
+	logger.Lock()
+	key := logger.Keys[len(logger.Keys) - 1]
+	logger.signers[key.KeyID] = &failingSigner{logger.signers[key.KeyID]}
+	logger.Unlock()
+	logger.Probe(context.Background())
+	readiness = mustGetReadiness(t, server, http.StatusServiceUnavailable)
+	if checkStatus(readiness, CheckSigner, key.KeyID) != HealthUnavailable {
+		t.Fatalf("failing signer should make the logger unavailable, got %+v", readiness)
+	}
+}
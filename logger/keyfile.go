@@ -0,0 +1,281 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"bytes"
+	"hash"
+	"path/filepath"
+	"io/ioutil"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/golang/glog"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+//where the logger gets a signing key from, exactly one of the sources must be set
+type KeySource struct {
+	//base64 DER EC private key, kept for old configs. Prefer private_key_file or signer
+	PrivKey			string			`json:"private_key"`
+	//PEM file holding an EC PRIVATE KEY, RSA PRIVATE KEY, PRIVATE KEY or passphrase encrypted ENCRYPTED PRIVATE KEY.
+	//Relative paths are relative to the directory of the config file
+	PrivKeyFile		string			`json:"private_key_file"`
+	PassphraseFile	string			`json:"passphrase_file"` //file holding the passphrase of an encrypted key
+	PassphraseEnv	string			`json:"passphrase_env"` //environment variable holding the passphrase of an encrypted key
+	Signer			*SignerConfig	`json:"signer"` //key held outside the logger, see RegisterSignerProvider
+	//one of the Algorithm names, defaults to ecdsa-p256-sha256 or ecdsa-p384-sha384 by curve, ed25519 and rsa-pkcs1-sha256
+	SignatureAlgorithm	string		`json:"signature_algorithm"`
+}
+
+//names a registered SignerProvider and the options it opens the key with
+type SignerConfig struct {
+	Provider	string				`json:"provider"`
+	Options		map[string]string	`json:"options"`
+}
+
+func (k *KeySource) isSet() bool {
+	return k.PrivKey != "" || k.PrivKeyFile != "" || k.Signer != nil
+}
+
+//opens the signer of the key source, relative file names are resolved against baseDir
+func (k *KeySource) newSigner(baseDir string) (Signer, error) {
+	sources := 0
+	for _, set := range []bool{k.PrivKey != "", k.PrivKeyFile != "", k.Signer != nil} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return nil, fmt.Errorf("exactly one of private_key, private_key_file and signer must be set for a key")
+	}
+	switch {
+	case k.Signer != nil:
+		return openProviderSigner(k.Signer, k.SignatureAlgorithm)
+	case k.PrivKeyFile != "":
+		passphrase, err := k.passphrase(baseDir)
+		if err != nil {
+			return nil, err
+		}
+		privKey, err := LoadPEMPrivateKey(resolvePath(baseDir, k.PrivKeyFile), passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return NewCryptoSigner(privKey, k.SignatureAlgorithm)
+	default:
+		glog.Warningf("the logger config holds a raw private key, move it to a private_key_file or signer")
+		der, err := base64.StdEncoding.DecodeString(k.PrivKey)
+		if err != nil {
+			return nil, fmt.Errorf("error base64 decoding private key: %v", err)
+		}
+		var privKey crypto.Signer
+		if privKey, err = x509.ParseECPrivateKey(der); err != nil {
+			pkcs8Key, pkcs8Err := x509.ParsePKCS8PrivateKey(der)
+			if pkcs8Err != nil {
+				return nil, fmt.Errorf("error parsing private key: %v", err)
+			}
+			var ok bool
+			if privKey, ok = pkcs8Key.(crypto.Signer); !ok {
+				return nil, fmt.Errorf("unsupported private key type %T", pkcs8Key)
+			}
+		}
+		return NewCryptoSigner(privKey, k.SignatureAlgorithm)
+	}
+}
+
+func (k *KeySource) passphrase(baseDir string) ([]byte, error) {
+	switch {
+	case k.PassphraseFile != "" && k.PassphraseEnv != "":
+		return nil, fmt.Errorf("only one of passphrase_file and passphrase_env may be set")
+	case k.PassphraseFile != "":
+		passphrase, err := ioutil.ReadFile(resolvePath(baseDir, k.PassphraseFile))
+		if err != nil {
+			return nil, fmt.Errorf("error reading passphrase: %v", err)
+		}
+		return bytes.TrimRight(passphrase, "\r\n"), nil
+	case k.PassphraseEnv != "":
+		passphrase, ok := os.LookupEnv(k.PassphraseEnv)
+		if !ok {
+			return nil, fmt.Errorf("passphrase environment variable %v is not set", k.PassphraseEnv)
+		}
+		return []byte(passphrase), nil
+	}
+	return nil, nil
+}
+
+func resolvePath(baseDir, fileName string) string {
+	if filepath.IsAbs(fileName) || baseDir == "" {
+		return fileName
+	}
+	return filepath.Join(baseDir, fileName)
+}
+
+//Reads a PEM private key file, passphrase is only used for an ENCRYPTED PRIVATE KEY
+func LoadPEMPrivateKey(fileName string, passphrase []byte) (crypto.Signer, error) {
+	pemBytes, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading private key file: %v", err)
+	}
+	privKey, err := ParsePEMPrivateKey(pemBytes, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing private key file %v: %w", fileName, err)
+	}
+	return privKey, nil
+}
+
+//Parses the first private key in PEM data, decrypting a PKCS#8 ENCRYPTED PRIVATE KEY with passphrase
+func ParsePEMPrivateKey(pemBytes, passphrase []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, pemBytes = pem.Decode(pemBytes)
+		if block == nil {
+			return nil, fmt.Errorf("no private key found in PEM data")
+		}
+		if _, ok := block.Headers["Proc-Type"]; ok {
+			return nil, fmt.Errorf("legacy PEM encryption is not supported, convert the key to an encrypted PKCS#8 key")
+		}
+		var privKey interface{}
+		var err error
+		switch block.Type {
+		case "EC PRIVATE KEY":
+			privKey, err = x509.ParseECPrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			privKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			privKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "ENCRYPTED PRIVATE KEY":
+			if len(passphrase) == 0 {
+				return nil, fmt.Errorf("private key is encrypted but no passphrase is configured")
+			}
+			var der []byte
+			der, err = decryptPKCS8(block.Bytes, passphrase)
+			if err == nil {
+				privKey, err = x509.ParsePKCS8PrivateKey(der)
+				if err != nil {
+					err = fmt.Errorf("wrong passphrase or corrupt private key")
+				}
+			}
+		default:
+			continue //e.g. EC PARAMETERS in front of the key
+		}
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := privKey.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", privKey)
+		}
+		return signer, nil
+	}
+}
+
+var (
+	oidPBES2			= asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2			= asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1		= asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256	= asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384	= asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512	= asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC		= asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC		= asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC		= asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+//ASN.1 structures of RFC 5958 and RFC 8018
+type encryptedPrivateKeyInfo struct {
+	Algorithm		pkix.AlgorithmIdentifier
+	EncryptedData	[]byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc	pkix.AlgorithmIdentifier
+	EncryptionScheme	pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt			[]byte
+	IterationCount	int
+	KeyLength		int						`asn1:"optional"`
+	PRF				pkix.AlgorithmIdentifier	`asn1:"optional"`
+}
+
+//decrypts a PBES2 encrypted PKCS#8 key using PBKDF2 and AES-CBC, the format openssl pkcs8 -topk8 -v2 writes
+func decryptPKCS8(der, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("invalid encrypted private key")
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported private key encryption %v, only PBES2 is supported", info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("invalid PBES2 parameters: %v", err)
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation function %v, only PBKDF2 is supported", params.KeyDerivationFunc.Algorithm)
+	}
+	var kdfParams pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+		return nil, fmt.Errorf("invalid PBKDF2 parameters: %v", err)
+	}
+	var prf func() hash.Hash
+	switch {
+	case len(kdfParams.PRF.Algorithm) == 0 || kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA384):
+		prf = sha512.New384
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA512):
+		prf = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported PBKDF2 pseudorandom function %v", kdfParams.PRF.Algorithm)
+	}
+	var keyLen int
+	switch {
+	case params.EncryptionScheme.Algorithm.Equal(oidAES128CBC):
+		keyLen = 16
+	case params.EncryptionScheme.Algorithm.Equal(oidAES192CBC):
+		keyLen = 24
+	case params.EncryptionScheme.Algorithm.Equal(oidAES256CBC):
+		keyLen = 32
+	default:
+		return nil, fmt.Errorf("unsupported private key cipher %v, only AES-CBC is supported", params.EncryptionScheme.Algorithm)
+	}
+	if kdfParams.KeyLength != 0 && kdfParams.KeyLength != keyLen {
+		return nil, fmt.Errorf("PBKDF2 key length %v does not match the cipher", kdfParams.KeyLength)
+	}
+	if kdfParams.IterationCount < 1 {
+		return nil, fmt.Errorf("invalid PBKDF2 iteration count %v", kdfParams.IterationCount)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("invalid AES-CBC parameters")
+	}
+	if len(info.EncryptedData) == 0 || len(info.EncryptedData) % aes.BlockSize != 0 {
+		return nil, fmt.Errorf("invalid encrypted private key length")
+	}
+
+	key := pbkdf2.Key(passphrase, kdfParams.Salt, kdfParams.IterationCount, keyLen, prf)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, info.EncryptedData)
+	//PKCS#7 padding, a wrong passphrase almost always breaks it
+	padding := int(plain[len(plain) - 1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(plain[len(plain) - padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, fmt.Errorf("wrong passphrase or corrupt private key")
+	}
+	return plain[:len(plain) - padding], nil
+}
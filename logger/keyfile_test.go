@@ -0,0 +1,141 @@
+package logger
+
+import (
+	"testing"
+	"time"
+	"os"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/n-ct/ct-monitor/signature"
+	"github.com/n-ct/ct-logger/internal/testutil"
+)
+
+const (
+	key_pem_filename				string = "../testdata/logger_key.pem"
+	key_encrypted_filename			string = "../testdata/logger_key_encrypted.pem" //PBKDF2 with HMAC-SHA256 and AES-256-CBC
+	key_encrypted_sha1_filename		string = "../testdata/logger_key_encrypted_sha1.pem" //PBKDF2 with the default HMAC-SHA1 and AES-128-CBC
+	key_passphrase_filename			string = "../testdata/logger_key.pass"
+	logger_public_key				string = "MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAE7NCs6/fduluK/ftyw2iOe0odyHWsl5gkEVdysQC2DCQR3gW5Co1XbViJPsDVvjUiYyLGm9OG676rJenwt81+ow=="
+)
+
+func mustPublicKey(t *testing.T, privKey crypto.Signer) string {
+	t.Helper()
+	signer, err := NewCryptoSigner(privKey, "")
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	publicKey, err := publicKeyOfSigner(signer)
+	if err != nil {
+		t.Fatalf("failed to get public key: %v", err)
+	}
+	return publicKey
+}
+
+func TestLoadPEMPrivateKey(t *testing.T) {
+	passphrase := []byte("correct horse battery staple")
+	for _, fileName := range []string{key_pem_filename, key_encrypted_filename, key_encrypted_sha1_filename} {
+		privKey, err := LoadPEMPrivateKey(fileName, passphrase)
+		if err != nil {
+			t.Fatalf("failed to load %v: %v", fileName, err)
+		}
+		if publicKey := mustPublicKey(t, privKey); publicKey != logger_public_key {
+			t.Fatalf("key loaded from %v has public key %v not %v", fileName, publicKey, logger_public_key)
+		}
+	}
+	if _, err := LoadPEMPrivateKey(key_encrypted_filename, []byte("wrong passphrase")); err == nil {
+		t.Fatalf("encrypted key should not load with a wrong passphrase")
+	}
+	if _, err := LoadPEMPrivateKey(key_encrypted_filename, nil); err == nil {
+		t.Fatalf("encrypted key should not load without a passphrase")
+	}
+	if _, err := ParsePEMPrivateKey([]byte("not a key"), nil); err == nil {
+		t.Fatalf("data without a PEM block should not parse")
+	}
+}
+
+func TestNewLoggerWithKeyFile(t *testing.T) {
+	keyFiles := testutil.WithFiles(key_encrypted_filename, key_passphrase_filename)
+	configName := testutil.WriteConfig(t, config_filename, testutil.WithFields(KeySource{PrivKeyFile: "logger_key_encrypted.pem", PassphraseFile: "logger_key.pass"}), keyFiles)
+	logger, err := NewLogger(configName, caList_filename, logList_filename)
+	if err != nil {
+		t.Fatalf("failed to create logger with encrypted key file: %v", err)
+	}
+	if logger.Keys[0].PublicKey != logger.PublicKey {
+		t.Fatalf("key file should hold the key of the logger in the log list")
+	}
+	srd := mustUpdateAt(t, logger, []uint64{1,3}, []uint64{1,3}, time.Now())
+	if err := VerifySRDWithKeys(srd, logger.Keys, ""); err != nil {
+		t.Fatalf("SRD signed with key file does not verify: %v", err)
+	}
+
+	os.Setenv("CT_LOGGER_TEST_PASSPHRASE", "correct horse battery staple")
+	defer os.Unsetenv("CT_LOGGER_TEST_PASSPHRASE")
+	configName = testutil.WriteConfig(t, config_filename, testutil.WithFields(KeySource{PrivKeyFile: "logger_key_encrypted.pem", PassphraseEnv: "CT_LOGGER_TEST_PASSPHRASE"}), keyFiles)
+	if _, err := NewLogger(configName, caList_filename, logList_filename); err != nil {
+		t.Fatalf("failed to create logger with passphrase from the environment: %v", err)
+	}
+
+	configName = testutil.WriteConfig(t, config_filename, testutil.WithFields(KeySource{PrivKeyFile: "logger_key_encrypted.pem"}), keyFiles)
+	if _, err := NewLogger(configName, caList_filename, logList_filename); err == nil {
+		t.Fatalf("logger should not be created with an encrypted key and no passphrase")
+	}
+	configName = testutil.WriteConfig(t, config_filename, testutil.WithFields(KeySource{PrivKey: mustGetPrivateKey(t), PrivKeyFile: "logger_key_encrypted.pem"}), keyFiles)
+	if _, err := NewLogger(configName, caList_filename, logList_filename); err == nil {
+		t.Fatalf("logger should not be created with two sources for one key")
+	}
+}
+
+func TestSignerProvider(t *testing.T) {
+	hsmKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	var gotOptions map[string]string
+	RegisterSignerProvider("test-hsm", func(options map[string]string) (crypto.Signer, error) {
+		gotOptions = options
+		return hsmKey, nil
+	})
+
+	options := map[string]string{"module": "/usr/lib/softhsm/libsofthsm2.so", "label": "ct-logger"}
+	configName := testutil.WriteConfig(t, config_filename, testutil.WithFields(KeySource{Signer: &SignerConfig{Provider: "test-hsm", Options: options}}))
+	logger, err := NewLogger(configName, caList_filename, mustWriteLogListWithKey(t, mustPublicKey(t, hsmKey), ""))
+	if err != nil {
+		t.Fatalf("failed to create logger with signer provider: %v", err)
+	}
+	if gotOptions["label"] != "ct-logger" {
+		t.Fatalf("signer provider should get the configured options, got %v", gotOptions)
+	}
+	if logger.Keys[0].PublicKey != mustPublicKey(t, hsmKey) {
+		t.Fatalf("logger should sign with the key of the signer provider")
+	}
+	srd := mustUpdateAt(t, logger, []uint64{1,3}, []uint64{1,3}, time.Now())
+	if err := VerifySRDWithKeys(srd, logger.Keys, ""); err != nil {
+		t.Fatalf("SRD signed through signer provider does not verify: %v", err)
+	}
+
+	configName = testutil.WriteConfig(t, config_filename, testutil.WithFields(KeySource{Signer: &SignerConfig{Provider: "unknown"}}))
+	if _, err := NewLogger(configName, caList_filename, logList_filename); err == nil {
+		t.Fatalf("logger should not be created with an unknown signer provider")
+	}
+}
+
+func TestRSACryptoSigner(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	signer, err := NewCryptoSigner(rsaKey, "")
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	sig, err := signer.CreateSignature("sign this")
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	if err := signature.VerifySignature(mustPublicKey(t, rsaKey), "sign this", *sig); err != nil {
+		t.Fatalf("RSA signature does not verify: %v", err)
+	}
+}
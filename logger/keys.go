@@ -0,0 +1,416 @@
+package logger
+
+import (
+	"fmt"
+	"sort"
+	"time"
+	"net/http"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang/glog"
+	ct "github.com/google/certificate-transparency-go"
+	mtr "github.com/n-ct/ct-monitor"
+)
+
+const (
+	GetKeysPath	= "/ct/v1/get-keys"
+)
+
+//a signing key of the logger in the logger config
+type KeyConfig struct {
+	KeySource
+	//the key signs SRDs with a timestamp in [not_before, not_after), unbounded when not set
+	NotBefore	*time.Time	`json:"not_before"`
+	NotAfter	*time.Time	`json:"not_after"`
+}
+
+//public information about a signing key of the logger
+type LoggerKey struct {
+	KeyID				string		`json:"key_id"` //base64 SHA-256 of the DER public key
+	PublicKey			string		`json:"public_key"` //base64 DER public key
+	SignatureAlgorithm	string		`json:"signature_algorithm,omitempty"` //one of the Algorithm names, empty for keys from before algorithms were recorded
+	NotBefore			*time.Time	`json:"not_before,omitempty"`
+	NotAfter			*time.Time	`json:"not_after,omitempty"`
+	//base64 signature of the previous key over a KeyEndorsement of this key, empty for the first key
+	Endorsement	string		`json:"endorsement,omitempty"`
+}
+
+//data the previous key signs to endorse a new key, so clients trusting an old key can trust the new one
+type KeyEndorsement struct {
+	LogID				string
+	KeyID				string
+	PublicKey			string
+	SignatureAlgorithm	string		`json:",omitempty"` //omitted so endorsements made before it existed still verify
+	NotBefore			*time.Time
+}
+
+//the key history of a logger, signed by the key current when it was served
+type KeyHistory struct {
+	Keys		KeyList
+	KeyID		string //key that made Signature
+	Signature	ct.DigitallySigned
+}
+
+type KeyList struct {
+	LogID		string
+	Timestamp	uint64
+	Keys		[]LoggerKey //ordered by NotBefore, oldest first
+}
+
+//returns the base64 SHA-256 of a base64 DER public key
+func KeyIDFromPublicKey(publicKey string) (string, error) {
+	der, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode public key: %v", err)
+	}
+	hash := sha256.Sum256(der)
+	return base64.StdEncoding.EncodeToString(hash[:]), nil
+}
+
+//reports whether the key may sign an SRD with the given timestamp
+func (k *LoggerKey) ValidAt(t time.Time) bool {
+	if k.NotBefore != nil && t.Before(*k.NotBefore) {
+		return false
+	}
+	return k.NotAfter == nil || t.Before(*k.NotAfter)
+}
+
+//verifies a signature of the key, which must be of the algorithm recorded for the key
+func (k *LoggerKey) verifySignature(data interface{}, sig ct.DigitallySigned) error {
+	if k.SignatureAlgorithm != "" {
+		if name, err := AlgorithmName(sig.Algorithm); err != nil || name != k.SignatureAlgorithm {
+			return fmt.Errorf("key (%v) signs with %v, not with signature algorithm %v and hash %v", k.KeyID, k.SignatureAlgorithm, sig.Algorithm.Signature, sig.Algorithm.Hash)
+		}
+	}
+	return VerifySignature(k.PublicKey, data, sig)
+}
+
+func (k *LoggerKey) endorsement(logID string) KeyEndorsement {
+	return KeyEndorsement{logID, k.KeyID, k.PublicKey, k.SignatureAlgorithm, k.NotBefore}
+}
+
+//orders keys by NotBefore, a key without NotBefore comes first
+func keyBefore(a, b *LoggerKey) bool {
+	if a.NotBefore == nil || b.NotBefore == nil {
+		return a.NotBefore == nil && b.NotBefore != nil
+	}
+	return a.NotBefore.Before(*b.NotBefore)
+}
+
+//creates the keys and signers of the logger from its config, each key is endorsed by the key before it.
+//Relative key file names are resolved against baseDir
+func newLoggerKeys(logID string, keyConfigs []KeyConfig, baseDir string) ([]LoggerKey, map[string]Signer, error) {
+	if len(keyConfigs) == 0 {
+		return nil, nil, fmt.Errorf("no signing key configured")
+	}
+	keyConfigSigners := []Signer{}
+	for _, keyConfig := range keyConfigs {
+		signer, err := keyConfig.newSigner(baseDir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error creating signer for logger: %w", err)
+		}
+		keyConfigSigners = append(keyConfigSigners, signer)
+	}
+	return newLoggerKeysFromSigners(logID, keyConfigs, keyConfigSigners)
+}
+
+//same as newLoggerKeys for already opened signers, signers[i] is the signer of keyConfigs[i]
+func newLoggerKeysFromSigners(logID string, keyConfigs []KeyConfig, keyConfigSigners []Signer) ([]LoggerKey, map[string]Signer, error) {
+	keys := []LoggerKey{}
+	signers := make(map[string]Signer)
+	for i, keyConfig := range keyConfigs {
+		signer := keyConfigSigners[i]
+		publicKey, err := publicKeyOfSigner(signer)
+		if err != nil {
+			return nil, nil, err
+		}
+		keyID, err := KeyIDFromPublicKey(publicKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := signers[keyID]; ok {
+			return nil, nil, fmt.Errorf("key (%v) is configured more than once", keyID)
+		}
+		if keyConfig.NotBefore != nil && keyConfig.NotAfter != nil && !keyConfig.NotBefore.Before(*keyConfig.NotAfter) {
+			return nil, nil, fmt.Errorf("key (%v) has an empty validity window", keyID)
+		}
+		algorithm, err := AlgorithmName(signer.Algorithm())
+		if err != nil {
+			return nil, nil, fmt.Errorf("key (%v): %v", keyID, err)
+		}
+		signers[keyID] = signer
+		keys = append(keys, LoggerKey{KeyID: keyID, PublicKey: publicKey, SignatureAlgorithm: algorithm, NotBefore: keyConfig.NotBefore, NotAfter: keyConfig.NotAfter})
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keyBefore(&keys[i], &keys[j]) })
+	for i := 1; i < len(keys); i++ {
+		if err := endorseKey(logID, &keys[i], signers[keys[i-1].KeyID]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return keys, signers, nil
+}
+
+func endorseKey(logID string, key *LoggerKey, previous Signer) error {
+	sig, err := previous.CreateSignature(key.endorsement(logID))
+	if err != nil {
+		return fmt.Errorf("failed to endorse key (%v): %v", key.KeyID, err)
+	}
+	key.Endorsement, err = sig.Base64String()
+	if err != nil {
+		return fmt.Errorf("failed to encode endorsement of key (%v): %v", key.KeyID, err)
+	}
+	return nil
+}
+
+//returns the key the logger signs with at time t, the newest key valid at t, caller must hold the lock
+func (this *Logger) signingKeyAt(t time.Time) (*LoggerKey, Signer, error) {
+	for i := len(this.Keys) - 1; i >= 0; i-- {
+		if this.Keys[i].ValidAt(t) {
+			return &this.Keys[i], this.signers[this.Keys[i].KeyID], nil
+		}
+	}
+	return nil, nil, fmt.Errorf("no signing key of logger (%v) is valid at %v", this.LogID, t.UTC().Format(time.RFC3339))
+}
+
+//returns the key an SRD with the given timestamp is signed with at now, caller must hold the lock.
+//The timestamp comes from the CA, so the key is picked by the clock of the logger: the newest key valid at now.
+//During a rotation overlap an older key still valid at now is used when only it is valid at the timestamp too,
+//clients check the key against the SRD timestamp
+func (this *Logger) signingKeyForSRD(now time.Time, timestamp uint64) (*LoggerKey, Signer, error) {
+	srdTime := time.Unix(int64(timestamp), 0)
+	for i := len(this.Keys) - 1; i >= 0; i-- {
+		if this.Keys[i].ValidAt(now) && this.Keys[i].ValidAt(srdTime) {
+			return &this.Keys[i], this.signers[this.Keys[i].KeyID], nil
+		}
+	}
+	return nil, nil, fmt.Errorf("no signing key of logger (%v) valid at %v is valid at the SRD timestamp %v", this.LogID,
+		now.UTC().Format(time.RFC3339), srdTime.UTC().Format(time.RFC3339))
+}
+
+//a logger signed SRD as the logger serves it. The CTObject decodes as a plain mtr.CTObject, so monitors read it as before.
+//A logger SRD names the logger, not the CA its CRV is of, CAID does. KeyID names the key of the key history that signed it.
+//Neither is signed: a wrong KeyID only makes the SRD fail verification, see VerifySRDWithKeys
+type LogSRDCTObject struct {
+	mtr.CTObject
+	CAID	string	`json:",omitempty"`
+	KeyID	string	`json:",omitempty"`
+}
+
+//wraps the logger signed SRD of caID in a CTObject naming the signing key, caller must hold the lock
+func (this *Logger) constructLogSRDCTObject(caID string, srd *mtr.SRDWithRevData) (*LogSRDCTObject, error) {
+	keyID, ok := this.logSRDKeyIDs[srd]
+	if !ok {
+		return nil, fmt.Errorf("signing key of the SRD of CA (%v) is unknown", caID)
+	}
+	ctObject, err := mtr.ConstructCTObject(srd)
+	if err != nil {
+		return nil, err
+	}
+	return &LogSRDCTObject{CTObject: *ctObject, CAID: caID, KeyID: keyID}, nil
+}
+
+//stores srd as the current logger signed SRD of caID and revType, signed by the key keyID. Caller must hold the lock
+func (this *Logger) setLogSRD(caID, revType string, srd *mtr.SRDWithRevData, keyID string) {
+	if this.LogSRDWithRevDataMap == nil {
+		this.LogSRDWithRevDataMap = make(map[string]map[string] *mtr.SRDWithRevData)
+	}
+	if this.LogSRDWithRevDataMap[caID] == nil {
+		this.LogSRDWithRevDataMap[caID] = make(map[string] *mtr.SRDWithRevData)
+	}
+	if this.logSRDKeyIDs == nil {
+		this.logSRDKeyIDs = make(map[*mtr.SRDWithRevData] string)
+	}
+	delete(this.logSRDKeyIDs, this.LogSRDWithRevDataMap[caID][revType])
+	this.LogSRDWithRevDataMap[caID][revType] = srd
+	this.logSRDKeyIDs[srd] = keyID
+}
+
+//drops the logger signed SRDs of caID, caller must hold the lock
+func (this *Logger) deleteLogSRDs(caID string) {
+	for _, srd := range this.LogSRDWithRevDataMap[caID] {
+		delete(this.logSRDKeyIDs, srd)
+	}
+	delete(this.LogSRDWithRevDataMap, caID)
+}
+
+//Adds a key that takes over signing at notBefore. The current key stays valid until notBefore plus overlap,
+//so SRDs signed before the rotation keep verifying. The new key is endorsed by the key it replaces
+func (this *Logger) RotateKey(privKey string, notBefore time.Time, overlap time.Duration) (*LoggerKey, error) {
+	signer, err := (&KeySource{PrivKey: privKey}).newSigner("")
+	if err != nil {
+		return nil, err
+	}
+	return this.RotateKeyWithSigner(signer, notBefore, overlap)
+}
+
+//Same as RotateKey for a key that is already opened, e.g. one held in an HSM
+func (this *Logger) RotateKeyWithSigner(signer Signer, notBefore time.Time, overlap time.Duration) (*LoggerKey, error) {
+	this.Lock()
+	defer this.Unlock()
+	//SRDs already signed must keep mapping to the key that signed them
+	if notBefore.Before(time.Now()) {
+		return nil, fmt.Errorf("new key must not become valid in the past")
+	}
+	last := &this.Keys[len(this.Keys) - 1]
+	if last.NotBefore != nil && !notBefore.After(*last.NotBefore) {
+		return nil, fmt.Errorf("new key must become valid after the newest key (%v)", last.KeyID)
+	}
+	keys, signers, err := newLoggerKeysFromSigners(this.LogID, []KeyConfig{{NotBefore: &notBefore}}, []Signer{signer})
+	if err != nil {
+		return nil, err
+	}
+	key := keys[0]
+	if _, ok := this.signers[key.KeyID]; ok {
+		return nil, fmt.Errorf("key (%v) is already a key of the logger", key.KeyID)
+	}
+	if err := endorseKey(this.LogID, &key, this.signers[last.KeyID]); err != nil {
+		return nil, err
+	}
+	retireAt := notBefore.Add(overlap)
+	if last.NotAfter == nil || retireAt.Before(*last.NotAfter) {
+		last.NotAfter = &retireAt
+	}
+	this.Keys = append(this.Keys, key)
+	this.signers[key.KeyID] = signers[key.KeyID]
+	glog.Infof("logger (%v) rotates to key (%v) at %v, key (%v) retires at %v", this.LogID, key.KeyID,
+		notBefore.UTC().Format(time.RFC3339), last.KeyID, retireAt.UTC().Format(time.RFC3339))
+	return &key, nil
+}
+
+//Returns the key history signed by the current key
+func (this *Logger) GetKeyHistory() (*KeyHistory, error) {
+	this.RLock()
+	defer this.RUnlock()
+	now := time.Now()
+	key, signer, err := this.signingKeyAt(now)
+	if err != nil {
+		return nil, err
+	}
+	keyList := KeyList{
+		LogID:		this.LogID,
+		Timestamp:	uint64(now.Unix()),
+		Keys:		append([]LoggerKey{}, this.Keys...),
+	}
+	sig, err := signer.CreateSignature(keyList)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign key history: %v", err)
+	}
+	return &KeyHistory{Keys: keyList, KeyID: key.KeyID, Signature: *sig}, nil
+}
+
+//checks that the keys belong to logID and that every key after trustedKey is endorsed by the key before it.
+//Returns the index of trustedKey, only keys from that index on may sign for keys before them
+func verifyKeyChain(logID string, keys []LoggerKey, trustedKey string) (int, error) {
+	trustedKeyID, err := KeyIDFromPublicKey(trustedKey)
+	if err != nil {
+		return -1, fmt.Errorf("invalid trusted key: %v", err)
+	}
+	anchor := -1
+	for i := range keys {
+		keyID, err := KeyIDFromPublicKey(keys[i].PublicKey)
+		if err != nil || keyID != keys[i].KeyID {
+			return -1, fmt.Errorf("key id (%v) does not match its public key", keys[i].KeyID)
+		}
+		if i > 0 && keyBefore(&keys[i], &keys[i-1]) {
+			return -1, fmt.Errorf("keys are not ordered by validity")
+		}
+		if keyID == trustedKeyID {
+			anchor = i
+		}
+	}
+	if anchor < 0 {
+		return -1, fmt.Errorf("trusted key (%v) is not part of the key history of logger (%v)", trustedKeyID, logID)
+	}
+	for i := anchor + 1; i < len(keys); i++ {
+		var sig ct.DigitallySigned
+		if err := sig.FromBase64String(keys[i].Endorsement); err != nil {
+			return -1, fmt.Errorf("invalid endorsement of key (%v): %v", keys[i].KeyID, err)
+		}
+		if err := keys[i-1].verifySignature(keys[i].endorsement(logID), sig); err != nil {
+			return -1, fmt.Errorf("key (%v) is not endorsed by key (%v): %v", keys[i].KeyID, keys[i-1].KeyID, err)
+		}
+	}
+	return anchor, nil
+}
+
+//returns the key with keyID, it must not come before the anchor of the chain
+func findChainedKey(keys []LoggerKey, anchor int, keyID string) (*LoggerKey, error) {
+	for i := anchor; i < len(keys); i++ {
+		if keys[i].KeyID == keyID {
+			return &keys[i], nil
+		}
+	}
+	return nil, fmt.Errorf("key (%v) is not chained to the trusted key", keyID)
+}
+
+//Verifies a key history served by the logger starting from a trusted key of the logger, e.g. its key in the log list.
+//Returns the verified keys
+func VerifyKeyHistory(history *KeyHistory, logID, trustedKey string) ([]LoggerKey, error) {
+	if history.Keys.LogID != logID {
+		return nil, fmt.Errorf("key history of logger (%v) is not the key history of logger (%v)", history.Keys.LogID, logID)
+	}
+	anchor, err := verifyKeyChain(logID, history.Keys.Keys, trustedKey)
+	if err != nil {
+		return nil, err
+	}
+	key, err := findChainedKey(history.Keys.Keys, anchor, history.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("key history is signed by an untrusted key: %v", err)
+	}
+	if err := key.verifySignature(history.Keys, history.Signature); err != nil {
+		return nil, fmt.Errorf("invalid signature on key history: %v", err)
+	}
+	return history.Keys.Keys, nil
+}
+
+//Verifies an SRD signed by the logger against its key history. keyID names the signing key when known,
+//otherwise every key valid at the timestamp of the SRD is tried
+func VerifySRDWithKeys(logSRD *mtr.SRDWithRevData, keys []LoggerKey, keyID string) error {
+	_, err := signingKeyOfSRD(logSRD, keys, keyID)
+	return err
+}
+
+//same as VerifySRDWithKeys, also returns the key that signed the SRD
+func signingKeyOfSRD(logSRD *mtr.SRDWithRevData, keys []LoggerKey, keyID string) (*LoggerKey, error) {
+	srd := &logSRD.SRD
+	timestamp := time.Unix(int64(srd.RevDigest.Timestamp), 0)
+	tried := 0
+	for i := range keys {
+		if keyID != "" && keys[i].KeyID != keyID {
+			continue
+		}
+		if !keys[i].ValidAt(timestamp) {
+			if keyID != "" {
+				return nil, fmt.Errorf("key (%v) is not valid at the SRD timestamp %v", keyID, timestamp.UTC().Format(time.RFC3339))
+			}
+			continue
+		}
+		tried++
+		if err := keys[i].verifySignature(srd.RevDigest, srd.Signature); err == nil {
+			return &keys[i], nil
+		}
+	}
+	if tried == 0 {
+		if keyID != "" {
+			return nil, fmt.Errorf("unknown key (%v)", keyID)
+		}
+		return nil, fmt.Errorf("no key is valid at the SRD timestamp %v", timestamp.UTC().Format(time.RFC3339))
+	}
+	return nil, fmt.Errorf("SRD signature does not verify with any key valid at %v", timestamp.UTC().Format(time.RFC3339))
+}
+
+func (this *Logger) OnGetKeys(res http.ResponseWriter, req *http.Request) {
+	glog.Infof("new GetKeys request received")
+	history, err := this.GetKeyHistory()
+	if err != nil {
+		WriteError(res, fmt.Errorf("Unable to get key history: %w", err))
+		return;
+	}
+	jsonBytes, err := json.Marshal(history)
+	if err != nil {
+		WriteError(res, NewError(ErrorCodeInternal, err, "failed to Marshal key history"))
+		return;
+	}
+	res.Write(jsonBytes)
+}
@@ -0,0 +1,183 @@
+package logger
+
+import (
+	"testing"
+	"time"
+	mtr "github.com/n-ct/ct-monitor"
+	"github.com/n-ct/ct-logger/internal/testutil"
+)
+
+//function that has the logger accept a CA signed SRD revoking revoked, with a delta of delta, made at timestamp
+func mustUpdateAt(t *testing.T, logger *Logger, revoked, delta []uint64, timestamp time.Time) *mtr.SRDWithRevData {
+	t.Helper()
+	logSRD, err := logger.updateLogSRDWithRevData(testutil.CreateCASRD(t, revoked, delta, timestamp))
+	if err != nil {
+		t.Fatalf("logger failed to accept SRD: %v", err)
+	}
+	return logSRD
+}
+
+func TestKeyRotation(t *testing.T) {
+	logger, err := mustCreateLogger(t)
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+	oldKey := logger.Keys[0]
+	if _, err := logger.RotateKey(testutil.RotatedPrivateKey, time.Now().Add(-time.Hour), time.Hour); err == nil {
+		t.Fatalf("key should not be rotated in the past")
+	}
+	rotateAt := time.Now().Add(time.Hour)
+	newKey, err := logger.RotateKey(testutil.RotatedPrivateKey, rotateAt, 30*time.Minute)
+	if err != nil {
+		t.Fatalf("failed to rotate key: %v", err)
+	}
+
+	beforeSRD := mustUpdateAt(t, logger, []uint64{1,3}, []uint64{1,3}, time.Now())
+	logger.clock = func() time.Time { return rotateAt.Add(time.Minute) }
+	afterSRD := mustUpdateAt(t, logger, []uint64{1,3,5}, []uint64{5}, rotateAt.Add(time.Minute))
+
+	history, err := logger.GetKeyHistory()
+	if err != nil {
+		t.Fatalf("failed to get key history: %v", err)
+	}
+	keys, err := VerifyKeyHistory(history, logger.LogID, logger.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to verify key history: %v", err)
+	}
+	if len(keys) != 2 || keys[0].KeyID != oldKey.KeyID || keys[1].KeyID != newKey.KeyID {
+		t.Fatalf("key history should hold the old and the new key, got %v", keys)
+	}
+	if keys[0].NotAfter == nil || !keys[0].NotAfter.Equal(rotateAt.Add(30*time.Minute)) {
+		t.Fatalf("old key should retire after the overlap, got %v", keys[0].NotAfter)
+	}
+
+	if err := VerifySRDWithKeys(beforeSRD, keys, oldKey.KeyID); err != nil {
+		t.Fatalf("SRD from before the rotation should verify with the old key: %v", err)
+	}
+	if err := VerifySRDWithKeys(afterSRD, keys, newKey.KeyID); err != nil {
+		t.Fatalf("SRD from after the rotation should verify with the new key: %v", err)
+	}
+	if err := VerifySRDWithKeys(afterSRD, keys, ""); err != nil {
+		t.Fatalf("SRD should verify without a key id: %v", err)
+	}
+	if err := VerifySRDWithKeys(afterSRD, keys, oldKey.KeyID); err == nil {
+		t.Fatalf("SRD from after the rotation should not verify with the old key")
+	}
+
+	ctObject, err := logger.constructLogSRDCTObject(ca_id, afterSRD)
+	if err != nil {
+		t.Fatalf("failed to construct CTObject: %v", err)
+	}
+	if ctObject.KeyID != newKey.KeyID {
+		t.Fatalf("CTObject should name key %v not %v", newKey.KeyID, ctObject.KeyID)
+	}
+	//the subject of an SRD CTObject is its signer, the key does not replace it
+	if ctObject.Subject != "" || ctObject.Identifier().Second != logger.LogID {
+		t.Fatalf("CTObject should be identified by the logger, got %v", ctObject.Identifier())
+	}
+}
+
+//the CA picks the timestamp of an SRD, so it must not pick the key that signs it
+func TestSigningKeyFollowsLoggerClock(t *testing.T) {
+	logger, _ := mustCreateLogger(t)
+	oldKey := logger.Keys[0]
+	rotateAt := time.Now().Add(time.Hour)
+	if _, err := logger.RotateKey(testutil.RotatedPrivateKey, rotateAt, 30*time.Minute); err != nil {
+		t.Fatalf("failed to rotate key: %v", err)
+	}
+	//wide enough that only the validity of the keys decides
+	logger.MaxTimestampSkew = 3 * time.Hour
+
+	//a backdated SRD does not get the old key once it is retired
+	logger.clock = func() time.Time { return rotateAt.Add(time.Hour) }
+	backdated := testutil.CreateCASRD(t, []uint64{1,3}, []uint64{1,3}, rotateAt.Add(-time.Minute))
+	if _, err := logger.updateLogSRDWithRevData(backdated); err == nil {
+		t.Fatalf("SRD dated before the rotation should not be signed once the old key is retired")
+	}
+
+	//a future dated SRD does not get the new key before it is valid
+	logger.clock = nil
+	futureSRD := mustUpdateAt(t, logger, []uint64{1,3}, []uint64{1,3}, rotateAt.Add(time.Minute))
+	if err := VerifySRDWithKeys(futureSRD, logger.Keys, oldKey.KeyID); err != nil {
+		t.Fatalf("SRD dated after the rotation should be signed with the old key until the rotation: %v", err)
+	}
+}
+
+func TestVerifyKeyHistoryRejectsTampering(t *testing.T) {
+	logger, _ := mustCreateLogger(t)
+	if _, err := logger.RotateKey(testutil.RotatedPrivateKey, time.Now().Add(time.Hour), time.Hour); err != nil {
+		t.Fatalf("failed to rotate key: %v", err)
+	}
+	history, err := logger.GetKeyHistory()
+	if err != nil {
+		t.Fatalf("failed to get key history: %v", err)
+	}
+
+	//until the rotation the history is signed by the old key, which a client trusting only the new key can not trust
+	if _, err := VerifyKeyHistory(history, logger.LogID, logger.Keys[1].PublicKey); err == nil {
+		t.Fatalf("history signed by a key before the trusted key should not verify")
+	}
+	if _, err := VerifyKeyHistory(history, "other log", logger.PublicKey); err == nil {
+		t.Fatalf("history of another log should not verify")
+	}
+	if _, err := VerifyKeyHistory(history, logger.LogID, ca_key); err == nil {
+		t.Fatalf("history should not verify from a key that is not part of it")
+	}
+
+	tampered := *history
+	tampered.Keys.Keys = append([]LoggerKey{}, history.Keys.Keys...)
+	tampered.Keys.Keys[1].Endorsement = tampered.Keys.Keys[0].Endorsement
+	if _, err := VerifyKeyHistory(&tampered, logger.LogID, logger.PublicKey); err == nil {
+		t.Fatalf("history with a key not endorsed by its predecessor should not verify")
+	}
+
+	tampered.Keys.Keys = append([]LoggerKey{}, history.Keys.Keys...)
+	notAfter := time.Now().Add(24*time.Hour)
+	tampered.Keys.Keys[0].NotAfter = &notAfter
+	if _, err := VerifyKeyHistory(&tampered, logger.LogID, logger.PublicKey); err == nil {
+		t.Fatalf("history with a changed validity window should not verify")
+	}
+}
+
+func TestKeysFromConfig(t *testing.T) {
+	retireAt := time.Now().Add(-time.Hour)
+	rotatedAt := time.Now().Add(-2*time.Hour)
+	//listed newest first, NewLogger has to sort them
+	fileName := testutil.WriteConfig(t, config_filename, testutil.WithField("private_key", ""), testutil.WithField("keys", []KeyConfig{
+		{KeySource: KeySource{PrivKey: testutil.RotatedPrivateKey}, NotBefore: &rotatedAt},
+		{KeySource: KeySource{PrivKey: mustGetPrivateKey(t)}, NotAfter: &retireAt},
+	}))
+
+	logger, err := NewLogger(fileName, caList_filename, logList_filename)
+	if err != nil {
+		t.Fatalf("failed to create logger with keys: %v", err)
+	}
+	if len(logger.Keys) != 2 || logger.Keys[0].NotAfter == nil || logger.Keys[1].NotBefore == nil {
+		t.Fatalf("keys should be ordered by validity, got %v", logger.Keys)
+	}
+	srd := mustUpdateAt(t, logger, []uint64{1,3}, []uint64{1,3}, time.Now())
+	if err := VerifySRDWithKeys(srd, logger.Keys, logger.Keys[1].KeyID); err != nil {
+		t.Fatalf("SRD should be signed with the current key: %v", err)
+	}
+	if _, err := VerifyKeyHistory(mustGetKeyHistory(t, logger), logger.LogID, logger.PublicKey); err != nil {
+		t.Fatalf("failed to verify key history of configured keys: %v", err)
+	}
+}
+
+func mustGetPrivateKey(t *testing.T) string {
+	t.Helper()
+	config, err := parseLoggerConfig(config_filename)
+	if err != nil {
+		t.Fatalf("failed to parse logger config: %v", err)
+	}
+	return config.PrivKey
+}
+
+func mustGetKeyHistory(t *testing.T, logger *Logger) *KeyHistory {
+	t.Helper()
+	history, err := logger.GetKeyHistory()
+	if err != nil {
+		t.Fatalf("failed to get key history: %v", err)
+	}
+	return history
+}
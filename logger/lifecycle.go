@@ -0,0 +1,101 @@
+package logger
+
+import (
+	"time"
+	"net/http"
+	"encoding/json"
+	"github.com/golang/glog"
+	el "github.com/n-ct/ct-monitor/entitylist"
+)
+
+const (
+	GetStatusPath	= "/ct/v1/get-status"
+)
+
+//states of a log in the log list, empty when its entry has no state
+const (
+	LogStatePending		= "pending"
+	LogStateQualified	= "qualified"
+	LogStateUsable		= "usable"
+	LogStateReadOnly	= "readonly"
+	LogStateRetired		= "retired"
+	LogStateRejected	= "rejected"
+)
+
+var logStateNames = map[el.LogStatus] string{
+	el.PendingLogStatus:	LogStatePending,
+	el.QualifiedLogStatus:	LogStateQualified,
+	el.UsableLogStatus:		LogStateUsable,
+	el.ReadOnlyLogStatus:	LogStateReadOnly,
+	el.RetiredLogStatus:	LogStateRetired,
+	el.RejectedLogStatus:	LogStateRejected,
+}
+
+//returns the name of the state of a log list entry and when it began
+func logState(states *el.LogStates) (string, *time.Time) {
+	state, readOnlyState := states.Active()
+	if readOnlyState != nil {
+		state = &readOnlyState.LogState
+	}
+	if state == nil {
+		return "", nil
+	}
+	since := state.Timestamp
+	return logStateNames[states.LogStatus()], &since
+}
+
+//reports whether a logger in the state may be started, retired and rejected logs are over for good
+func startsInState(state string) bool {
+	return state != LogStateRetired && state != LogStateRejected
+}
+
+//returns an error if the logger must not sign an SRD with the given timestamp, caller must hold the lock.
+//A read only log keeps serving its SRDs but signs no new ones
+func (this *Logger) checkCanSign(timestamp uint64) error {
+	if !startsInState(this.LogState) || this.LogState == LogStateReadOnly {
+		return NewError(ErrorCodeNotSigning, nil, "logger (%v) is %v in the log list and signs no new SRDs", this.LogID, this.LogState)
+	}
+	if this.TemporalInterval == nil {
+		return nil
+	}
+	t := time.Unix(int64(timestamp), 0)
+	if t.Before(this.TemporalInterval.StartInclusive) || !t.Before(this.TemporalInterval.EndExclusive) {
+		return NewError(ErrorCodeOutsideInterval, nil, "SRD timestamp %v is outside the temporal interval [%v, %v) of logger (%v)", t.UTC().Format(time.RFC3339),
+			this.TemporalInterval.StartInclusive.UTC().Format(time.RFC3339), this.TemporalInterval.EndExclusive.UTC().Format(time.RFC3339), this.LogID)
+	}
+	return nil
+}
+
+//lifecycle of the logger as served on GetStatusPath
+type LoggerStatus struct {
+	LogID				string					`json:"log_id"`
+	State				string					`json:"state,omitempty"` //state in the log list, see LogStateUsable
+	StateSince			*time.Time				`json:"state_since,omitempty"`
+	TemporalInterval	*el.TemporalInterval	`json:"temporal_interval,omitempty"`
+	Signing				bool					`json:"signing"` //whether an SRD posted now would be signed
+	Follower			bool					`json:"follower"`
+}
+
+//Returns the lifecycle state of the logger
+func (this *Logger) GetStatus() *LoggerStatus {
+	this.RLock()
+	defer this.RUnlock()
+	return &LoggerStatus{
+		LogID:				this.LogID,
+		State:				this.LogState,
+		StateSince:			this.LogStateSince,
+		TemporalInterval:	this.TemporalInterval,
+		Signing:			this.checkCanSign(uint64(time.Now().Unix())) == nil,
+		Follower:			this.follower,
+	}
+}
+
+func (this *Logger) OnGetStatus(res http.ResponseWriter, req *http.Request) {
+	glog.Infof("new GetStatus request received")
+	jsonBytes, err := json.Marshal(this.GetStatus())
+	if err != nil {
+		WriteError(res, NewError(ErrorCodeInternal, err, "failed to Marshal status"))
+		return;
+	}
+	res.Write(jsonBytes)
+}
@@ -0,0 +1,103 @@
+package logger
+
+import (
+	"testing"
+	"strings"
+	"time"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	ctca "github.com/n-ct/ct-certificate-authority"
+	"github.com/n-ct/ct-logger/internal/testutil"
+)
+
+//function that writes the test log list with the logger of the test config in state to fileName
+func mustWriteLogListInState(t *testing.T, fileName, state string) {
+	t.Helper()
+	logListBytes, err := ioutil.ReadFile(logList_filename)
+	if err != nil {
+		t.Fatalf("failed to read log list: %v", err)
+	}
+	//the logger of the test config is the first entry of the log list
+	logList := strings.Replace(string(logListBytes), `"usable": {`, `"` + state + `": {`, 1)
+	if err := ioutil.WriteFile(fileName, []byte(logList), 0600); err != nil {
+		t.Fatalf("failed to write log list: %v", err)
+	}
+}
+
+func TestLogStateAtStartup(t *testing.T) {
+	for _, state := range []string{LogStateRetired, LogStateRejected} {
+		logListName := filepath.Join(t.TempDir(), "log_list.json")
+		mustWriteLogListInState(t, logListName, state)
+		if _, err := NewLogger(config_filename, caList_filename, logListName); err == nil {
+			t.Fatalf("%v logger should not start", state)
+		}
+	}
+
+	logListName := filepath.Join(t.TempDir(), "log_list.json")
+	mustWriteLogListInState(t, logListName, LogStateReadOnly)
+	logger, err := NewLogger(config_filename, caList_filename, logListName)
+	if err != nil {
+		t.Fatalf("read only logger should start: %v", err)
+	}
+	if err := mustUpdateLogSRDWithRevData(t, logger, ctca.CreateCRV([]uint64{1}, 0), ctca.GetCRVDelta([]uint64{1})); err == nil {
+		t.Fatalf("read only logger should not sign new SRDs")
+	}
+}
+
+func TestTemporalInterval(t *testing.T) {
+	logger, err := mustCreateLogger(t)
+	if err != nil {
+		t.Fatalf("failed to create Logger: %v", err)
+	}
+	interval := logger.TemporalInterval
+	for _, timestamp := range []time.Time{interval.StartInclusive.Add(-time.Second), interval.EndExclusive} {
+		logger.clock = func() time.Time { return timestamp } //the timestamps are fresh, only the interval rejects them
+		if err := logger.UpdateLogSRDWithRevData(testutil.CreateCASRD(t, []uint64{1}, []uint64{1}, timestamp)); err == nil {
+			t.Fatalf("SRD at %v outside the temporal interval should be rejected", timestamp)
+		}
+	}
+	logger.clock = func() time.Time { return interval.StartInclusive }
+	mustUpdateAt(t, logger, []uint64{1}, []uint64{1}, interval.StartInclusive)
+}
+
+func TestOnGetStatus(t *testing.T) {
+	logger, dir := mustCreateLoggerFromCopies(t)
+	serveMux, err := NewServeMux([]*Logger{logger}, nil)
+	if err != nil {
+		t.Fatalf("failed to create ServeMux: %v", err)
+	}
+	server := httptest.NewServer(serveMux)
+	defer server.Close()
+
+	getStatus := func() *LoggerStatus {
+		res, err := server.Client().Get(server.URL + logger.PathPrefix + GetStatusPath)
+		if err != nil {
+			t.Fatalf("failed to get status: %v", err)
+		}
+		defer res.Body.Close()
+		var status LoggerStatus
+		if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+			t.Fatalf("failed to decode status: %v", err)
+		}
+		return &status
+	}
+	status := getStatus()
+	if status.State != LogStateUsable || !status.Signing || status.TemporalInterval == nil || status.StateSince == nil {
+		t.Fatalf("usable logger should report that it signs, got %+v", status)
+	}
+
+	//retiring the logger in the log list stops it from signing without a restart
+	mustWriteLogListInState(t, filepath.Join(dir, "log_list.json"), LogStateRetired)
+	if err := logger.Reload(); err != nil {
+		t.Fatalf("failed to reload logger: %v", err)
+	}
+	status = getStatus()
+	if status.State != LogStateRetired || status.Signing {
+		t.Fatalf("retired logger should report that it does not sign, got %+v", status)
+	}
+	if err := mustUpdateLogSRDWithRevData(t, logger, ctca.CreateCRV([]uint64{1}, 0), ctca.GetCRVDelta([]uint64{1})); err == nil {
+		t.Fatalf("retired logger should not sign new SRDs")
+	}
+}
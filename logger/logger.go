@@ -2,19 +2,23 @@ package logger
 
 import (
 	"fmt"
+	"context"
 	"bytes"
-	"strings"
+	"errors"
+	"io"
 	"encoding/json"
 	"net/http"
 	"math/rand"
 	"os"
+	"sync"
+	"time"
 	"io/ioutil"
+	"path/filepath"
 	"github.com/golang/glog"
 	ba "github.com/Workiva/go-datastructures/bitarray"
 	mtr "github.com/n-ct/ct-monitor"
 	el "github.com/n-ct/ct-monitor/entitylist"
 	"github.com/n-ct/ct-monitor/signature"
-	"github.com/google/certificate-transparency-go/tls"
 	ca "github.com/n-ct/ct-certificate-authority/ca"
 	ctca "github.com/n-ct/ct-certificate-authority"
 )
@@ -29,20 +33,67 @@ const (
 type Logger struct {
 	//map to store the LogSRDWithRevData structs, map[CA ID][Revocation Type]
 	LogSRDWithRevDataMap	map[string] map[string] *mtr.SRDWithRevData
+	logSRDKeyIDs			map[*mtr.SRDWithRevData] string //ID of the key that signed each SRD of LogSRDWithRevDataMap, see setLogSRD
 	//map to store the CurrentCRV as a bitarray, map[CA ID][Revocation Type]
 	CurrentCRVMap			map[string] map[string] ba.BitArray
-	Address					string
+	Network					string //network to listen on, "tcp" or "unix"
+	Address					string //address to listen on, host:port or the path of a unix socket
+	PathPrefix				string //path of the log URL the endpoints are served under, e.g. /argon2020
 	LogID					string
-	Signer 					*signature.Signer
-	PublicKey				string
+	PublicKey				string //key of the logger in the log list, clients start trusting the key history from it
+	SignatureAlgorithm		string //algorithm advertised for the logger in the log list, empty if none is
+	LogState				string //state of the logger in the log list, see LogStateUsable
+	LogStateSince			*time.Time
+	TemporalInterval		*el.TemporalInterval //only SRDs with a timestamp in the interval are signed, unbounded when nil
+	MaxTimestampSkew		time.Duration //how far SRD timestamps may be ahead of the logger clock or behind the MMD of the CA, see ValidateSRDTimestamp
+	Keys					[]LoggerKey //signing keys ordered by validity, oldest first, see RotateKey
+	signers					map[string] Signer //signers of Keys, map[Key ID]
 	CAList 					*el.CAList //entitylist that stores all data about CAs
-	CAIDs   				[]string //list of CA ids used to index CAList
+	CAIDs   				[]string //list of CA ids used to index CAList, only these CAs may post SRDs
+	CAAuthMode				string //how callers posting SRDs are authenticated, see CAAuthNone
+	CAClientCertIDs			map[string] []string //base64 SHA-256 of client certificate public keys, map[CA ID]
+	OperatorKeys			[]string //base64 DER public keys operators sign requests to the state endpoints with, see authenticateOperator
+	OperatorCertIDs			[]string //base64 SHA-256 of the public keys of operator client certificates
+	Diagnostics				[]Diagnostic //warnings of the startup self-checks, see SelfCheck
+	metrics					*loggerMetrics //served on MetricsPath
+	audit					*AuditLog //accepted, rejected and signed SRDs, nil when the config sets no audit_log
+	tracer					*Tracer //spans of requests and of signing, nil when tracing is off, see SetTracer
+	probes					probeResults //latest signer and CA probes reported by CheckReadiness, see Probe
+	//CA signed SRDWithRevData accepted by the logger in the order they were accepted. The first historyCompacted are the latest
+	//of their CA and revocation type from before historyStart, the others have sequence numbers from historyStart on, see compactHistory
+	SRDHistory				[]*mtr.SRDWithRevData
+	MaxSRDHistory			int //uncompacted SRDs of SRDHistory, see appendHistory
+	historyStart			uint64 //sequence number of the first uncompacted SRD of SRDHistory
+	historyCompacted		int //compacted SRDs at the front of SRDHistory
+	sync.RWMutex // Mutex lock to prevent race conditions
+	reloadMu				sync.Mutex //serializes Reload, which reads the files without holding the lock above
+	follower				bool //true while replicating from a leader, see Follower
+	statePending			bool //true from AwaitState until the persistent state is imported, see IsReady
+	readySince				time.Time //when the logger was created or its state imported, CA MMDs are checked from it
+	clock					func() time.Time //the clock of the logger, time.Now unless replaced in tests, see now
+	draining				bool //true once Drain was called, no SRDs are applied after
+	updated					chan struct{} //closed when a new SRD is accepted, see updateSignal
+	updatedMu				sync.Mutex //guards updated, which is also replaced under the read lock
+	configName				string //files the logger was created from, read again by Reload
+	caListName				string
+	logListName				string
+	auditLogName			string
 }
 
 type LoggerConfig struct {
 	LogID	string		`json:"log_id"`
-	PrivKey	string		`json:"private_key"`
+	KeySource	//signing key of the logger, see KeySource
+	Keys	[]KeyConfig	`json:"keys"` //signing keys with validity windows, used besides private_key
 	CAIDs   []string	`json:"ca_ids"`
+	CAAuthMode		string				`json:"ca_auth_mode"`
+	//overrides the address derived from the log URL, e.g. ":6966", "[::1]:6966" or "unix:/run/ct-logger.sock"
+	ListenAddress	string				`json:"listen_address"`
+	CAClientCertIDs	map[string][]string	`json:"ca_client_cert_ids"`
+	OperatorKeys	[]string			`json:"operator_keys"` //may export and import the state, see authenticateOperator
+	OperatorCertIDs	[]string			`json:"operator_cert_ids"`
+	AuditLog		string				`json:"audit_log"` //file the audit records are appended to, relative to the config
+	MaxTimestampSkew	uint64			`json:"max_timestamp_skew"` //seconds, DefaultMaxTimestampSkew when 0
+	MaxSRDHistory	int					`json:"max_srd_history"` //DefaultMaxSRDHistory when 0
 }
 
 func parseLoggerConfig(fileName string) (*LoggerConfig, error){
@@ -67,132 +118,271 @@ func parseLoggerConfig(fileName string) (*LoggerConfig, error){
 
 //creates and returns a new Relying party type
 func NewLogger(configName, caListName, logListName string) (*Logger, error){
-	caList, err := el.NewCAList(caListName)
+	logger, logList, err := loadLogger(configName, caListName, logListName)
 	if err != nil {
 		return nil, err
 	}
+	if !startsInState(logger.LogState) {
+		return nil, fmt.Errorf("logger (%v) is %v in the log list at [%v], refusing to start", logger.LogID, logger.LogState, logListName)
+	}
+	//fail fast on keys and CAs that would make every SRD of the logger unverifiable
+	logger.Diagnostics = logger.SelfCheck(logList)
+	if err := checkDiagnostics(logger.Diagnostics); err != nil {
+		return nil, err
+	}
+	if logger.auditLogName != "" {
+		if logger.audit, err = OpenAuditLog(logger.auditLogName); err != nil {
+			return nil, err
+		}
+	}
+	return logger, nil
+}
+
+//creates a logger from its files without running the self-checks, also returns the log list it was created from
+func loadLogger(configName, caListName, logListName string) (*Logger, *el.LogList, error) {
+	caList, err := el.NewCAList(caListName)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	logList, err := el.NewLogList(logListName)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	config, err := parseLoggerConfig(configName)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	logInfo := logList.FindLogByLogID(config.LogID)
 	if (logInfo == nil) {
-		return nil, fmt.Errorf("Logger with id: [%v] not found in log list at: [%v]", config.LogID, logListName)
+		return nil, nil, fmt.Errorf("Logger with id: [%v] not found in log list at: [%v]", config.LogID, logListName)
 	}
 
-	URL := logInfo.URL
-	if strings.Index(URL, "http://") == 0 {
-		URL = URL[7:len(URL)-1]
+	//the listen address comes from the log URL unless the config overrides it
+	listenAddress := logInfo.URL
+	if config.ListenAddress != "" {
+		listenAddress = config.ListenAddress
 	}
-	if strings.Index(URL, "https://") == 0 {
-		URL = URL[8:len(URL)-1]
+	network, address, err := ResolveListenAddress(listenAddress)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error resolving listen address of logger: %w", err)
 	}
-	if URL[len(URL)-1] == '/' { //if the last char of the url is a '/' remove it
-		URL = URL[0:len(URL)-1]
+	pathPrefix, err := ResolvePathPrefix(logInfo.URL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error resolving path prefix of logger: %w", err)
 	}
-	i := strings.Index(URL, ":")
-	if i >= 0 {
-		URL = fmt.Sprintf("%v:6966", URL[0:i])
+	if config.CAAuthMode == "" {
+		config.CAAuthMode = CAAuthNone
 	}
-	signer,err := signature.NewSigner(config.PrivKey)
+	if !validCAAuthMode(config.CAAuthMode) {
+		return nil, nil, fmt.Errorf("invalid ca_auth_mode %q in logger config", config.CAAuthMode)
+	}
+	keyConfigs := config.Keys
+	if config.KeySource.isSet() {
+		keyConfigs = append([]KeyConfig{{KeySource: config.KeySource}}, keyConfigs...)
+	}
+	keys, signers, err := newLoggerKeys(config.LogID, keyConfigs, filepath.Dir(configName))
+	if err != nil {
+		return nil, nil, err
+	}
+	signatureAlgorithm, err := LogSignatureAlgorithm(logListName, config.LogID)
 	if err != nil {
-		return nil, fmt.Errorf("error creating signer for logger: %v", err)
+		return nil, nil, err
+	}
+
+	state, stateSince := logState(logInfo.State)
+	var auditLogName string
+	if config.AuditLog != "" {
+		auditLogName = resolvePath(filepath.Dir(configName), config.AuditLog)
+	}
+	maxTimestampSkew := DefaultMaxTimestampSkew
+	if config.MaxTimestampSkew > 0 {
+		maxTimestampSkew = time.Duration(config.MaxTimestampSkew) * time.Second
+	}
+	maxSRDHistory := DefaultMaxSRDHistory
+	if config.MaxSRDHistory > 0 {
+		maxSRDHistory = config.MaxSRDHistory
 	}
 
 	logger := &Logger{
-		Address:	URL,
+		Network:	network,
+		Address:	address,
+		PathPrefix:	pathPrefix,
 		LogID: 		config.LogID,
-		Signer:		signer,
+		Keys:		keys,
+		signers:	signers,
 		PublicKey:	logInfo.Key,
+		SignatureAlgorithm:	signatureAlgorithm,
+		LogState:	state,
+		LogStateSince:	stateSince,
+		TemporalInterval:	logInfo.TemporalInterval,
+		MaxTimestampSkew:	maxTimestampSkew,
+		MaxSRDHistory:	maxSRDHistory,
 		CAList:		caList,
 		CAIDs:		config.CAIDs,
-	}
-	return logger, nil
+		CAAuthMode:	config.CAAuthMode,
+		CAClientCertIDs:	config.CAClientCertIDs,
+		OperatorKeys:	config.OperatorKeys,
+		OperatorCertIDs:	config.OperatorCertIDs,
+		configName:	configName,
+		caListName:	caListName,
+		logListName:	logListName,
+		auditLogName:	auditLogName,
+		metrics:	newLoggerMetrics(caList),
+		readySince:	time.Now(),
+	}
+	return logger, logList, nil
 }
 
-func (this *Logger) createNewMMDSRDWithRevData(data *mtr.SRDWithRevData) (*mtr.SRDWithRevData, error) {
+//verifies the CA signed SRD data and applies its delta, returns the logger signed SRD and the ID of the key that signed it
+func (this *Logger) createNewMMDSRDWithRevData(ctx context.Context, data *mtr.SRDWithRevData) (*mtr.SRDWithRevData, string, error) {
 	newSRD := &data.SRD //cast the CTObject to a SRD
 	newRevData := &data.RevData //cast the CTObject to a RevData
 	caID := newSRD.EntityID
 	caInfo := this.CAList.FindCAByCAID(caID)
 	if caInfo == nil {
-		return nil, fmt.Errorf("caID (%v) not found in caInfoMap", caID)
+		return nil, "", NewError(ErrorCodeUnknownCA, nil, "caID (%v) not found in caInfoMap", caID).With("ca_id", caID)
+	}
+	if !this.allowsCA(caID) {
+		return nil, "", NewError(ErrorCodeCANotAllowed, nil, "caID (%v) is not in the CAIDs of this logger", caID).With("ca_id", caID)
+	}
+	if err := this.checkCanSign(newSRD.RevDigest.Timestamp); err != nil {
+		return nil, "", err
+	}
+	//a CA can not roll its revocations back by posting an older SRD again
+	if current := this.LogSRDWithRevDataMap[caID][newRevData.RevocationType]; current != nil && newSRD.RevDigest.Timestamp < current.SRD.RevDigest.Timestamp {
+		return nil, "", NewError(ErrorCodeStale, nil, "SRD timestamp %v is older than the current SRD of caID (%v) at %v",
+			newSRD.RevDigest.Timestamp, caID, current.SRD.RevDigest.Timestamp).With("ca_id", caID)
+	}
+	//a follower replays SRDs the leader checked when they were fresh
+	if !this.follower {
+		if err := ValidateSRDTimestamp(newSRD.RevDigest.Timestamp, caInfo.MMD, this.now(), this.MaxTimestampSkew); err != nil {
+			return nil, "", NewError(ErrorCodeTimestampOutOfRange, err, "Invalid timestamp").With("ca_id", caID)
+		}
+	}
+	//picked before the CRV is touched, an SRD no key may sign leaves it unchanged
+	key, signer, err := this.signingKeyForSRD(this.now(), newSRD.RevDigest.Timestamp)
+	if err != nil {
+		return nil, "", err
 	}
 	caKey := caInfo.CAKey
 
-	err := ca.VerifySRDSignature(newSRD, caKey) //verify the signature on the object
+	_, span := this.startSpan(ctx, "verify-ca-signature", SpanKindInternal)
+	err = ca.VerifySRDSignature(newSRD, caKey) //verify the signature on the object
+	span.SetError(err)
+	span.Finish()
 	if err != nil {
-		return nil, fmt.Errorf("Invalid signature: %v", err) // if there is an eror report
+		return nil, "", NewError(ErrorCodeInvalidSignature, err, "Invalid signature").With("ca_id", caID) // if there is an eror report
 	}
 
+	_, span = this.startSpan(ctx, "decompress", SpanKindInternal)
 	deltaCRV, err := ctca.DecompressCRV(newRevData.CRVDelta) //decompress the delta CRV
+	span.SetError(err)
+	span.Finish()
 	if err != nil {
-		return nil, fmt.Errorf("Invalid compression on delta CRV: %v", err) // if there is an eror report
+		return nil, "", NewError(ErrorCodeInvalidCompression, err, "Invalid compression on delta CRV").With("ca_id", caID) // if there is an eror report
 	}
 
-	if this.CurrentCRVMap == nil { //if this is the first post request made
-		this.CurrentCRVMap = make(map[string]map[string] ba.BitArray)
-	}
-	if this.CurrentCRVMap[newSRD.EntityID] == nil { //if this is the first post request made by this entity
-		this.CurrentCRVMap[newSRD.EntityID] = make(map[string] ba.BitArray)
-		this.CurrentCRVMap[newSRD.EntityID][newRevData.RevocationType] = ba.NewBitArray((*deltaCRV).Capacity()) // create a new bit array the same size as the deltaCRV
+	_, span = this.startSpan(ctx, "hash", SpanKindInternal)
+	currentCRV, ok := this.CurrentCRVMap[newSRD.EntityID][newRevData.RevocationType] //get the current CRV, with the current rev type for the requesting ca
+	if !ok { //if this is the first post request made by this entity
+		currentCRV = ba.NewBitArray((*deltaCRV).Capacity()) // create a new bit array the same size as the deltaCRV
 	}
 
-	currentCRV := this.CurrentCRVMap[newSRD.EntityID][newRevData.RevocationType] //get the current CRV, with the current rev type for the requesting ca
-
 	NewCRV := ctca.ApplyCRVDeltaToCRV(&currentCRV, deltaCRV) //apply the delta crv to the crv
 
 	compCRV, err := ctca.CompressCRV(NewCRV) //compress and hash the new CRV to make sure it is consistant
-	crvHash, _, err := signature.GenerateHash(tls.SHA256, compCRV)
+	crvHash, _, err := signature.GenerateHash(newSRD.Signature.Algorithm.Hash, compCRV) //the CA hashes with the hash of its signature
+	span.SetError(err)
+	span.Finish()
 	if err != nil {
-		return nil, fmt.Errorf("Error Hashing CRV: %v", err) // if there is an eror report
+		return nil, "", fmt.Errorf("Error Hashing CRV: %v", err) // if there is an eror report
 	}
 
-	if bytes.Compare(newSRD.RevDigest.CRVHash, crvHash) == 0 { //if delta CRV is consistant
-		this.CurrentCRVMap[newSRD.EntityID][newRevData.RevocationType] = *NewCRV //update the curr CRV
-	} else {
-		return nil, fmt.Errorf("Inconsistant delta CRV: %v + %v", newSRD.RevDigest.CRVHash, crvHash) // if there is an eror report
+	if bytes.Compare(newSRD.RevDigest.CRVHash, crvHash) != 0 { //if delta CRV is not consistant
+		return nil, "", NewError(ErrorCodeInconsistentDelta, nil, "Inconsistant delta CRV: %v + %v", newSRD.RevDigest.CRVHash, crvHash).With("ca_id", caID) // if there is an eror report
 	}
 
-	newMMDSRD, err := ca.CreateSRDWithRevData(
+	_, span = this.startSpan(ctx, "sign", SpanKindInternal)
+	defer span.Finish()
+	span.SetAttribute("key_id", key.KeyID)
+	logSRD, err := createLogSRDWithRevData(
 		&currentCRV, deltaCRV,
 		newSRD.RevDigest.Timestamp,
 		this.LogID,
-		tls.SHA256,
-		this.Signer,
+		signer,
 	)
-	return newMMDSRD, err
+	span.SetError(err)
+	if err != nil {
+		return nil, "", err
+	}
+
+	//only an SRD the logger signed moves the CRV on
+	if this.CurrentCRVMap == nil { //if this is the first post request made
+		this.CurrentCRVMap = make(map[string]map[string] ba.BitArray)
+	}
+	if this.CurrentCRVMap[newSRD.EntityID] == nil { //if this is the first post request made by this entity
+		this.CurrentCRVMap[newSRD.EntityID] = make(map[string] ba.BitArray)
+	}
+	this.CurrentCRVMap[newSRD.EntityID][newRevData.RevocationType] = *NewCRV //update the curr CRV
+	return logSRD, key.KeyID, nil
+}
+
+//returns the current time by the clock of the logger
+func (this *Logger) now() time.Time {
+	if this.clock != nil {
+		return this.clock()
+	}
+	return time.Now()
 }
 
 func (this *Logger) UpdateLogSRDWithRevData(data *mtr.SRDWithRevData) error {
-	newSRDWithRevData, err := this.createNewMMDSRDWithRevData(data)
-	if err != nil {
-		return fmt.Errorf("failed to create newMMDSRD: %w", err)
+	_, err := this.updateLogSRDWithRevData(data)
+	return err
+}
+
+func (this *Logger) updateLogSRDWithRevData(data *mtr.SRDWithRevData) (*mtr.SRDWithRevData, error) {
+	return this.updateLogSRDWithRevDataFrom(context.Background(), data, "")
+}
+
+//verifies and applies a CA signed SRDWithRevData sent by caller and returns the new logger signed SRDWithRevData.
+//Its spans are children of the current span in ctx
+func (this *Logger) updateLogSRDWithRevDataFrom(ctx context.Context, data *mtr.SRDWithRevData, caller string) (*mtr.SRDWithRevData, error) {
+	this.Lock()
+	defer this.Unlock()
+	if this.draining {
+		err := NewError(ErrorCodeShuttingDown, nil, "Logger is shutting down").With("ca_id", data.SRD.EntityID)
+		this.recordSRD(data, nil, "", caller, err)
+		return nil, err
 	}
 	newSRD := &data.SRD //cast the CTObject to a SRD
 	newRevData := &data.RevData //cast the CTObject to a RevData
-
-	if this.LogSRDWithRevDataMap == nil {
-		this.LogSRDWithRevDataMap = make(map[string]map[string] *mtr.SRDWithRevData)
-	}
-	if this.LogSRDWithRevDataMap[newSRD.EntityID] == nil {
-		this.LogSRDWithRevDataMap[newSRD.EntityID] = make(map[string] *mtr.SRDWithRevData)
+	prevCRV, hadCRV := this.CurrentCRVMap[newSRD.EntityID][newRevData.RevocationType]
+	newSRDWithRevData, keyID, err := this.createNewMMDSRDWithRevData(ctx, data)
+	auditErr := this.recordSRD(data, newSRDWithRevData, keyID, caller, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create newMMDSRD: %w", err)
+	}
+	//signing fails closed: an SRD the audit log does not hold is not handed out, and the CRV goes back to before it
+	if auditErr != nil {
+		if hadCRV {
+			this.CurrentCRVMap[newSRD.EntityID][newRevData.RevocationType] = prevCRV
+		} else {
+			delete(this.CurrentCRVMap[newSRD.EntityID], newRevData.RevocationType)
+			if len(this.CurrentCRVMap[newSRD.EntityID]) == 0 {
+				delete(this.CurrentCRVMap, newSRD.EntityID)
+			}
+		}
+		return nil, NewError(ErrorCodeInternal, auditErr, "failed to write the audit log, SRD not signed").With("ca_id", newSRD.EntityID)
 	}
 
 	//update the SRDWithRevDataMap
-	this.LogSRDWithRevDataMap[newSRD.EntityID][newRevData.RevocationType] = newSRDWithRevData
-	if err != nil {
-		return fmt.Errorf("Error Updating SRDWithRevData: %v", err) // if there is an eror report
-	}
-	this.LogSRDWithRevDataMap[newSRD.EntityID][newRevData.RevocationType].RevData.RevocationType = "Let's-Revoke" //update the revocation type
-	return nil //if get to the end ther are no errors
+	this.setLogSRD(newSRD.EntityID, newRevData.RevocationType, newSRDWithRevData, keyID)
+	this.appendHistory(data)
+	this.signalUpdate()
+	return newSRDWithRevData, nil //if get to the end ther are no errors
 }
 
 /*func (this *Logger) UpdateLogSRDWithRevData(data *mtr.SRDWithRevData) error {
@@ -258,36 +448,66 @@ func (this *Logger) UpdateLogSRDWithRevData(data *mtr.SRDWithRevData) error {
 	if err != nil {
 		return fmt.Errorf("Error Updating SRDWithRevData: %v", err) // if there is an eror report
 	}
-	this.LogSRDWithRevDataMap[newSRD.EntityID][newRevData.RevocationType].RevData.RevocationType = "Let's-Revoke" //update the revocation type
 	return nil //if get to the end ther are no errors
 }
 */
 
 func (this *Logger) OnPostLogSRDWithRevData(res http.ResponseWriter, req *http.Request) {
 	glog.Infof("new PostLogSRDWithRevData request received")
+	if err := this.checkAccepting(); err != nil {
+		WriteError(res, err)
+		return;
+	}
+	_, span := this.startSpan(req.Context(), "decode", SpanKindInternal)
+	body, err := ioutil.ReadAll(req.Body) //keep the raw body, request signatures are made over it
 	data := mtr.SRDWithRevData{}; //create an empty CTObject
-	err := json.NewDecoder(req.Body).Decode(&data); // fill that struct using the JSON encoded struct send via the Post
+	if err == nil {
+		err = json.Unmarshal(body, &data); // fill that struct using the JSON encoded struct send via the Post
+	}
+	span.SetError(err)
+	span.Finish()
 	if err != nil {
-		http.Error(res, fmt.Sprintf("Invalid data sent via post: %v", err), http.StatusBadRequest) // if there is an eror report and abort
+		WriteError(res, NewError(ErrorCodeInvalidRequest, err, "Invalid data sent via post")) // if there is an eror report and abort
 		return;
 	}
-	err = this.UpdateLogSRDWithRevData(&data); //update with the given data
+	err = ValidateSRDWithRevData(&data) //reject malformed SRDs before verifying any signature
 	if err != nil {
-		http.Error(res, fmt.Sprintf("Unable to Update: %v", err), http.StatusBadRequest) // if there is an eror report and abort
+		logRejectedCaller(req, data.SRD.EntityID, err)
+		this.recordUnauthenticated(&data, describeCaller(req), err)
+		WriteError(res, err)
+		return;
+	}
+	err = this.authenticateCAPoster(req, body, data.SRD.EntityID)
+	if err != nil {
+		logRejectedCaller(req, data.SRD.EntityID, err)
+		var loggerErr *Error
+		if !errors.As(err, &loggerErr) {
+			err = NewError(ErrorCodeUnauthorized, err, "Unauthorized").With("ca_id", data.SRD.EntityID)
+		}
+		this.recordUnauthenticated(&data, describeCaller(req), err)
+		WriteError(res, err)
+		return;
+	}
+	_, err = this.updateLogSRDWithRevDataFrom(req.Context(), &data, describeCaller(req)); //update with the given data
+	if err != nil {
+		logRejectedCaller(req, data.SRD.EntityID, err)
+		WriteError(res, fmt.Errorf("Unable to Update: %w", err)) // if there is an eror report and abort
 		return;
 	}
 }
 
 func (this *Logger) GetAllLogSrdWithRevDataAsJSONBytes() ([]byte,error) {
+	this.RLock()
+	defer this.RUnlock()
 	if this.LogSRDWithRevDataMap == nil { //if the SRDmap hasnt been created yet report that to the caller and return
-		return nil, fmt.Errorf("SRDWithRevData is still being created")
+		return nil, NewError(ErrorCodeNotReady, nil, "SRDWithRevData is still being created")
 	}
 
-	var CTObjects = []mtr.CTObject{} //create an empty slice to hold ctobjects
+	var CTObjects = []LogSRDCTObject{} //create an empty slice to hold ctobjects
 
-	for _, element := range this.LogSRDWithRevDataMap {
+	for caID, element := range this.LogSRDWithRevDataMap {
 		LogSRDWithRevData := element["Let's-Revoke"]
-		CTObject, err := mtr.ConstructCTObject(LogSRDWithRevData)
+		CTObject, err := this.constructLogSRDCTObject(caID, LogSRDWithRevData)
 		if err != nil {
 			return nil, fmt.Errorf("Error Generating LogSRD objects: %v", err) // if there is an eror report
 		}
@@ -305,7 +525,7 @@ func (this *Logger) OnGetLogSRDWithRevData(res http.ResponseWriter, req *http.Re
 	glog.Infof("new GetLogSRDWithRevData request received")
 	jsonBytes, err := this.GetAllLogSrdWithRevDataAsJSONBytes()
 	if err != nil {
-		http.Error(res, fmt.Sprintf("%v", err), http.StatusBadRequest) // if there is an eror report and abort
+		WriteError(res, err) // if there is an eror report and abort
 		return;
 	}
 	res.Write(jsonBytes)
@@ -313,46 +533,50 @@ func (this *Logger) OnGetLogSRDWithRevData(res http.ResponseWriter, req *http.Re
 
 func (this *Logger) OnRevokeAndProduceSRD(res http.ResponseWriter, req *http.Request) {
 	glog.Infof("new RevokeAndProduceSRD request received")
+	if err := this.checkAccepting(); err != nil {
+		WriteError(res, err)
+		return;
+	}
+	_, span := this.startSpan(req.Context(), "decode", SpanKindInternal)
 	data := ctca.RevokeAndProduceSRDRequest{}; //create an empty CTObject
 	err := json.NewDecoder(req.Body).Decode(&data); // fill that struct using the JSON encoded struct send via the Post
+	span.SetError(err)
+	span.Finish()
 	if err != nil {
-		http.Error(res, fmt.Sprintf("Invalid data sent via post: %v", err), http.StatusBadRequest) // if there is an eror report and abort
+		WriteError(res, NewError(ErrorCodeInvalidRequest, err, "Invalid data sent via post")) // if there is an eror report and abort
+		return;
+	}
+	if data.PercentRevoked > 100 {
+		WriteError(res, NewError(ErrorCodeInvalidRequest, nil, "PercentRevoked %v is more than 100", data.PercentRevoked))
 		return;
 	}
 
 	glog.Infof("randomCAINfo")
 	ca := this.GetRandomCAInfoFromCaList()
-	if err != nil {
-		http.Error(res, fmt.Sprintf("No CAs to forward message to"), http.StatusBadRequest) // if there is an eror report and abort
+	if ca == nil {
+		WriteError(res, NewError(ErrorCodeCAUnavailable, nil, "No CAs to forward message to")) // if there is an eror report and abort
 		return;
 	}
 
 	//fmt.Println(data)
 	glog.Infof("start sending to ca")
-	jsonBytes, err := json.Marshal(data)	// Just use serialize method somewhere else
-	caReq, err := http.NewRequest("GET", fmt.Sprintf("%v%v", ca.CAURL, RevokeAndProduceSRDPath), bytes.NewBuffer(jsonBytes))
-	client := &http.Client{};
-	caResp, err := client.Do(caReq);
-	if err != nil {
-		panic(err);
-	}
-	defer caResp.Body.Close();
-	//body, err := ioutil.ReadAll(caResp.Body)
-	caData := mtr.SRDWithRevData{}; //create an empty CTObject
-	err = json.NewDecoder(caResp.Body).Decode(&caData); // fill that struct using the JSON encoded struct send via the Post
-	if err != nil {
-		http.Error(res, fmt.Sprintf("Invalid data sent via post: %v", err), http.StatusBadRequest) // if there is an eror report and abort
+	caData, forwardErr := this.forwardToCA(req.Context(), ca, &data)
+	if forwardErr != nil {
+		this.rejectForward(res, ca.CAID, forwardErr)
 		return;
 	}
+	this.metrics.countForward(ca.CAID, nil)
 
-	newLogSRD, err := this.createNewMMDSRDWithRevData(&caData)
+	newLogSRD, err := this.updateLogSRDWithRevDataFrom(req.Context(), caData, describeCaller(req))
 	if err != nil {
-		http.Error(res, fmt.Sprintf("failed to create SRD in Logger: %v", err), http.StatusBadRequest) // if there is an eror report and abort
+		WriteError(res, fmt.Errorf("failed to create SRD in Logger: %w", err)) // if there is an eror report and abort
 		return;
 	}
-	srdCTObject, err := mtr.ConstructCTObject(newLogSRD)
+	this.RLock()
+	srdCTObject, err := this.constructLogSRDCTObject(caData.SRD.EntityID, newLogSRD)
+	this.RUnlock()
 	if err != nil {
-		http.Error(res, fmt.Sprintf("failed to construct CTObject of SRD in Logger: %v", err), http.StatusBadRequest) // if there is an eror report and abort
+		WriteError(res, fmt.Errorf("failed to construct CTObject of SRD in Logger: %w", err)) // if there is an eror report and abort
 		return;
 	}
 	newCTObjSRDBytes, err := json.Marshal(*srdCTObject)	// Just use serialize method somewhere else
@@ -360,7 +584,59 @@ func (this *Logger) OnRevokeAndProduceSRD(res http.ResponseWriter, req *http.Req
 	res.Write(newCTObjSRDBytes)
 }
 
+//sends a revoke request to the CA and returns the SRD it answers with, errors are ca_unavailable unless the logger failed
+func (this *Logger) forwardToCA(ctx context.Context, ca *el.CAInfo, data *ctca.RevokeAndProduceSRDRequest) (*mtr.SRDWithRevData, *Error) {
+	ctx, span := this.startSpan(ctx, "forward", SpanKindClient)
+	defer span.Finish()
+	span.SetAttribute("ca_id", ca.CAID)
+	fail := func(err *Error) (*mtr.SRDWithRevData, *Error) {
+		span.SetError(err)
+		return nil, err
+	}
+	jsonBytes, err := json.Marshal(data)	// Just use serialize method somewhere else
+	if err != nil {
+		return fail(NewError(ErrorCodeInternal, err, "failed to Marshal request to CA"))
+	}
+	//the CA serves revoke-and-produce-srd on GET only, so the request is sent as the body of a GET
+	caReq, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%v%v", ca.CAURL, RevokeAndProduceSRDPath), bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		return fail(NewError(ErrorCodeInternal, err, "failed to create request to CA"))
+	}
+	caReq.Header.Set("Content-Type", "application/json")
+	InjectTraceContext(ctx, caReq.Header)
+	client := &http.Client{};
+	caResp, err := client.Do(caReq);
+	if err != nil {
+		return fail(NewError(ErrorCodeCAUnavailable, err, "failed to forward request to CA"))
+	}
+	defer caResp.Body.Close();
+	span.SetAttribute("http.status_code", fmt.Sprint(caResp.StatusCode))
+	if caResp.StatusCode != http.StatusOK {
+		return fail(NewError(ErrorCodeCAUnavailable, nil, "CA responded with %v", caResp.Status))
+	}
+	caData := mtr.SRDWithRevData{}; //create an empty CTObject
+	err = json.NewDecoder(io.LimitReader(caResp.Body, MaxSRDBodySize)).Decode(&caData); // fill that struct using the JSON encoded struct send via the Post
+	if err != nil {
+		return fail(NewError(ErrorCodeCAUnavailable, err, "Invalid data sent by CA")) // if there is an eror report and abort
+	}
+	if err := ValidateSRDWithRevData(&caData); err != nil {
+		return fail(NewError(ErrorCodeCAUnavailable, err, "Invalid SRD sent by CA"))
+	}
+	return &caData, nil
+}
+
+//answers a revoke request whose forwarding to the CA failed and counts the failure
+func (this *Logger) rejectForward(res http.ResponseWriter, caID string, err *Error) {
+	this.metrics.countForward(caID, err)
+	WriteError(res, err.With("ca_id", caID))
+}
+
 func (this *Logger) GetRandomCAInfoFromCaList() (*el.CAInfo){
+	this.RLock()
+	defer this.RUnlock()
+	if len(this.CAIDs) == 0 {
+		return nil
+	}
 	i := rand.Intn(len(this.CAIDs))
 	return this.CAList.FindCAByCAID(this.CAIDs[i]);
 }
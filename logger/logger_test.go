@@ -13,6 +13,7 @@ import (
 	"github.com/Workiva/go-datastructures/bitarray"
 	"github.com/google/certificate-transparency-go/tls"
 	"github.com/n-ct/ct-monitor/signature"
+	"github.com/n-ct/ct-logger/internal/testutil"
 )
 //"ca_id": "LeYXK29QzQV9RxvgMw+hnOeyZV85A6a5quOLltev9H0=",
 //"ca_key": "MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEmFk6QT48Ts4oxSkBPM4mQ/mnWICKVmZUP6urQVBH0vhDzJVYHc2ShvF2KjWzorVu2C+tY6lIU+61iiPLsGvZXw==",
@@ -112,6 +113,25 @@ func TestUpdateLogSRDWithRevData(t *testing.T) {
 	}
 }
 
+//an SRD the logger fails to sign must not move the CRV on
+func TestSigningErrorKeepsCRV(t *testing.T) {
+	logger, _ := mustCreateLogger(t)
+	mustUpdateAt(t, logger, []uint64{1,3}, []uint64{1,3}, time.Now())
+	crv := logger.CurrentCRVMap[ca_id]["Let's-Revoke"]
+	before, _ := ctca.CompressCRV(&crv)
+
+	key := logger.Keys[len(logger.Keys) - 1]
+	logger.signers[key.KeyID] = &failingSigner{logger.signers[key.KeyID]}
+	if err := logger.UpdateLogSRDWithRevData(testutil.CreateCASRD(t, []uint64{1,3,4,5,7}, []uint64{4,5,7}, time.Now().Add(time.Second))); err == nil {
+		t.Fatalf("SRD should not be accepted when signing fails")
+	}
+	crv = logger.CurrentCRVMap[ca_id]["Let's-Revoke"]
+	after, _ := ctca.CompressCRV(&crv)
+	if !bytes.Equal(before, after) {
+		t.Fatalf("failed signature should leave the CRV unchanged")
+	}
+}
+
 func TestGetAllLogSrdWithRevDataAsJSONBytes(t *testing.T) {
 	logger, _ := mustCreateLogger(t)
 
@@ -163,3 +183,20 @@ func TestOnRevokeAndProduceSRD(t *testing.T) {
 	deltaCRV := ctca.GetCRVDelta([]uint64{1,3}) //000 ==> 101
 	mustUpdateLogSRDWithRevData(t, logger, crv, deltaCRV)
 }
+
+//a CA verifies logger SRDs the way the logger verifies the SRDs of a CA
+func TestLogSRDVerifiesAsSRD(t *testing.T) {
+	logger, _ := mustCreateLogger(t)
+	srd := mustUpdateAt(t, logger, []uint64{1,3}, []uint64{1,3}, time.Now())
+	if err := VerifyLogSRD(logger.PublicKey, srd); err != nil {
+		t.Fatalf("logger SRD does not verify: %v", err)
+	}
+	if err := ca.VerifySRDSignature(&srd.SRD, logger.PublicKey); err != nil {
+		t.Fatalf("logger SRD does not verify as an SRD: %v", err)
+	}
+	tampered := *srd
+	tampered.SRD.RevDigest.Timestamp++
+	if err := ca.VerifySRDSignature(&tampered.SRD, logger.PublicKey); err == nil {
+		t.Fatalf("tampered logger SRD should not verify")
+	}
+}
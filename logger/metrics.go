@@ -0,0 +1,286 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"bytes"
+	"net/http"
+	"github.com/golang/glog"
+	el "github.com/n-ct/ct-monitor/entitylist"
+)
+
+const (
+	MetricsPath	= "/metrics"
+)
+
+//upper bounds of the request duration buckets in seconds
+var requestDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+//outcomes of SRDs and CA forwards as reported in the result label
+const (
+	resultAccepted	= "accepted"
+	resultRejected	= "rejected"
+	resultOK		= "ok"
+	resultError		= "error"
+	//ca_id label of SRDs from CAs not in the ca list, whose IDs are chosen by the poster
+	unknownCALabel	= "unknown"
+)
+
+type histogram struct {
+	counts	[]uint64 //per bucket of requestDurationBuckets, not cumulative
+	count	uint64
+	sum		float64
+}
+
+func (h *histogram) observe(seconds float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(requestDurationBuckets))
+	}
+	for i, bound := range requestDurationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+//counters of a logger served on MetricsPath, they have their own lock so counting never waits for signing
+type loggerMetrics struct {
+	sync.Mutex
+	requests	map[[2]string] uint64 //map[endpoint, status code]
+	durations	map[string] *histogram //map[endpoint]
+	srds		map[[3]string] uint64 //map[CA ID, result, reason]
+	forwards	map[[2]string] uint64 //map[CA ID, result]
+	caList		*el.CAList //CAs counted under their own ID, see setCAList
+}
+
+func newLoggerMetrics(caList *el.CAList) *loggerMetrics {
+	return &loggerMetrics{
+		caList:		caList,
+		requests:	make(map[[2]string] uint64),
+		durations:	make(map[string] *histogram),
+		srds:		make(map[[3]string] uint64),
+		forwards:	make(map[[2]string] uint64),
+	}
+}
+
+func (m *loggerMetrics) observeRequest(endpoint string, status int, duration time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.requests[[2]string{endpoint, fmt.Sprint(status)}]++
+	if m.durations[endpoint] == nil {
+		m.durations[endpoint] = &histogram{}
+	}
+	m.durations[endpoint].observe(duration.Seconds())
+}
+
+//replaces the ca list when the logger reloads it, counts of CAs that were removed are kept
+func (m *loggerMetrics) setCAList(caList *el.CAList) {
+	m.Lock()
+	defer m.Unlock()
+	m.caList = caList
+}
+
+//counts an SRD of the CA as accepted when err is nil, otherwise as rejected with the code of err as reason.
+//CAs not in the ca list are counted as unknownCALabel, so posters can not add labels without bound
+func (m *loggerMetrics) countSRD(caID string, err error) {
+	result, reason := resultAccepted, ""
+	if err != nil {
+		result, reason = resultRejected, AsError(err).Code
+	}
+	m.Lock()
+	defer m.Unlock()
+	if m.caList == nil || m.caList.FindCAByCAID(caID) == nil {
+		caID = unknownCALabel
+	}
+	m.srds[[3]string{caID, result, reason}]++
+}
+
+func (m *loggerMetrics) countForward(caID string, err error) {
+	result := resultOK
+	if err != nil {
+		result = resultError
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.forwards[[2]string{caID, result}]++
+}
+
+//response writer that remembers the status code for the request metrics
+type statusRecorder struct {
+	http.ResponseWriter
+	status	int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+//wraps handler so its requests are counted and timed under endpoint, and traced as a span continuing the trace of the caller
+func (this *Logger) instrument(endpoint string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		ctx, span := this.startSpan(extractTraceContext(req), endpoint, SpanKindServer)
+		span.SetAttribute("http.method", req.Method)
+		recorder := &statusRecorder{ResponseWriter: res, status: http.StatusOK}
+		handler(recorder, req.WithContext(ctx))
+		this.metrics.observeRequest(endpoint, recorder.status, time.Since(start))
+		span.SetAttribute("http.status_code", fmt.Sprint(recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetError(fmt.Errorf("%v", http.StatusText(recorder.status)))
+		}
+		span.Finish()
+	}
+}
+
+//one sample of a metric family
+type sample struct {
+	labels	[][2]string
+	value	float64
+}
+
+//writes the samples of a metric in the Prometheus text format, ordered by their labels so the output is stable.
+//Samples with the same labels besides le keep their order, so histogram buckets stay ascending
+func writeSamples(w io.Writer, name string, samples []sample) {
+	sort.SliceStable(samples, func(i, j int) bool {
+		return formatLabels(withoutLe(samples[i].labels)) < formatLabels(withoutLe(samples[j].labels))
+	})
+	for _, s := range samples {
+		fmt.Fprintf(w, "%v%v %v\n", name, formatLabels(s.labels), formatValue(s.value))
+	}
+}
+
+func withoutLe(labels [][2]string) [][2]string {
+	if len(labels) > 0 && labels[len(labels) - 1][0] == "le" {
+		return labels[:len(labels) - 1]
+	}
+	return labels
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels [][2]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(labels))
+	for i, label := range labels {
+		pairs[i] = fmt.Sprintf(`%v="%v"`, label[0], labelEscaper.Replace(label[1]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	return fmt.Sprintf("%g", value)
+}
+
+//appends the samples of the logger to the metric families in families, map[metric name]
+func (this *Logger) collectMetrics(families map[string] []sample, now time.Time) {
+	logID := [2]string{"log_id", this.LogID}
+	m := this.metrics
+	m.Lock()
+	for key, count := range m.requests {
+		families["ct_logger_http_requests_total"] = append(families["ct_logger_http_requests_total"],
+			sample{[][2]string{logID, {"endpoint", key[0]}, {"code", key[1]}}, float64(count)})
+	}
+	for endpoint, h := range m.durations {
+		labels := [][2]string{logID, {"endpoint", endpoint}}
+		var cumulative uint64
+		for i, bound := range requestDurationBuckets {
+			cumulative += h.counts[i]
+			families["ct_logger_http_request_duration_seconds_bucket"] = append(families["ct_logger_http_request_duration_seconds_bucket"],
+				sample{[][2]string{logID, {"endpoint", endpoint}, {"le", formatValue(bound)}}, float64(cumulative)})
+		}
+		families["ct_logger_http_request_duration_seconds_bucket"] = append(families["ct_logger_http_request_duration_seconds_bucket"],
+			sample{[][2]string{logID, {"endpoint", endpoint}, {"le", "+Inf"}}, float64(h.count)})
+		families["ct_logger_http_request_duration_seconds_sum"] = append(families["ct_logger_http_request_duration_seconds_sum"], sample{labels, h.sum})
+		families["ct_logger_http_request_duration_seconds_count"] = append(families["ct_logger_http_request_duration_seconds_count"], sample{labels, float64(h.count)})
+	}
+	for key, count := range m.srds {
+		families["ct_logger_srds_total"] = append(families["ct_logger_srds_total"],
+			sample{[][2]string{logID, {"ca_id", key[0]}, {"result", key[1]}, {"reason", key[2]}}, float64(count)})
+	}
+	for key, count := range m.forwards {
+		families["ct_logger_ca_forwards_total"] = append(families["ct_logger_ca_forwards_total"],
+			sample{[][2]string{logID, {"ca_id", key[0]}, {"result", key[1]}}, float64(count)})
+	}
+	m.Unlock()
+	if this.audit != nil {
+		if head := this.audit.Head(); head != nil {
+			families["ct_logger_audit_records"] = append(families["ct_logger_audit_records"], sample{[][2]string{logID}, float64(head.Seq)})
+		}
+	}
+
+	this.RLock()
+	defer this.RUnlock()
+	for caID, crvs := range this.CurrentCRVMap {
+		for revocationType, crv := range crvs {
+			labels := [][2]string{logID, {"ca_id", caID}, {"revocation_type", revocationType}}
+			families["ct_logger_crv_size"] = append(families["ct_logger_crv_size"], sample{labels, float64(crv.Capacity())})
+			families["ct_logger_crv_revoked"] = append(families["ct_logger_crv_revoked"], sample{labels, float64(len(crv.ToNums()))})
+		}
+	}
+	for caID, srds := range this.LogSRDWithRevDataMap {
+		for revocationType, srd := range srds {
+			labels := [][2]string{logID, {"ca_id", caID}, {"revocation_type", revocationType}}
+			since := now.Sub(time.Unix(int64(srd.SRD.RevDigest.Timestamp), 0)).Seconds()
+			families["ct_logger_ca_seconds_since_update"] = append(families["ct_logger_ca_seconds_since_update"], sample{labels, since})
+		}
+	}
+}
+
+//help and type of the metric families, in the order they are written
+var metricFamilies = []struct {
+	name, help, metricType string
+}{
+	{"ct_logger_http_requests_total", "Requests served per endpoint and status code.", "counter"},
+	{"ct_logger_http_request_duration_seconds", "Time taken to serve requests per endpoint.", "histogram"},
+	{"ct_logger_srds_total", "CA SRDs accepted or rejected, with the error code as reason.", "counter"},
+	{"ct_logger_ca_forwards_total", "Revoke requests forwarded to CAs, by result.", "counter"},
+	{"ct_logger_crv_size", "Capacity in bits of the current CRV of a CA.", "gauge"},
+	{"ct_logger_crv_revoked", "Revoked certificates in the current CRV of a CA.", "gauge"},
+	{"ct_logger_ca_seconds_since_update", "Seconds since the timestamp of the latest SRD of a CA.", "gauge"},
+	{"ct_logger_audit_records", "Records in the audit log, the sequence number of its head.", "gauge"},
+}
+
+// Writes the metrics of the loggers in the Prometheus text format
+func WriteMetrics(w io.Writer, loggers []*Logger) {
+	families := make(map[string] []sample)
+	now := time.Now()
+	for _, l := range loggers {
+		l.collectMetrics(families, now)
+	}
+	for _, family := range metricFamilies {
+		//the series of a histogram share the HELP and TYPE of the family
+		names := []string{family.name}
+		if family.metricType == "histogram" {
+			names = []string{family.name + "_bucket", family.name + "_sum", family.name + "_count"}
+		}
+		if len(families[names[0]]) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", family.name, family.help, family.name, family.metricType)
+		for _, name := range names {
+			writeSamples(w, name, families[name])
+		}
+	}
+}
+
+//handler serving the metrics of the loggers on MetricsPath
+func metricsHandler(loggers []*Logger) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		var buf bytes.Buffer
+		WriteMetrics(&buf, loggers)
+		res.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if _, err := res.Write(buf.Bytes()); err != nil {
+			glog.Warningf("failed to write metrics: %v", err)
+		}
+	}
+}
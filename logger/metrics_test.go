@@ -0,0 +1,102 @@
+package logger
+
+import (
+	"testing"
+	"fmt"
+	"strings"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	mtr "github.com/n-ct/ct-monitor"
+)
+
+//function that fetches the metrics served by server
+func mustGetMetrics(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+	res, err := http.Get(server.URL + MetricsPath)
+	if err != nil {
+		t.Fatalf("failed to get metrics: %v", err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get metrics, %v: %v", res.Status, err)
+	}
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("metrics should be in the Prometheus text format, got Content-Type %q", res.Header.Get("Content-Type"))
+	}
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	logger, err := mustCreateLogger(t)
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+	serveMux, err := NewServeMux([]*Logger{logger}, nil)
+	if err != nil {
+		t.Fatalf("failed to create serve mux: %v", err)
+	}
+	server := httptest.NewServer(serveMux)
+	defer server.Close()
+	caServer := httptest.NewServer(http.NotFoundHandler())
+	defer caServer.Close()
+	logger.CAList.FindCAByCAID(ca_id).CAURL = caServer.URL
+
+	if code := mustGetStatus(t, "POST", server.URL + PostLogSRDWithRevDataPath, mustCreateCASRDBody(t)); code != http.StatusOK {
+		t.Fatalf("post failed with %v", code)
+	}
+	badSRD := mustCreateModifiedCASRDBody(t, func(data *mtr.SRDWithRevData) {
+		data.SRD.RevDigest.Timestamp++ //invalidates the CA signature
+	})
+	if code := mustGetStatus(t, "POST", server.URL + PostLogSRDWithRevDataPath, badSRD); code != http.StatusUnprocessableEntity {
+		t.Fatalf("post of SRD with invalid signature should fail with %v, got %v", http.StatusUnprocessableEntity, code)
+	}
+	unknownSRD := mustCreateModifiedCASRDBody(t, func(data *mtr.SRDWithRevData) {
+		data.SRD.EntityID = "made up"
+		data.RevData.EntityID = "made up"
+	})
+	if code := mustGetStatus(t, "POST", server.URL + PostLogSRDWithRevDataPath, unknownSRD); code != http.StatusNotFound {
+		t.Fatalf("post of SRD of an unknown CA should fail with %v, got %v", http.StatusNotFound, code)
+	}
+	if code := mustGetStatus(t, "POST", server.URL + RevokeAndProduceSRDPath, []byte("{}")); code != http.StatusBadGateway {
+		t.Fatalf("revoke request to a failing CA should fail with %v, got %v", http.StatusBadGateway, code)
+	}
+
+	metrics := mustGetMetrics(t, server)
+	logID := fmt.Sprintf(`log_id="%v"`, logger.LogID)
+	caLabels := fmt.Sprintf(`%v,ca_id="%v"`, logID, ca_id)
+	for _, want := range []string{
+		fmt.Sprintf(`ct_logger_http_requests_total{%v,endpoint="%v",code="200"} 1`, logID, PostLogSRDWithRevDataPath),
+		fmt.Sprintf(`ct_logger_http_requests_total{%v,endpoint="%v",code="422"} 1`, logID, PostLogSRDWithRevDataPath),
+		fmt.Sprintf(`ct_logger_http_request_duration_seconds_count{%v,endpoint="%v"} 3`, logID, PostLogSRDWithRevDataPath),
+		fmt.Sprintf(`ct_logger_http_request_duration_seconds_bucket{%v,endpoint="%v",le="+Inf"} 3`, logID, PostLogSRDWithRevDataPath),
+		fmt.Sprintf(`ct_logger_srds_total{%v,result="accepted",reason=""} 1`, caLabels),
+		fmt.Sprintf(`ct_logger_srds_total{%v,result="rejected",reason="invalid_signature"} 1`, caLabels),
+		fmt.Sprintf(`ct_logger_srds_total{%v,ca_id="unknown",result="rejected",reason="unknown_ca"} 1`, logID),
+		fmt.Sprintf(`ct_logger_ca_forwards_total{%v,result="error"} 1`, caLabels),
+		fmt.Sprintf(`ct_logger_crv_revoked{%v,revocation_type="Let's-Revoke"} 2`, caLabels),
+		fmt.Sprintf(`ct_logger_ca_seconds_since_update{%v,revocation_type="Let's-Revoke"}`, caLabels),
+		"# TYPE ct_logger_http_request_duration_seconds histogram",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Fatalf("metrics should contain %q:\n%v", want, metrics)
+		}
+	}
+	if strings.Contains(metrics, "made up") {
+		t.Fatalf("CA IDs not in the ca list should not become labels:\n%v", metrics)
+	}
+	//buckets are cumulative and ascending, ending in +Inf
+	bucket10 := strings.Index(metrics, fmt.Sprintf(`endpoint="%v",le="10"}`, PostLogSRDWithRevDataPath))
+	bucketInf := strings.Index(metrics, fmt.Sprintf(`endpoint="%v",le="+Inf"}`, PostLogSRDWithRevDataPath))
+	if bucket10 < 0 || bucketInf < bucket10 {
+		t.Fatalf("histogram buckets are out of order:\n%v", metrics)
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	got := formatLabels([][2]string{{"ca_id", "a\"b\\c\nd"}})
+	if want := `{ca_id="a\"b\\c\nd"}`; got != want {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,81 @@
+package logger
+
+import (
+	"fmt"
+	"net/http"
+)
+
+//NewServeMux returns a mux serving the endpoints of every logger under its PathPrefix, so one process can host several logs.
+//Each logger keeps its own LogID, Signer, CA set and state. A single logger is also served at the root, as before.
+//wrapPost, when not nil, wraps the endpoints CAs post to, e.g. with tlsutil.RequireClientCert.
+//The state endpoints authenticate operators themselves, see authenticateOperator
+func NewServeMux(loggers []*Logger, wrapPost func(http.HandlerFunc) http.HandlerFunc) (*http.ServeMux, error) {
+	if len(loggers) == 0 {
+		return nil, fmt.Errorf("no loggers to serve")
+	}
+	prefixes := make(map[string]*Logger)
+	logIDs := make(map[string]bool)
+	for _, l := range loggers {
+		if other, ok := prefixes[l.PathPrefix]; ok {
+			return nil, fmt.Errorf("loggers %v and %v are both served under path prefix %q", other.LogID, l.LogID, l.PathPrefix)
+		}
+		if logIDs[l.LogID] {
+			return nil, fmt.Errorf("logger %v is configured more than once", l.LogID)
+		}
+		if l.Network != loggers[0].Network || l.Address != loggers[0].Address {
+			return nil, fmt.Errorf("logger %v listens on %v %v but logger %v on %v %v, loggers served together must share a listen address",
+				l.LogID, l.Network, l.Address, loggers[0].LogID, loggers[0].Network, loggers[0].Address)
+		}
+		prefixes[l.PathPrefix] = l
+		logIDs[l.LogID] = true
+	}
+
+	serveMux := http.NewServeMux()
+	for _, l := range loggers {
+		l.registerHandlers(serveMux, l.PathPrefix, wrapPost)
+	}
+	if len(loggers) == 1 && loggers[0].PathPrefix != "" {
+		loggers[0].registerHandlers(serveMux, "", wrapPost)
+	}
+
+	//metrics of all loggers are served once, labeled with their log IDs
+	serveMux.HandleFunc(MetricsPath, validateRequest(http.MethodGet, 0, metricsHandler(loggers)))
+	//liveness and readiness of the server, for orchestrators
+	serveMux.HandleFunc(HealthzPath, validateRequest(http.MethodGet, 0, healthzHandler))
+	serveMux.HandleFunc(ReadyzPath, validateRequest(http.MethodGet, 0, readyzHandler(loggers)))
+
+	// Return a 200 on the root and on the prefix of every logger so clients can easily check if server is up
+	serveMux.HandleFunc("/", func(resp http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/" {
+			resp.WriteHeader(http.StatusOK)
+			return
+		}
+		for prefix := range prefixes {
+			if req.URL.Path == prefix || req.URL.Path == prefix + "/" {
+				resp.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+		resp.WriteHeader(http.StatusNotFound)
+	})
+	return serveMux, nil
+}
+
+//registers the endpoints of the logger on serveMux under prefix, each only for its method and body limit
+func (this *Logger) registerHandlers(serveMux *http.ServeMux, prefix string, wrapPost func(http.HandlerFunc) http.HandlerFunc) {
+	postHandler := http.HandlerFunc(this.OnPostLogSRDWithRevData)
+	if wrapPost != nil {
+		postHandler = wrapPost(postHandler)
+	}
+	handle := func(path, method string, maxBodySize int64, handler http.HandlerFunc) {
+		serveMux.HandleFunc(prefix + path, this.instrument(path, validateRequest(method, maxBodySize, handler)))
+	}
+	handle(PostLogSRDWithRevDataPath, http.MethodPost, MaxSRDBodySize, postHandler)
+	handle(GetLogSRDWithRevDataPath, http.MethodGet, 0, this.OnGetLogSRDWithRevData)
+	handle(RevokeAndProduceSRDPath, http.MethodPost, MaxSRDBodySize, this.OnRevokeAndProduceSRD)
+	handle(GetStatePath, http.MethodGet, 0, this.OnGetState)
+	handle(PostStatePath, http.MethodPost, MaxStateBodySize, this.OnPostState)
+	handle(GetSRDUpdatesPath, http.MethodGet, 0, this.OnGetSRDUpdates)
+	handle(GetKeysPath, http.MethodGet, 0, this.OnGetKeys)
+	handle(GetStatusPath, http.MethodGet, 0, this.OnGetStatus)
+}
@@ -0,0 +1,136 @@
+package logger
+
+import (
+	"testing"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+)
+
+const second_config_filename string = "../testdata/config_argon2021.json"
+
+//function that creates the argon2020 and argon2021 loggers and serves both from one server
+func mustServeTwoLoggers(t *testing.T) (*Logger, *Logger, *httptest.Server) {
+	t.Helper()
+	first, err := mustCreateLogger(t)
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+	second, err := NewLogger(second_config_filename, caList_filename, logList_filename)
+	if err != nil {
+		t.Fatalf("failed to create logger with config @ (%s): %v", second_config_filename, err)
+	}
+	serveMux, err := NewServeMux([]*Logger{first, second}, nil)
+	if err != nil {
+		t.Fatalf("failed to create serve mux: %v", err)
+	}
+	server := httptest.NewServer(serveMux)
+	t.Cleanup(server.Close)
+	return first, second, server
+}
+
+func mustGetStatus(t *testing.T, method, url string, body []byte) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to send request to %v: %v", url, err)
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
+
+func TestPathPrefixRouting(t *testing.T) {
+	first, second, server := mustServeTwoLoggers(t)
+	if first.PathPrefix != "/logs/argon2020" || second.PathPrefix != "/logs/argon2021" {
+		t.Fatalf("unexpected path prefixes %q and %q", first.PathPrefix, second.PathPrefix)
+	}
+
+	code := mustGetStatus(t, "POST", server.URL + first.PathPrefix + PostLogSRDWithRevDataPath, mustCreateCASRDBody(t))
+	if code != http.StatusOK {
+		t.Fatalf("post to %v failed with %v", first.PathPrefix, code)
+	}
+	if len(first.SRDHistory) != 1 || len(second.SRDHistory) != 0 {
+		t.Fatalf("SRD posted under %v should only reach %v, histories hold %v and %v SRDs",
+			first.PathPrefix, first.LogID, len(first.SRDHistory), len(second.SRDHistory))
+	}
+	if code := mustGetStatus(t, "GET", server.URL + first.PathPrefix + GetLogSRDWithRevDataPath, nil); code != http.StatusOK {
+		t.Fatalf("get from %v failed with %v", first.PathPrefix, code)
+	}
+	if code := mustGetStatus(t, "GET", server.URL + second.PathPrefix + GetLogSRDWithRevDataPath, nil); code == http.StatusOK {
+		t.Fatalf("logger under %v should not hold the SRD posted to %v", second.PathPrefix, first.PathPrefix)
+	}
+
+	//with several loggers nothing is served at the root
+	if code := mustGetStatus(t, "GET", server.URL + GetLogSRDWithRevDataPath, nil); code != http.StatusNotFound {
+		t.Fatalf("endpoints at the root should not be served for several loggers, got %v", code)
+	}
+	if code := mustGetStatus(t, "GET", server.URL + second.PathPrefix + "/", nil); code != http.StatusOK {
+		t.Fatalf("prefix of a logger should report the server is up, got %v", code)
+	}
+}
+
+func TestSingleLoggerServedAtRoot(t *testing.T) {
+	logger, err := mustCreateLogger(t)
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+	serveMux, err := NewServeMux([]*Logger{logger}, nil)
+	if err != nil {
+		t.Fatalf("failed to create serve mux: %v", err)
+	}
+	server := httptest.NewServer(serveMux)
+	defer server.Close()
+	if code := mustGetStatus(t, "POST", server.URL + PostLogSRDWithRevDataPath, mustCreateCASRDBody(t)); code != http.StatusOK {
+		t.Fatalf("post to the root failed with %v", code)
+	}
+	if code := mustGetStatus(t, "GET", server.URL + logger.PathPrefix + GetLogSRDWithRevDataPath, nil); code != http.StatusOK {
+		t.Fatalf("get from %v failed with %v", logger.PathPrefix, code)
+	}
+}
+
+func TestNewServeMuxRejectsConflicts(t *testing.T) {
+	first, _ := mustCreateLogger(t)
+	second, _ := NewLogger(second_config_filename, caList_filename, logList_filename)
+	if _, err := NewServeMux(nil, nil); err == nil {
+		t.Fatalf("serve mux should not be created without loggers")
+	}
+
+	second.PathPrefix = first.PathPrefix
+	if _, err := NewServeMux([]*Logger{first, second}, nil); err == nil {
+		t.Fatalf("serve mux should not be created for loggers sharing a path prefix")
+	}
+
+	second.PathPrefix = "/logs/argon2021"
+	second.Address = "localhost:6966"
+	if _, err := NewServeMux([]*Logger{first, second}, nil); err == nil {
+		t.Fatalf("serve mux should not be created for loggers with different listen addresses")
+	}
+}
+
+func TestWrapPostGuardsPosts(t *testing.T) {
+	logger, _ := mustCreateLogger(t)
+	deny := func(handler http.HandlerFunc) http.HandlerFunc {
+		return func(res http.ResponseWriter, req *http.Request) {
+			WriteError(res, NewError(ErrorCodeUnauthorized, nil, "denied"))
+		}
+	}
+	serveMux, err := NewServeMux([]*Logger{logger}, deny)
+	if err != nil {
+		t.Fatalf("failed to create serve mux: %v", err)
+	}
+	server := httptest.NewServer(serveMux)
+	defer server.Close()
+	if code := mustGetStatus(t, "POST", server.URL + PostLogSRDWithRevDataPath, mustCreateCASRDBody(t)); code != http.StatusUnauthorized {
+		t.Fatalf("post endpoint should be wrapped, got %v", code)
+	}
+	if code := mustGetStatus(t, "GET", server.URL + GetLogSRDWithRevDataPath, nil); code == http.StatusUnauthorized {
+		t.Fatalf("public endpoints should not be wrapped")
+	}
+}
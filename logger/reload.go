@@ -0,0 +1,137 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"context"
+	"time"
+	"github.com/golang/glog"
+)
+
+// How often WatchFiles checks the config, ca list and log list files for changes
+const DefaultReloadInterval = 30 * time.Second
+
+// Reads the config, ca list and log list files the logger was created from again and swaps in the CAs,
+// CA authentication and log list metadata, including its state and temporal interval, in one step.
+// The new files must load and pass the self-checks, otherwise the logger keeps the old ones. CRVs and SRDs of CAs
+// that remain are kept, those of CAs that were removed from ca_ids or the ca list are dropped.
+// Signing keys, the log ID and the listen address only change on a restart.
+// Reloads triggered at the same time, e.g. by SIGHUP and WatchFiles, run one after the other
+func (this *Logger) Reload() error {
+	this.reloadMu.Lock()
+	defer this.reloadMu.Unlock()
+	loaded, logList, err := loadLogger(this.configName, this.caListName, this.logListName)
+	if err != nil {
+		return fmt.Errorf("failed to reload logger (%v), keeping the old files: %w", this.LogID, err)
+	}
+	if loaded.LogID != this.LogID {
+		return fmt.Errorf("reloaded config is for logger (%v) instead of logger (%v), a restart is needed", loaded.LogID, this.LogID)
+	}
+	if loaded.Network != this.Network || loaded.Address != this.Address || loaded.PathPrefix != this.PathPrefix {
+		return fmt.Errorf("listen address of logger (%v) changed, a restart is needed", this.LogID)
+	}
+
+	//validate the new files against the keys the logger signs with, which the reload keeps
+	configured := loaded.signers
+	loaded.signers = make(map[string] Signer)
+	this.RLock()
+	loaded.Keys = append([]LoggerKey{}, this.Keys...)
+	for keyID, signer := range this.signers {
+		loaded.signers[keyID] = signer
+	}
+	this.RUnlock()
+	for keyID := range configured {
+		if _, ok := loaded.signers[keyID]; !ok {
+			glog.Warningf("signing keys changed in %v, they take effect after a restart", this.configName)
+			break
+		}
+	}
+	diagnostics := loaded.SelfCheck(logList)
+	if err := checkDiagnostics(diagnostics); err != nil {
+		return fmt.Errorf("reloaded files of logger (%v) failed, keeping the old ones: %w", this.LogID, err)
+	}
+
+	if loaded.auditLogName != this.auditLogName {
+		glog.Warningf("audit_log changed in %v, it takes effect after a restart", this.configName)
+	}
+	this.Lock()
+	defer this.Unlock()
+	if loaded.LogState != this.LogState {
+		glog.Infof("logger (%v) is now %v in the log list", this.LogID, loaded.LogState)
+	}
+	this.CAList = loaded.CAList
+	this.metrics.setCAList(loaded.CAList)
+	this.CAIDs = loaded.CAIDs
+	this.CAAuthMode = loaded.CAAuthMode
+	this.CAClientCertIDs = loaded.CAClientCertIDs
+	this.OperatorKeys = loaded.OperatorKeys
+	this.OperatorCertIDs = loaded.OperatorCertIDs
+	this.PublicKey = loaded.PublicKey
+	this.SignatureAlgorithm = loaded.SignatureAlgorithm
+	this.LogState = loaded.LogState
+	this.LogStateSince = loaded.LogStateSince
+	this.TemporalInterval = loaded.TemporalInterval
+	this.MaxTimestampSkew = loaded.MaxTimestampSkew
+	this.MaxSRDHistory = loaded.MaxSRDHistory
+	this.Diagnostics = diagnostics
+	for caID := range this.CurrentCRVMap {
+		if !this.allowsCA(caID) || this.CAList.FindCAByCAID(caID) == nil {
+			glog.Infof("logger (%v) drops the CRVs of removed CA (%v)", this.LogID, caID)
+			delete(this.CurrentCRVMap, caID)
+			this.deleteLogSRDs(caID)
+		}
+	}
+	if len(this.LogSRDWithRevDataMap) == 0 {
+		this.LogSRDWithRevDataMap = nil
+	}
+	glog.Infof("logger (%v) reloaded %v, %v and %v", this.LogID, this.configName, this.caListName, this.logListName)
+	return nil
+}
+
+func (this *Logger) readModTimes() (map[string] time.Time, error) {
+	modTimes := make(map[string] time.Time)
+	for _, fileName := range []string{this.configName, this.caListName, this.logListName} {
+		info, err := os.Stat(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %v: %v", fileName, err)
+		}
+		modTimes[fileName] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// Checks the files of the logger for changes every interval and reloads them when any changed, until ctx is done.
+// A failed reload is logged and retried when the files change again
+func (this *Logger) WatchFiles(ctx context.Context, interval time.Duration) {
+	modTimes, err := this.readModTimes()
+	if err != nil {
+		glog.Warningf("failed to check files of logger (%v) for changes: %v", this.LogID, err)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		newModTimes, err := this.readModTimes()
+		if err != nil {
+			glog.Warningf("failed to check files of logger (%v) for changes: %v", this.LogID, err)
+			continue
+		}
+		changed := false
+		for fileName, modTime := range newModTimes {
+			if !modTime.Equal(modTimes[fileName]) {
+				changed = true
+			}
+		}
+		modTimes = newModTimes
+		if !changed {
+			continue
+		}
+		if err := this.Reload(); err != nil {
+			glog.Errorf("%v", err)
+		}
+	}
+}
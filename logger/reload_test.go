@@ -0,0 +1,191 @@
+package logger
+
+import (
+	"testing"
+	"context"
+	"time"
+	"os"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	el "github.com/n-ct/ct-monitor/entitylist"
+)
+
+//CA that is added to the ca list by the reload tests, its key is not used to sign anything
+const (
+	second_ca_id	string = "7RDc5hlPf1l00h1vARrHAFH1Zk2rcoJy9SBahGjr0S0="
+	second_ca_key	string = "MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEVDl0gZQP4R0dwTZZ7Z+j/ECyquRV8Dn0NdH/GpvSf39/4JumUKFfkwuigsS+Y8hmEHNSlD1KoKl+7jbMPY8yEA=="
+)
+
+//function that copies the test config, ca list and log list to a temporary directory and creates a logger from the copies.
+//Returns the logger and the directory
+func mustCreateLoggerFromCopies(t *testing.T) (*Logger, string) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, fileName := range []string{config_filename, caList_filename, logList_filename} {
+		data, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			t.Fatalf("failed to read %v: %v", fileName, err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.Base(fileName)), data, 0600); err != nil {
+			t.Fatalf("failed to copy %v: %v", fileName, err)
+		}
+	}
+	logger, err := NewLogger(filepath.Join(dir, "config.json"), filepath.Join(dir, "ca_list.json"), filepath.Join(dir, "log_list.json"))
+	if err != nil {
+		t.Fatalf("failed to create Logger: %v", err)
+	}
+	return logger, dir
+}
+
+//function that rewrites a copied file with v marshaled as JSON and moves its modification time forward,
+//so the change is seen even within the resolution of the file system clock
+func mustRewriteJSON(t *testing.T, fileName string, v interface{}) {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("failed to marshal %v: %v", fileName, err)
+	}
+	if err := ioutil.WriteFile(fileName, data, 0600); err != nil {
+		t.Fatalf("failed to write %v: %v", fileName, err)
+	}
+	modTime := time.Now().Add(time.Minute)
+	if err := os.Chtimes(fileName, modTime, modTime); err != nil {
+		t.Fatalf("failed to touch %v: %v", fileName, err)
+	}
+}
+
+//function that adds the second CA to the copied ca list and sets the ca_ids of the copied config
+func mustRewriteCAs(t *testing.T, dir string, caIDs []string) {
+	t.Helper()
+	caList, err := el.NewCAList(filepath.Join(dir, "ca_list.json"))
+	if err != nil {
+		t.Fatalf("failed to read ca list: %v", err)
+	}
+	if caList.FindCAByCAID(second_ca_id) == nil {
+		caList.CAOperators[0].CAs = append(caList.CAOperators[0].CAs, &el.CAInfo{CAID: second_ca_id, CAKey: second_ca_key, CAURL: "http://localhost:6001", MMD: 10})
+	}
+	mustRewriteJSON(t, filepath.Join(dir, "ca_list.json"), caList)
+
+	config, err := parseLoggerConfig(filepath.Join(dir, "config.json"))
+	if err != nil {
+		t.Fatalf("failed to parse logger config: %v", err)
+	}
+	config.CAIDs = caIDs
+	mustRewriteJSON(t, filepath.Join(dir, "config.json"), config)
+}
+
+func TestReloadKeepsCRVsOfRemainingCAs(t *testing.T) {
+	logger, dir := mustCreateLoggerFromCopies(t)
+	mustUpdateAt(t, logger, []uint64{1,3}, []uint64{1,3}, time.Now())
+
+	mustRewriteCAs(t, dir, []string{ca_id, second_ca_id})
+	if err := logger.Reload(); err != nil {
+		t.Fatalf("failed to reload logger: %v", err)
+	}
+	if !logger.isAllowedCA(second_ca_id) || logger.CAList.FindCAByCAID(second_ca_id) == nil {
+		t.Fatalf("reload should add the new CA, got ca_ids %v", logger.CAIDs)
+	}
+	if _, ok := logger.CurrentCRVMap[ca_id]; !ok {
+		t.Fatalf("reload should keep the CRVs of a CA that remains")
+	}
+	mustUpdateAt(t, logger, []uint64{1,3,5}, []uint64{5}, time.Now())
+
+	mustRewriteCAs(t, dir, []string{second_ca_id})
+	if err := logger.Reload(); err != nil {
+		t.Fatalf("failed to reload logger: %v", err)
+	}
+	if logger.isAllowedCA(ca_id) {
+		t.Fatalf("reload should remove the CA")
+	}
+	if _, ok := logger.CurrentCRVMap[ca_id]; ok {
+		t.Fatalf("reload should drop the CRVs of a removed CA")
+	}
+	if _, ok := logger.LogSRDWithRevDataMap[ca_id]; ok {
+		t.Fatalf("reload should drop the SRDs of a removed CA")
+	}
+}
+
+func TestReloadKeepsOldFilesOnError(t *testing.T) {
+	logger, dir := mustCreateLoggerFromCopies(t)
+	caIDs := logger.CAIDs
+
+	//a CA missing from the ca list fails the self-check
+	config, err := parseLoggerConfig(filepath.Join(dir, "config.json"))
+	if err != nil {
+		t.Fatalf("failed to parse logger config: %v", err)
+	}
+	config.CAIDs = []string{ca_id, second_ca_id}
+	mustRewriteJSON(t, filepath.Join(dir, "config.json"), config)
+	if err := logger.Reload(); err == nil {
+		t.Fatalf("reload should fail for a CA missing from the ca list")
+	}
+	if len(logger.CAIDs) != len(caIDs) {
+		t.Fatalf("failed reload should keep the old ca_ids, got %v", logger.CAIDs)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "ca_list.json"), []byte("{"), 0600); err != nil {
+		t.Fatalf("failed to write ca list: %v", err)
+	}
+	if err := logger.Reload(); err == nil {
+		t.Fatalf("reload should fail for a ca list that does not parse")
+	}
+
+	caListBytes, err := ioutil.ReadFile(caList_filename)
+	if err != nil {
+		t.Fatalf("failed to read ca list: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "ca_list.json"), caListBytes, 0600); err != nil {
+		t.Fatalf("failed to write ca list: %v", err)
+	}
+	config.CAIDs = caIDs
+	config.ListenAddress = "unix:/run/ct-logger.sock"
+	mustRewriteJSON(t, filepath.Join(dir, "config.json"), config)
+	if err := logger.Reload(); err == nil {
+		t.Fatalf("reload should not change the listen address")
+	}
+}
+
+func TestConcurrentReload(t *testing.T) {
+	logger, dir := mustCreateLoggerFromCopies(t)
+	mustRewriteCAs(t, dir, []string{ca_id, second_ca_id})
+
+	//SIGHUP, WatchFiles and the remote list refresh can all reload at once
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- logger.Reload()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("failed to reload logger: %v", err)
+		}
+	}
+	if !logger.isAllowedCA(second_ca_id) {
+		t.Fatalf("reload should add the new CA, got ca_ids %v", logger.CAIDs)
+	}
+}
+
+func TestWatchFiles(t *testing.T) {
+	logger, dir := mustCreateLoggerFromCopies(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go logger.WatchFiles(ctx, 10*time.Millisecond)
+	time.Sleep(50*time.Millisecond) //let the watcher read the modification times of the copies
+
+	mustRewriteCAs(t, dir, []string{ca_id, second_ca_id})
+	deadline := time.Now().Add(5*time.Second)
+	for !logger.isAllowedCA(second_ca_id) {
+		if time.Now().After(deadline) {
+			t.Fatalf("changed files were not reloaded")
+		}
+		time.Sleep(10*time.Millisecond)
+	}
+}
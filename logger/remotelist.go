@@ -0,0 +1,274 @@
+package logger
+
+import (
+	"io"
+	"fmt"
+	"os"
+	"bytes"
+	"context"
+	"time"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/golang/glog"
+	el "github.com/n-ct/ct-monitor/entitylist"
+)
+
+// How often a RemoteList is fetched again by Refresh
+const DefaultListRefreshInterval = time.Hour
+
+// suffix of the file holding the detached signature, both next to the list on the server and next to the cache file
+const ListSignatureSuffix = ".sig"
+
+// Largest list and detached signature a RemoteList fetches, longer responses are rejected
+const (
+	MaxListSize				= 16 << 20
+	MaxListSignatureSize	= 4 << 10
+)
+
+// RemoteList is a CA or log list published at a URL with a detached signature, the way Chrome's log_list.json
+// is distributed with log_list.sig. A verified copy of the list is cached in CacheFile, which is the file
+// the logger is created from, so a refreshed list is picked up by Reload
+type RemoteList struct {
+	URL				string
+	SignatureURL	string // URL of the detached signature over the bytes of the list, URL + ".sig" when empty
+	PublicKey		crypto.PublicKey // key the signature is verified with, ECDSA, RSA PKCS#1 v1.5 or Ed25519
+	CacheFile		string // its signature is kept in CacheFile + ".sig" so the cache can be verified again
+	HTTPClient		*http.Client
+	validate		func([]byte) error // checks that the list parses
+}
+
+// Creates a RemoteList for a CA list
+func NewRemoteCAList(url string, publicKey crypto.PublicKey, cacheFile string) *RemoteList {
+	return newRemoteList(url, publicKey, cacheFile, func(data []byte) error {
+		var caList el.CAList
+		if err := json.Unmarshal(data, &caList); err != nil {
+			return fmt.Errorf("failed to parse ca list: %v", err)
+		}
+		if len(caList.CAOperators) == 0 {
+			return fmt.Errorf("ca list has no operators")
+		}
+		return nil
+	})
+}
+
+// Creates a RemoteList for a log list
+func NewRemoteLogList(url string, publicKey crypto.PublicKey, cacheFile string) *RemoteList {
+	return newRemoteList(url, publicKey, cacheFile, func(data []byte) error {
+		var logList el.LogList
+		if err := json.Unmarshal(data, &logList); err != nil {
+			return fmt.Errorf("failed to parse log list: %v", err)
+		}
+		if len(logList.Operators) == 0 {
+			return fmt.Errorf("log list has no operators")
+		}
+		return nil
+	})
+}
+
+func newRemoteList(url string, publicKey crypto.PublicKey, cacheFile string, validate func([]byte) error) *RemoteList {
+	return &RemoteList{
+		URL:			url,
+		SignatureURL:	url + ListSignatureSuffix,
+		PublicKey:		publicKey,
+		CacheFile:		cacheFile,
+		HTTPClient:		&http.Client{Timeout: 30 * time.Second},
+		validate:		validate,
+	}
+}
+
+// Reads the public key lists are signed with from a PEM PUBLIC KEY file or a file holding a base64 DER key
+func LoadListPublicKey(fileName string) (crypto.PublicKey, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading list public key: %v", err)
+	}
+	der := []byte{}
+	if block, _ := pem.Decode(data); block != nil {
+		der = block.Bytes
+	} else if der, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data))); err != nil {
+		return nil, fmt.Errorf("list public key %v is neither PEM nor base64 DER", fileName)
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing list public key: %v", err)
+	}
+	return publicKey, nil
+}
+
+// Verifies a detached signature over data. ECDSA signatures are ASN.1 over SHA-256, or SHA-384 for P-384 keys,
+// RSA signatures are PKCS#1 v1.5 over SHA-256 as used for Chrome's log list
+func verifyDetachedSignature(publicKey crypto.PublicKey, data, sig []byte) error {
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		var digest []byte
+		if key.Curve == elliptic.P384() {
+			hash := sha512.Sum384(data)
+			digest = hash[:]
+		} else {
+			hash := sha256.Sum256(data)
+			digest = hash[:]
+		}
+		if !ecdsa.VerifyASN1(key, digest, sig) {
+			return fmt.Errorf("invalid ECDSA signature")
+		}
+	case *rsa.PublicKey:
+		hash := sha256.Sum256(data)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig); err != nil {
+			return fmt.Errorf("invalid RSA signature: %v", err)
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, data, sig) {
+			return fmt.Errorf("invalid Ed25519 signature")
+		}
+	default:
+		return fmt.Errorf("unsupported list public key type %T", publicKey)
+	}
+	return nil
+}
+
+//fetches url, failing if the response is longer than maxSize bytes
+func (r *RemoteList) get(ctx context.Context, url string, maxSize int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for %v: %v", url, err)
+	}
+	res, err := r.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %v: %w", url, err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxSize + 1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %v: %v", url, err)
+	}
+	if int64(len(body)) > maxSize {
+		return nil, fmt.Errorf("%v is larger than %v bytes", url, maxSize)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %v returned %v", url, res.Status)
+	}
+	return body, nil
+}
+
+// checks the signature of a list and that it parses
+func (r *RemoteList) verify(data, sig []byte) error {
+	if err := verifyDetachedSignature(r.PublicKey, data, sig); err != nil {
+		return err
+	}
+	return r.validate(data)
+}
+
+// Fetches the list and its signature and replaces the cache with them if they verify.
+// Returns true if the list differs from the cached one. The cache is left alone on any error
+func (r *RemoteList) Fetch(ctx context.Context) (bool, error) {
+	data, err := r.get(ctx, r.URL, MaxListSize)
+	if err != nil {
+		return false, err
+	}
+	sig, err := r.get(ctx, r.SignatureURL, MaxListSignatureSize)
+	if err != nil {
+		return false, err
+	}
+	if err := r.verify(data, sig); err != nil {
+		return false, fmt.Errorf("list fetched from %v failed verification: %w", r.URL, err)
+	}
+	cached, err := ioutil.ReadFile(r.CacheFile)
+	if err == nil && bytes.Equal(cached, data) {
+		return false, nil
+	}
+	//the signature is written first, a list is never left next to a signature of another version for long
+	if err := writeFileAtomic(r.CacheFile + ListSignatureSuffix, sig); err != nil {
+		return false, err
+	}
+	if err := writeFileAtomic(r.CacheFile, data); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Verifies the cached list against its cached signature
+func (r *RemoteList) VerifyCache() error {
+	data, err := ioutil.ReadFile(r.CacheFile)
+	if err != nil {
+		return fmt.Errorf("failed to read cached list: %v", err)
+	}
+	sig, err := ioutil.ReadFile(r.CacheFile + ListSignatureSuffix)
+	if err != nil {
+		return fmt.Errorf("failed to read signature of cached list: %v", err)
+	}
+	if err := r.verify(data, sig); err != nil {
+		return fmt.Errorf("cached list %v failed verification: %w", r.CacheFile, err)
+	}
+	return nil
+}
+
+// Makes sure CacheFile holds a verified list before the logger reads it: the list is fetched,
+// and if that fails the cached copy is used as long as it still verifies
+func (r *RemoteList) Sync(ctx context.Context) error {
+	_, fetchErr := r.Fetch(ctx)
+	if fetchErr == nil {
+		return nil
+	}
+	if err := r.VerifyCache(); err != nil {
+		return fmt.Errorf("%v, and no usable cached list: %v", fetchErr, err)
+	}
+	glog.Warningf("%v, using the cached list %v", fetchErr, r.CacheFile)
+	return nil
+}
+
+// Fetches the list every interval until ctx is done and calls onChange after the cache got a new list.
+// Failed fetches are logged and the cached list stays in use
+func (r *RemoteList) Refresh(ctx context.Context, interval time.Duration, onChange func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := r.Fetch(ctx)
+		if err != nil {
+			glog.Errorf("failed to refresh list, keeping %v: %v", r.CacheFile, err)
+			continue
+		}
+		if changed {
+			glog.Infof("fetched a new list from %v into %v", r.URL, r.CacheFile)
+			if onChange != nil {
+				onChange()
+			}
+		}
+	}
+}
+
+// writes the file through a temporary file in the same directory, so readers never see a partial file
+func writeFileAtomic(fileName string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(fileName), filepath.Base(fileName) + ".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write %v: %v", fileName, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %v: %v", fileName, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %v: %v", fileName, err)
+	}
+	if err := os.Rename(tmp.Name(), fileName); err != nil {
+		return fmt.Errorf("failed to write %v: %v", fileName, err)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package logger
+
+import (
+	"testing"
+	"context"
+	"sync"
+	"time"
+	"strings"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+)
+
+//serves a list and its detached signature, the served bytes can be swapped while the server runs
+type listServer struct {
+	sync.Mutex
+	list	[]byte
+	sig		[]byte
+}
+
+func (s *listServer) set(list, sig []byte) {
+	s.Lock()
+	defer s.Unlock()
+	s.list, s.sig = list, sig
+}
+
+func (s *listServer) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	switch req.URL.Path {
+	case "/log_list.json":
+		res.Write(s.list)
+	case "/log_list.json" + ListSignatureSuffix:
+		res.Write(s.sig)
+	default:
+		http.NotFound(res, req)
+	}
+}
+
+//function that signs data the way a list is signed, with an ECDSA signature over SHA-256
+func mustSignList(t *testing.T, key crypto.Signer, data []byte) []byte {
+	t.Helper()
+	digest := sha256.Sum256(data)
+	sig, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("failed to sign list: %v", err)
+	}
+	return sig
+}
+
+//function that serves the test log list signed with a new key, returns the server, the list, the key and the cache file
+func mustServeLogList(t *testing.T) (*httptest.Server, *listServer, *ecdsa.PrivateKey, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	list, err := ioutil.ReadFile(logList_filename)
+	if err != nil {
+		t.Fatalf("failed to read log list: %v", err)
+	}
+	lists := &listServer{}
+	lists.set(list, mustSignList(t, key, list))
+	server := httptest.NewServer(lists)
+	t.Cleanup(server.Close)
+	return server, lists, key, filepath.Join(t.TempDir(), "log_list.json")
+}
+
+func TestRemoteListFetch(t *testing.T) {
+	server, lists, key, cacheFile := mustServeLogList(t)
+	remoteList := NewRemoteLogList(server.URL + "/log_list.json", key.Public(), cacheFile)
+	ctx := context.Background()
+
+	changed, err := remoteList.Fetch(ctx)
+	if err != nil || !changed {
+		t.Fatalf("failed to fetch list: %v", err)
+	}
+	if _, err := NewLogger(config_filename, caList_filename, cacheFile); err != nil {
+		t.Fatalf("failed to create logger from the cached list: %v", err)
+	}
+	if changed, err := remoteList.Fetch(ctx); err != nil || changed {
+		t.Fatalf("fetching the same list again should not change the cache: %v", err)
+	}
+
+	//a list signed with another key must not replace the cache
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	forged := []byte(`{"operators": [{"name": "forged", "logs": []}]}`)
+	lists.set(forged, mustSignList(t, otherKey, forged))
+	if _, err := remoteList.Fetch(ctx); err == nil {
+		t.Fatalf("list with an invalid signature should be rejected")
+	}
+	if err := remoteList.VerifyCache(); err != nil {
+		t.Fatalf("rejected list should leave the cache alone: %v", err)
+	}
+
+	//a signed list that does not parse is rejected as well
+	lists.set([]byte("{"), mustSignList(t, key, []byte("{")))
+	if _, err := remoteList.Fetch(ctx); err == nil {
+		t.Fatalf("list that does not parse should be rejected")
+	}
+
+	//oversized responses are not read to the end
+	list, _ := ioutil.ReadFile(logList_filename)
+	lists.set(list, make([]byte, MaxListSignatureSize + 1))
+	if _, err := remoteList.Fetch(ctx); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Fatalf("signature larger than %v bytes should be rejected, got %v", MaxListSignatureSize, err)
+	}
+	huge := make([]byte, MaxListSize + 1)
+	lists.set(huge, mustSignList(t, key, huge))
+	if _, err := remoteList.Fetch(ctx); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Fatalf("list larger than %v bytes should be rejected, got %v", MaxListSize, err)
+	}
+}
+
+func TestRemoteListSyncFallsBackToCache(t *testing.T) {
+	server, _, key, cacheFile := mustServeLogList(t)
+	remoteList := NewRemoteLogList(server.URL + "/log_list.json", key.Public(), cacheFile)
+	ctx := context.Background()
+	if err := remoteList.Sync(ctx); err != nil {
+		t.Fatalf("failed to sync list: %v", err)
+	}
+
+	server.Close()
+	if err := remoteList.Sync(ctx); err != nil {
+		t.Fatalf("sync should use the cached list when the server is down: %v", err)
+	}
+	if err := ioutil.WriteFile(cacheFile, []byte(`{"operators": []}`), 0600); err != nil {
+		t.Fatalf("failed to tamper with cache: %v", err)
+	}
+	if err := remoteList.Sync(ctx); err == nil {
+		t.Fatalf("sync should not use a cached list that fails verification")
+	}
+}
+
+func TestRemoteListRefresh(t *testing.T) {
+	server, lists, key, cacheFile := mustServeLogList(t)
+	remoteList := NewRemoteLogList(server.URL + "/log_list.json", key.Public(), cacheFile)
+	if err := remoteList.Sync(context.Background()); err != nil {
+		t.Fatalf("failed to sync list: %v", err)
+	}
+
+	changed := make(chan struct{}, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go remoteList.Refresh(ctx, 10*time.Millisecond, func() { changed <- struct{}{} })
+
+	list := []byte(`{"operators": [{"name": "refreshed", "logs": []}]}`)
+	lists.set(list, mustSignList(t, key, list))
+	select {
+	case <-changed:
+	case <-time.After(5*time.Second):
+		t.Fatalf("refreshed list was not fetched")
+	}
+	cached, err := ioutil.ReadFile(cacheFile)
+	if err != nil || string(cached) != string(list) {
+		t.Fatalf("cache should hold the refreshed list, got %s: %v", cached, err)
+	}
+}
+
+func TestLoadListPublicKey(t *testing.T) {
+	//Chrome's log list is signed with an RSA key
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %v", err)
+	}
+	fileName := filepath.Join(t.TempDir(), "log_list_pubkey.pem")
+	if err := ioutil.WriteFile(fileName, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("failed to write public key: %v", err)
+	}
+	publicKey, err := LoadListPublicKey(fileName)
+	if err != nil {
+		t.Fatalf("failed to load public key: %v", err)
+	}
+	data := []byte("list")
+	if err := verifyDetachedSignature(publicKey, data, mustSignList(t, key, data)); err != nil {
+		t.Fatalf("RSA list signature does not verify: %v", err)
+	}
+	if err := verifyDetachedSignature(publicKey, []byte("other list"), mustSignList(t, key, data)); err == nil {
+		t.Fatalf("RSA list signature should not verify over other data")
+	}
+}
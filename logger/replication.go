@@ -0,0 +1,345 @@
+package logger
+
+import (
+	"io"
+	"fmt"
+	"errors"
+	"context"
+	"strconv"
+	"time"
+	"io/ioutil"
+	"encoding/json"
+	"net/http"
+
+	"github.com/golang/glog"
+	mtr "github.com/n-ct/ct-monitor"
+	"github.com/n-ct/ct-monitor/utils"
+)
+
+const (
+	GetSRDUpdatesPath = "/ct/v1/get-srd-updates"
+	// Longest a leader holds a GetSRDUpdates request open while waiting for a new update
+	MaxSRDUpdatesWait = 60 * time.Second
+	// Most SRDs in a single SRDUpdates, and the number returned when the request sets no limit
+	MaxSRDUpdates = 1000
+	// Bytes of the SRDs in a single SRDUpdates. The first SRD is always returned, so a response may exceed it by one SRD
+	MaxSRDUpdatesSize = 8 << 20
+	// Uncompacted entries of the SRDHistory, unless max_srd_history is set, see compactHistory
+	DefaultMaxSRDHistory = 100000
+)
+
+// SRDUpdates is the response of a leader to a GetSRDUpdates request.
+// Updates holds the CA signed SRDWithRevData accepted by the leader with sequence numbers Start up to Next,
+// the start of the following request
+type SRDUpdates struct {
+	Start	uint64
+	Next	uint64
+	Updates	[]*mtr.SRDWithRevData
+}
+
+// Sequence number of the next SRD the logger accepts. Caller must hold at least the read lock
+func (this *Logger) historyEnd() uint64 {
+	return this.historyStart + uint64(len(this.SRDHistory) - this.historyCompacted)
+}
+
+// Return up to limit SRDs accepted with a sequence number of start or later, at most MaxSRDUpdatesSize bytes of them.
+// If there are none, wait up to wait for the next one to be accepted.
+// SRDs before the compacted part of the history can no longer be returned, a follower that needs them is seeded from a state archive
+func (this *Logger) GetSRDUpdates(ctx context.Context, start uint64, limit int, wait time.Duration) (*SRDUpdates, error) {
+	if limit <= 0 || limit > MaxSRDUpdates {
+		limit = MaxSRDUpdates
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		this.RLock()
+		historyEnd := this.historyEnd()
+		if start > historyEnd {
+			this.RUnlock()
+			return nil, NewError(ErrorCodeInvalidRequest, nil, "start (%v) is beyond the end of the SRD history (%v)", start, historyEnd)
+		}
+		if start < this.historyStart {
+			historyStart := this.historyStart
+			this.RUnlock()
+			return nil, NewError(ErrorCodeHistoryCompacted, nil, "SRD updates before %v were compacted, seed the follower from a state archive", historyStart).
+				With("history_start", strconv.FormatUint(historyStart, 10))
+		}
+		if start < historyEnd || wait <= 0 {
+			first := this.historyCompacted + int(start - this.historyStart)
+			updates, err := boundUpdates(this.SRDHistory[first:], limit)
+			this.RUnlock()
+			if err != nil {
+				return nil, err
+			}
+			return &SRDUpdates{Start: start, Next: start + uint64(len(updates)), Updates: updates}, nil
+		}
+		updated := this.updateSignal()
+		this.RUnlock()
+
+		select {
+		case <-updated:
+		case <-timer.C:
+			wait = 0
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+//returns a copy of the first SRDs of history, at most limit of them and at most MaxSRDUpdatesSize bytes after the first
+func boundUpdates(history []*mtr.SRDWithRevData, limit int) ([]*mtr.SRDWithRevData, error) {
+	updates := []*mtr.SRDWithRevData{}
+	size := 0
+	for _, srd := range history {
+		if len(updates) == limit {
+			break
+		}
+		jsonBytes, err := json.Marshal(srd)
+		if err != nil {
+			return nil, NewError(ErrorCodeInternal, err, "failed to marshal SRD of CA (%v)", srd.SRD.EntityID)
+		}
+		size += len(jsonBytes)
+		if len(updates) > 0 && size > MaxSRDUpdatesSize {
+			break
+		}
+		updates = append(updates, srd)
+	}
+	return updates, nil
+}
+
+// Append an accepted CA signed SRD to the history and compact the history once it holds more than MaxSRDHistory uncompacted SRDs.
+// Caller must hold the write lock
+func (this *Logger) appendHistory(srd *mtr.SRDWithRevData) {
+	this.SRDHistory = append(this.SRDHistory, srd)
+	if this.MaxSRDHistory > 0 && len(this.SRDHistory) - this.historyCompacted > this.MaxSRDHistory {
+		this.compactHistory(this.MaxSRDHistory / 2)
+	}
+}
+
+// Drop all but the newest keep uncompacted SRDs of the history. Of the dropped ones and the compacted prefix,
+// only the latest SRD of every CA and revocation type without a newer kept SRD stays, in the order it was accepted,
+// as a state archive has to hold the CA signed SRD of every logger SRD. The sequence numbers of the kept SRDs are unchanged.
+// Caller must hold the write lock
+func (this *Logger) compactHistory(keep int) {
+	cut := len(this.SRDHistory) - keep
+	if cut <= this.historyCompacted {
+		return
+	}
+	kept := this.SRDHistory[cut:]
+	latest := make(map[string] int)
+	for i, srd := range this.SRDHistory {
+		latest[srd.SRD.EntityID + "/" + srd.RevData.RevocationType] = i
+	}
+	history := []*mtr.SRDWithRevData{}
+	for i, srd := range this.SRDHistory[:cut] {
+		if latest[srd.SRD.EntityID + "/" + srd.RevData.RevocationType] == i {
+			history = append(history, srd)
+		}
+	}
+	dropped := cut - this.historyCompacted
+	this.historyCompacted = len(history)
+	this.SRDHistory = append(history, kept...)
+	this.historyStart += uint64(dropped)
+	glog.Infof("logger (%v) compacted %v SRDs of its history, updates start at %v", this.LogID, dropped, this.historyStart)
+}
+
+// Returns a channel that is closed the next time an SRD is accepted. Caller must hold at least the read lock
+func (this *Logger) updateSignal() <-chan struct{} {
+	this.updatedMu.Lock()
+	defer this.updatedMu.Unlock()
+	if this.updated == nil {
+		this.updated = make(chan struct{})
+	}
+	return this.updated
+}
+
+// Wake up everyone waiting for a new SRD. Caller must hold the write lock
+func (this *Logger) signalUpdate() {
+	this.updatedMu.Lock()
+	defer this.updatedMu.Unlock()
+	if this.updated != nil {
+		close(this.updated)
+		this.updated = nil
+	}
+}
+
+// IsFollower returns true while the logger replicates from a leader and refuses SRDs posted to it directly
+func (this *Logger) IsFollower() bool {
+	this.RLock()
+	defer this.RUnlock()
+	return this.follower
+}
+
+func (this *Logger) setFollower(follower bool) {
+	this.Lock()
+	this.follower = follower
+	this.Unlock()
+}
+
+func (this *Logger) OnGetSRDUpdates(res http.ResponseWriter, req *http.Request) {
+	start, err := strconv.ParseUint(req.URL.Query().Get("start"), 10, 64)
+	if err != nil {
+		WriteError(res, NewError(ErrorCodeInvalidRequest, err, "Invalid start"))
+		return
+	}
+	var wait time.Duration
+	if waitParam := req.URL.Query().Get("wait"); waitParam != "" {
+		seconds, err := strconv.ParseUint(waitParam, 10, 32)
+		if err != nil {
+			WriteError(res, NewError(ErrorCodeInvalidRequest, err, "Invalid wait"))
+			return
+		}
+		wait = time.Duration(seconds) * time.Second
+		if wait > MaxSRDUpdatesWait {
+			wait = MaxSRDUpdatesWait
+		}
+	}
+	var limit int
+	if limitParam := req.URL.Query().Get("limit"); limitParam != "" {
+		parsed, err := strconv.ParseUint(limitParam, 10, 32)
+		if err != nil {
+			WriteError(res, NewError(ErrorCodeInvalidRequest, err, "Invalid limit"))
+			return
+		}
+		limit = int(parsed)
+	}
+	updates, err := this.GetSRDUpdates(req.Context(), start, limit, wait)
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+	jsonBytes, err := json.Marshal(updates)
+	if err != nil {
+		WriteError(res, NewError(ErrorCodeInternal, err, "failed to marshal SRDUpdates"))
+		return
+	}
+	res.Write(jsonBytes)
+}
+
+// Follower keeps a standby logger in sync with a leader by streaming the SRDs accepted by the leader
+// and reapplying them with the same verification as SRDs posted by CAs
+type Follower struct {
+	Logger			*Logger
+	LeaderURL		string
+	// How long the leader may hold each request open waiting for new SRDs
+	PollWait		time.Duration
+	// How long to wait before retrying after the leader could not be reached
+	RetryInterval	time.Duration
+	// Client used to reach the leader, replace it to talk to a leader that requires client certificates
+	HTTPClient		*http.Client
+	promote			chan struct{}
+}
+
+// Create a new Follower that replicates l from the leader at leaderURL.
+// The logger refuses posts from here on, create it before l is served so no CA can post to it in between
+func NewFollower(l *Logger, leaderURL string) *Follower {
+	l.setFollower(true)
+	return &Follower{
+		Logger:			l,
+		LeaderURL:		leaderURL,
+		PollWait:		30 * time.Second,
+		RetryInterval:	5 * time.Second,
+		HTTPClient:		&http.Client{},
+		promote:		make(chan struct{}),
+	}
+}
+
+// Replicate from the leader until ctx is cancelled or the follower is promoted.
+// Returns an error if an SRD from the leader fails verification, as the two loggers would have diverged,
+// and ErrHistoryCompacted if the leader no longer holds the SRDs the follower needs next
+
+func (f *Follower) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-f.promote:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		f.Logger.RLock()
+		start := f.Logger.historyEnd()
+		f.Logger.RUnlock()
+
+		updates, err := f.fetchUpdates(ctx, start)
+		if ctx.Err() != nil {
+			return f.stopped()
+		}
+		if errors.Is(err, ErrHistoryCompacted) {
+			return fmt.Errorf("failed to replicate from leader %v: %w", f.LeaderURL, err)
+		}
+		if err != nil {
+			glog.Warningf("failed to get SRD updates from leader %v: %v", f.LeaderURL, err)
+			select {
+			case <-time.After(f.RetryInterval):
+				continue
+			case <-ctx.Done():
+				return f.stopped()
+			}
+		}
+		for _, update := range updates.Updates {
+			if _, err := f.Logger.updateLogSRDWithRevDataFrom(ctx, update, "leader " + f.LeaderURL); err != nil {
+				return fmt.Errorf("failed to apply SRD of CA (%v) from leader: %w", update.SRD.EntityID, err)
+			}
+		}
+	}
+}
+
+// Promote the follower: replication stops and the logger accepts SRDs posted to it directly
+func (f *Follower) Promote() {
+	select {
+	case <-f.promote:
+	default:
+		close(f.promote)
+	}
+}
+
+func (f *Follower) stopped() error {
+	select {
+	case <-f.promote:
+		f.Logger.setFollower(false)
+		glog.Infof("follower promoted to leader")
+		return nil
+	default:
+		return context.Canceled
+	}
+}
+
+func (f *Follower) fetchUpdates(ctx context.Context, start uint64) (*SRDUpdates, error) {
+	url := fmt.Sprintf("%v?start=%v&wait=%v", utils.CreateRequestURL(f.LeaderURL, GetSRDUpdatesPath), start, int(f.PollWait.Seconds()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	resp, err := f.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	//one SRD more than MaxSRDUpdatesSize, as the first is always returned
+	maxSize := int64(MaxSRDUpdatesSize + MaxSRDBodySize)
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize + 1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	if int64(len(body)) > maxSize {
+		return nil, fmt.Errorf("leader response is larger than %v bytes", maxSize)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if loggerErr := ParseErrorResponse(body); loggerErr != nil {
+			return nil, fmt.Errorf("leader responded with %v: %w", resp.Status, loggerErr)
+		}
+		return nil, fmt.Errorf("leader responded with %v: %s", resp.Status, body)
+	}
+	var updates SRDUpdates
+	if err := json.Unmarshal(body, &updates); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal SRDUpdates: %v", err)
+	}
+	if updates.Start != start || updates.Next != start + uint64(len(updates.Updates)) {
+		return nil, fmt.Errorf("leader returned updates %v to %v instead of %v SRDs starting at %v", updates.Start, updates.Next, len(updates.Updates), start)
+	}
+	return &updates, nil
+}
@@ -0,0 +1,229 @@
+package logger
+
+import (
+	"testing"
+	"context"
+	"bytes"
+	"errors"
+	"time"
+	"net/http"
+	"net/http/httptest"
+	mtr "github.com/n-ct/ct-monitor"
+	ctca "github.com/n-ct/ct-certificate-authority"
+	"github.com/n-ct/ct-logger/internal/testutil"
+)
+
+//function that serves the leader on loopback and starts a follower replicating from it.
+//The returned function waits until Run returned and gives its error, the test cleanup cancels Run and waits too
+func mustStartLeaderAndFollower(t *testing.T) (*Logger, *Logger, *Follower, func() error) {
+	t.Helper()
+	leader, err := mustCreateLogger(t)
+	if err != nil {
+		t.Fatalf("failed to create leader: %v", err)
+	}
+	standby, err := mustCreateLogger(t)
+	if err != nil {
+		t.Fatalf("failed to create follower: %v", err)
+	}
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc(GetSRDUpdatesPath, leader.OnGetSRDUpdates)
+	server := httptest.NewServer(serveMux)
+	t.Cleanup(server.Close)
+
+	follower := NewFollower(standby, server.URL)
+	follower.PollWait = time.Second
+	follower.RetryInterval = 100 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	var runErr error
+	go func() {
+		runErr = follower.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	wait := func() error {
+		<-done
+		return runErr
+	}
+	return leader, standby, follower, wait
+}
+
+//function that waits until the logger has accepted n SRDs
+func mustWaitForHistory(t *testing.T, logger *Logger, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		logger.RLock()
+		historyLen := len(logger.SRDHistory)
+		logger.RUnlock()
+		if historyLen == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("follower did not replicate %v SRDs in time", n)
+}
+
+func TestFollowerReplicatesLeader(t *testing.T) {
+	leader, standby, _, _ := mustStartLeaderAndFollower(t)
+
+	err := mustUpdateLogSRDWithRevData(t, leader, ctca.CreateCRV([]uint64{1,3}, 0), ctca.GetCRVDelta([]uint64{1,3}))
+	if err != nil {
+		t.Fatalf("leader not updated correctly 1: %v", err)
+	}
+	mustWaitForHistory(t, standby, 1)
+
+	err = mustUpdateLogSRDWithRevData(t, leader, ctca.CreateCRV([]uint64{1,3,4,5,7}, 0), ctca.GetCRVDelta([]uint64{4,5,7}))
+	if err != nil {
+		t.Fatalf("leader not updated correctly 2: %v", err)
+	}
+	mustWaitForHistory(t, standby, 2)
+
+	leaderSRD := leader.LogSRDWithRevDataMap[ca_id]["Let's-Revoke"]
+	standby.RLock()
+	standbySRD := standby.LogSRDWithRevDataMap[ca_id]["Let's-Revoke"]
+	standby.RUnlock()
+	if !bytes.Equal(leaderSRD.SRD.RevDigest.CRVHash, standbySRD.SRD.RevDigest.CRVHash) {
+		t.Fatalf("follower CRV does not match the leader CRV")
+	}
+}
+
+func TestFollowerRejectsPostsUntilPromoted(t *testing.T) {
+	_, standby, follower, wait := mustStartLeaderAndFollower(t)
+	if !standby.IsFollower() {
+		t.Fatalf("logger should be a follower as soon as the Follower is created")
+	}
+
+	res := httptest.NewRecorder()
+	standby.OnPostLogSRDWithRevData(res, httptest.NewRequest("POST", PostLogSRDWithRevDataPath, bytes.NewBufferString("{}")))
+	if res.Code != http.StatusServiceUnavailable {
+		t.Fatalf("follower should reject posts with %v not %v", http.StatusServiceUnavailable, res.Code)
+	}
+
+	follower.Promote()
+	if err := wait(); err != nil {
+		t.Fatalf("promoted follower returned error: %v", err)
+	}
+	if standby.IsFollower() {
+		t.Fatalf("promoted follower still refuses posts")
+	}
+	err := mustUpdateLogSRDWithRevData(t, standby, ctca.CreateCRV([]uint64{2}, 0), ctca.GetCRVDelta([]uint64{2}))
+	if err != nil {
+		t.Fatalf("promoted follower not updated correctly: %v", err)
+	}
+}
+
+//function that applies n CA signed SRDs to logger, each revoking one more certificate
+func mustApplySRDs(t *testing.T, logger *Logger, n int) {
+	t.Helper()
+	now := time.Now()
+	revoked := []uint64{}
+	for i := 0; i < n; i++ {
+		revoked = append(revoked, uint64(i))
+		srd := testutil.CreateCASRD(t, revoked, []uint64{uint64(i)}, now.Add(time.Duration(i) * time.Second))
+		if err := logger.UpdateLogSRDWithRevData(srd); err != nil {
+			t.Fatalf("failed to apply SRD %v: %v", i, err)
+		}
+	}
+}
+
+func TestGetSRDUpdatesPages(t *testing.T) {
+	logger, _ := mustCreateLogger(t)
+	mustApplySRDs(t, logger, 3)
+
+	first, err := logger.GetSRDUpdates(context.Background(), 0, 2, 0)
+	if err != nil {
+		t.Fatalf("failed to get first page: %v", err)
+	}
+	if first.Start != 0 || first.Next != 2 || len(first.Updates) != 2 {
+		t.Fatalf("first page should hold SRDs 0 to 2, got %v to %v with %v SRDs", first.Start, first.Next, len(first.Updates))
+	}
+	second, err := logger.GetSRDUpdates(context.Background(), first.Next, 2, 0)
+	if err != nil {
+		t.Fatalf("failed to get second page: %v", err)
+	}
+	if second.Next != 3 || len(second.Updates) != 1 || second.Updates[0] != logger.SRDHistory[2] {
+		t.Fatalf("second page should hold SRD 2 only, got %v to %v with %v SRDs", second.Start, second.Next, len(second.Updates))
+	}
+	if _, err := logger.GetSRDUpdates(context.Background(), 4, 0, 0); !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("start beyond the history should be an invalid request, got %v", err)
+	}
+}
+
+func TestCompactHistory(t *testing.T) {
+	logger, _ := mustCreateLogger(t)
+	srd := func(caID, revType string) *mtr.SRDWithRevData {
+		srd := &mtr.SRDWithRevData{}
+		srd.SRD.EntityID = caID
+		srd.RevData.RevocationType = revType
+		return srd
+	}
+	a1, b1, a2, c1, b2, a3 := srd("a", "x"), srd("b", "x"), srd("a", "x"), srd("a", "y"), srd("b", "x"), srd("a", "x")
+	logger.SRDHistory = []*mtr.SRDWithRevData{a1, b1, a2, c1, b2, a3}
+
+	logger.compactHistory(2)
+	//a2 and c1 are the latest of their CA and revocation type before the kept b2 and a3, only c1 is not superseded by them
+	want := []*mtr.SRDWithRevData{c1, b2, a3}
+	if logger.historyStart != 4 || logger.historyCompacted != 1 || len(logger.SRDHistory) != len(want) {
+		t.Fatalf("expected start 4 with 1 compacted SRD of 3, got start %v with %v of %v", logger.historyStart, logger.historyCompacted, len(logger.SRDHistory))
+	}
+	for i := range want {
+		if logger.SRDHistory[i] != want[i] {
+			t.Fatalf("SRD %v of the compacted history is not the expected one", i)
+		}
+	}
+	if logger.historyEnd() != 6 {
+		t.Fatalf("compaction should not move the end of the history, got %v", logger.historyEnd())
+	}
+	updates, err := logger.GetSRDUpdates(context.Background(), 4, 0, 0)
+	if err != nil || len(updates.Updates) != 2 || updates.Updates[0] != b2 {
+		t.Fatalf("updates from 4 should be the kept SRDs, got %v: %v", updates, err)
+	}
+	if _, err := logger.GetSRDUpdates(context.Background(), 3, 0, 0); !errors.Is(err, ErrHistoryCompacted) {
+		t.Fatalf("updates before the start should be compacted, got %v", err)
+	}
+}
+
+func TestHistoryIsBounded(t *testing.T) {
+	logger, _ := mustCreateLogger(t)
+	logger.MaxSRDHistory = 4
+	mustApplySRDs(t, logger, 7)
+	if len(logger.SRDHistory) > logger.MaxSRDHistory || logger.historyEnd() != 7 {
+		t.Fatalf("history should hold at most %v SRDs and end at 7, holds %v ending at %v", logger.MaxSRDHistory, len(logger.SRDHistory), logger.historyEnd())
+	}
+
+	//a standby seeded from the compacted state resumes at the same sequence number
+	archive, err := logger.ExportState()
+	if err != nil {
+		t.Fatalf("failed to export state: %v", err)
+	}
+	standby, _ := mustCreateLogger(t)
+	if err := standby.ImportState(archive); err != nil {
+		t.Fatalf("failed to import compacted state: %v", err)
+	}
+	if standby.historyEnd() != logger.historyEnd() || standby.historyStart != logger.historyStart {
+		t.Fatalf("standby history should end at %v, got %v", logger.historyEnd(), standby.historyEnd())
+	}
+}
+
+func TestFollowerStopsOnCompactedHistory(t *testing.T) {
+	leader, _ := mustCreateLogger(t)
+	leader.MaxSRDHistory = 2
+	mustApplySRDs(t, leader, 3)
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc(GetSRDUpdatesPath, leader.OnGetSRDUpdates)
+	server := httptest.NewServer(serveMux)
+	defer server.Close()
+
+	standby, _ := mustCreateLogger(t)
+	follower := NewFollower(standby, server.URL)
+	follower.RetryInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+	defer cancel()
+	if err := follower.Run(ctx); !errors.Is(err, ErrHistoryCompacted) {
+		t.Fatalf("follower behind the compacted history should stop with %v, got %v", ErrHistoryCompacted, err)
+	}
+}
@@ -0,0 +1,144 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+	"github.com/golang/glog"
+	el "github.com/n-ct/ct-monitor/entitylist"
+)
+
+//severities of a Diagnostic
+const (
+	SeverityError	= "error" //the logger does not start
+	SeverityWarning	= "warning" //the logger starts, the diagnostic is logged
+)
+
+//names of the startup self-checks
+const (
+	CheckLogKey				= "log-key" //the log list key of the logger is one of its signing keys and signs
+	CheckSignatureAlgorithm	= "signature-algorithm" //the log list key signs with the algorithm advertised in the log list
+	CheckKeyReuse			= "key-reuse" //no signing key of the logger is the key of a CA or of another log
+	CheckCAIDs				= "ca-ids" //every CA in ca_ids is in the ca list, once
+)
+
+//outcome of a startup self-check that found a problem
+type Diagnostic struct {
+	Check		string	`json:"check"`
+	Severity	string	`json:"severity"`
+	LogID		string	`json:"log_id"`
+	Subject		string	`json:"subject,omitempty"` //key ID or CA ID the diagnostic is about
+	Message		string	`json:"message"`
+}
+
+func (d *Diagnostic) String() string {
+	if d.Subject == "" {
+		return fmt.Sprintf("%v [%v] logger (%v): %v", d.Severity, d.Check, d.LogID, d.Message)
+	}
+	return fmt.Sprintf("%v [%v] logger (%v), %v: %v", d.Severity, d.Check, d.LogID, d.Subject, d.Message)
+}
+
+//returned by NewLogger when a self-check finds an error, Diagnostics holds every problem found
+type SelfCheckError struct {
+	Diagnostics	[]Diagnostic
+}
+
+func (e *SelfCheckError) Error() string {
+	messages := []string{}
+	for i := range e.Diagnostics {
+		if e.Diagnostics[i].Severity == SeverityError {
+			messages = append(messages, e.Diagnostics[i].String())
+		}
+	}
+	return fmt.Sprintf("logger self-check failed: %v", strings.Join(messages, "; "))
+}
+
+//Runs the startup self-checks of the logger against the log list it was created from and its ca list.
+//Returns the problems found, nil if there are none
+func (this *Logger) SelfCheck(logList *el.LogList) []Diagnostic {
+	this.RLock()
+	defer this.RUnlock()
+	var diagnostics []Diagnostic
+	report := func(check, severity, subject, format string, args ...interface{}) {
+		diagnostics = append(diagnostics, Diagnostic{check, severity, this.LogID, subject, fmt.Sprintf(format, args...)})
+	}
+
+	//the key clients trust must be a key of the logger, and its signer must make signatures that verify with it
+	logKeyID, err := KeyIDFromPublicKey(this.PublicKey)
+	if err != nil {
+		report(CheckLogKey, SeverityError, "", "log list key is invalid: %v", err)
+	}
+	var logKey *LoggerKey
+	for i := range this.Keys {
+		if this.Keys[i].KeyID == logKeyID {
+			logKey = &this.Keys[i]
+		}
+	}
+	if logKey == nil && err == nil {
+		report(CheckLogKey, SeverityError, logKeyID, "log list key is not one of the signing keys in the logger config")
+	}
+	if logKey != nil {
+		probe := KeyEndorsement{LogID: this.LogID, KeyID: logKey.KeyID, PublicKey: logKey.PublicKey}
+		sig, err := this.signers[logKey.KeyID].CreateSignature(probe)
+		if err == nil {
+			err = VerifySignature(this.PublicKey, probe, *sig)
+		}
+		if err != nil {
+			report(CheckLogKey, SeverityError, logKey.KeyID, "private key does not sign for the log list key: %v", err)
+		}
+		if this.SignatureAlgorithm != "" && logKey.SignatureAlgorithm != this.SignatureAlgorithm {
+			report(CheckSignatureAlgorithm, SeverityError, logKey.KeyID, "log list advertises %v but the key is configured for %v", this.SignatureAlgorithm, logKey.SignatureAlgorithm)
+		}
+	}
+
+	//a key shared with another entity lets it sign in the name of the logger
+	for i := range this.Keys {
+		key := &this.Keys[i]
+		for _, operator := range this.CAList.CAOperators {
+			for _, caInfo := range operator.CAs {
+				if keyID, err := KeyIDFromPublicKey(caInfo.CAKey); err == nil && keyID == key.KeyID {
+					report(CheckKeyReuse, SeverityError, key.KeyID, "signing key is also the key of CA (%v)", caInfo.CAID)
+				}
+			}
+		}
+		for _, operator := range logList.Operators {
+			for _, logInfo := range operator.Logs {
+				if logInfo.LogID == this.LogID {
+					continue
+				}
+				if keyID, err := KeyIDFromPublicKey(logInfo.Key); err == nil && keyID == key.KeyID {
+					report(CheckKeyReuse, SeverityError, key.KeyID, "signing key is also the key of logger (%v)", logInfo.LogID)
+				}
+			}
+		}
+	}
+
+	seen := make(map[string] bool)
+	for _, caID := range this.CAIDs {
+		if seen[caID] {
+			report(CheckCAIDs, SeverityWarning, caID, "CA is listed more than once in ca_ids")
+			continue
+		}
+		seen[caID] = true
+		if this.CAList.FindCAByCAID(caID) == nil {
+			report(CheckCAIDs, SeverityError, caID, "CA in ca_ids is not in the ca list")
+		}
+	}
+	return diagnostics
+}
+
+//logs the diagnostics and returns a SelfCheckError if any of them is an error
+func checkDiagnostics(diagnostics []Diagnostic) error {
+	failed := false
+	for i := range diagnostics {
+		if diagnostics[i].Severity == SeverityError {
+			glog.Errorf("%v", diagnostics[i].String())
+			failed = true
+		} else {
+			glog.Warningf("%v", diagnostics[i].String())
+		}
+	}
+	if failed {
+		return &SelfCheckError{diagnostics}
+	}
+	return nil
+}
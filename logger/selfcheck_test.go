@@ -0,0 +1,139 @@
+package logger
+
+import (
+	"testing"
+	"errors"
+	"strings"
+	"io/ioutil"
+	"path/filepath"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"github.com/n-ct/ct-logger/internal/testutil"
+)
+
+//function that writes a copy of the test log list in which the logger of the test config has publicKey,
+//advertising signatureAlgorithm or no algorithm when it is empty
+func mustWriteLogListWithKey(t *testing.T, publicKey, signatureAlgorithm string) string {
+	t.Helper()
+	logListBytes, err := ioutil.ReadFile(logList_filename)
+	if err != nil {
+		t.Fatalf("failed to read log list: %v", err)
+	}
+	advertised := ""
+	if signatureAlgorithm != "" {
+		advertised = `"signature_algorithm": "` + signatureAlgorithm + `",`
+	}
+	logList := strings.Replace(string(logListBytes), `"signature_algorithm": "ecdsa-p256-sha256",`, advertised, 1)
+	logList = strings.Replace(logList, mustGetLogListKey(t), publicKey, 1)
+	logListName := filepath.Join(t.TempDir(), "log_list.json")
+	if err := ioutil.WriteFile(logListName, []byte(logList), 0600); err != nil {
+		t.Fatalf("failed to write log list: %v", err)
+	}
+	return logListName
+}
+
+//function that returns the log list key of the logger of the test config
+func mustGetLogListKey(t *testing.T) string {
+	t.Helper()
+	logger, err := mustCreateLogger(t)
+	if err != nil {
+		t.Fatalf("failed to create Logger: %v", err)
+	}
+	return logger.PublicKey
+}
+
+//function that returns the diagnostics of a SelfCheckError, failing the test for any other error
+func mustGetDiagnostics(t *testing.T, err error) []Diagnostic {
+	t.Helper()
+	var selfCheckErr *SelfCheckError
+	if !errors.As(err, &selfCheckErr) {
+		t.Fatalf("expected a SelfCheckError, got %v", err)
+	}
+	return selfCheckErr.Diagnostics
+}
+
+func hasDiagnostic(diagnostics []Diagnostic, check, severity string) bool {
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Check == check && diagnostic.Severity == severity {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSelfCheckPasses(t *testing.T) {
+	logger, err := mustCreateLogger(t)
+	if err != nil {
+		t.Fatalf("failed to create Logger: %v", err)
+	}
+	if len(logger.Diagnostics) != 0 {
+		t.Fatalf("test config should pass every self-check, got %v", logger.Diagnostics)
+	}
+}
+
+func TestSelfCheckRejectsCAKey(t *testing.T) {
+	//the logger signs with the CA key, which is not its log list key
+	configName := testutil.WriteConfig(t, config_filename, testutil.WithFields(KeySource{PrivKey: testutil.CAPrivateKey}))
+	_, err := NewLogger(configName, caList_filename, logList_filename)
+	diagnostics := mustGetDiagnostics(t, err)
+	if !hasDiagnostic(diagnostics, CheckLogKey, SeverityError) {
+		t.Fatalf("self-check should report the log list key missing, got %v", diagnostics)
+	}
+	if !hasDiagnostic(diagnostics, CheckKeyReuse, SeverityError) {
+		t.Fatalf("self-check should report the key shared with the CA, got %v", diagnostics)
+	}
+}
+
+//a crypto.Signer that claims a public key it does not sign for
+type mismatchedSigner struct {
+	crypto.Signer
+	public	crypto.PublicKey
+}
+
+func (s *mismatchedSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+func TestSelfCheckRejectsMismatchedSigner(t *testing.T) {
+	logKey, err := LoadPEMPrivateKey(key_pem_filename, nil)
+	if err != nil {
+		t.Fatalf("failed to load key: %v", err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	RegisterSignerProvider("test-mismatched", func(options map[string]string) (crypto.Signer, error) {
+		return &mismatchedSigner{otherKey, logKey.Public()}, nil
+	})
+	configName := testutil.WriteConfig(t, config_filename, testutil.WithFields(KeySource{Signer: &SignerConfig{Provider: "test-mismatched"}}))
+	_, err = NewLogger(configName, caList_filename, logList_filename)
+	if diagnostics := mustGetDiagnostics(t, err); !hasDiagnostic(diagnostics, CheckLogKey, SeverityError) {
+		t.Fatalf("self-check should report a signer that does not sign for the log list key, got %v", diagnostics)
+	}
+}
+
+func TestSelfCheckCAIDs(t *testing.T) {
+	config, err := parseLoggerConfig(config_filename)
+	if err != nil {
+		t.Fatalf("failed to parse logger config: %v", err)
+	}
+	caID := config.CAIDs[0]
+
+	configName := testutil.WriteConfig(t, config_filename, testutil.WithField("ca_ids", []string{caID, "bm90IGEgQ0EgaW4gdGhlIGNhIGxpc3QgYXQgYWxsIQ=="}))
+	_, err = NewLogger(configName, caList_filename, logList_filename)
+	if diagnostics := mustGetDiagnostics(t, err); !hasDiagnostic(diagnostics, CheckCAIDs, SeverityError) {
+		t.Fatalf("self-check should report a CA missing from the ca list, got %v", diagnostics)
+	}
+
+	configName = testutil.WriteConfig(t, config_filename, testutil.WithField("ca_ids", []string{caID, caID}))
+	logger, err := NewLogger(configName, caList_filename, logList_filename)
+	if err != nil {
+		t.Fatalf("a CA listed twice should only be a warning: %v", err)
+	}
+	if !hasDiagnostic(logger.Diagnostics, CheckCAIDs, SeverityWarning) {
+		t.Fatalf("self-check should warn about a CA listed twice, got %v", logger.Diagnostics)
+	}
+}
@@ -0,0 +1,41 @@
+package logger
+
+//returns the error a request sending SRDs gets when the logger does not take them right now, nil when it does
+func (this *Logger) checkAccepting() *Error {
+	this.RLock()
+	defer this.RUnlock()
+	switch {
+	case this.draining:
+		return NewError(ErrorCodeShuttingDown, nil, "Logger is shutting down, send to another logger")
+	case this.follower:
+		return NewError(ErrorCodeFollower, nil, "Logger is a follower, send to the leader instead")
+	case this.statePending:
+		return NewError(ErrorCodeNotReady, nil, "Logger is loading its state, try again later")
+	}
+	return nil
+}
+
+// Drain stops the logger from applying SRDs, posted, forwarded by a CA or replicated, and returns once
+// the update in progress, if any, is applied. Requests sending SRDs get shutting_down from then on and
+// the logger is not ready. The state does not change after Drain, so it can be exported consistently
+func (this *Logger) Drain() {
+	this.Lock()
+	this.draining = true
+	this.Unlock()
+}
+
+// IsDraining returns true once Drain was called
+func (this *Logger) IsDraining() bool {
+	this.RLock()
+	defer this.RUnlock()
+	return this.draining
+}
+
+// Close drains the logger and closes its audit log. The logger serves no SRDs after
+func (this *Logger) Close() error {
+	this.Drain()
+	if this.audit != nil {
+		return this.audit.Close()
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package logger
+
+import (
+	"testing"
+	"errors"
+	"time"
+	"net/http"
+	"net/http/httptest"
+	"github.com/n-ct/ct-logger/internal/testutil"
+)
+
+func TestDrain(t *testing.T) {
+	logger, _ := mustCreateLogger(t)
+	mustUpdateAt(t, logger, []uint64{1}, []uint64{1}, time.Now())
+
+	//Drain waits for the update in progress, which holds the lock
+	logger.Lock()
+	drained := make(chan struct{})
+	go func() {
+		logger.Drain()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		t.Fatalf("Drain should wait for the update in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+	logger.Unlock()
+	<-drained
+
+	err := logger.UpdateLogSRDWithRevData(testutil.CreateCASRD(t, []uint64{1,3}, []uint64{3}, time.Now()))
+	if !errors.Is(err, ErrShuttingDown) {
+		t.Fatalf("drained logger should apply no SRDs, got %v", err)
+	}
+	res := httptest.NewRecorder()
+	logger.OnPostLogSRDWithRevData(res, newPostRequest(mustCreateCASRDBody(t)))
+	mustGetErrorResponse(t, res, http.StatusServiceUnavailable, ErrorCodeShuttingDown)
+	res = httptest.NewRecorder()
+	logger.OnRevokeAndProduceSRD(res, newPostRequest([]byte(`{"percent_revoked": 10, "total_certs": 100}`)))
+	mustGetErrorResponse(t, res, http.StatusServiceUnavailable, ErrorCodeShuttingDown)
+
+	//the state stays readable and the logger is not ready
+	if _, err := logger.GetAllLogSrdWithRevDataAsJSONBytes(); err != nil {
+		t.Fatalf("drained logger should still serve its SRDs: %v", err)
+	}
+	readiness := CheckReadiness([]*Logger{logger})
+	if readiness.Status != HealthUnavailable || checkStatus(readiness, CheckDraining, "") != HealthUnavailable {
+		t.Fatalf("drained logger should not be ready, got %+v", readiness)
+	}
+}
+
+func TestCloseFlushesAuditLog(t *testing.T) {
+	logger, fileName := mustCreateLoggerWithAuditLog(t)
+	mustUpdateAt(t, logger, []uint64{1}, []uint64{1}, time.Now())
+	if err := logger.Close(); err != nil {
+		t.Fatalf("failed to close logger: %v", err)
+	}
+	if !logger.IsDraining() {
+		t.Fatalf("closed logger should be drained")
+	}
+	if err := logger.audit.Append(&AuditRecord{Event: AuditAccept}); err == nil {
+		t.Fatalf("closed audit log should not take records")
+	}
+	//the records written before Close are intact
+	if records := mustReadAuditRecords(t, fileName); len(records) != 2 {
+		t.Fatalf("expected the accept and sign records, got %+v", records)
+	}
+	if _, err := OpenAuditLog(fileName); err != nil {
+		t.Fatalf("audit log should verify after Close: %v", err)
+	}
+}
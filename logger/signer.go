@@ -0,0 +1,180 @@
+package logger
+
+import (
+	"fmt"
+	"sync"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	ba "github.com/Workiva/go-datastructures/bitarray"
+	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/certificate-transparency-go/tls"
+	mtr "github.com/n-ct/ct-monitor"
+	"github.com/n-ct/ct-monitor/signature"
+	ctca "github.com/n-ct/ct-certificate-authority"
+)
+
+//Signer creates the signatures of the logger with the algorithm it was configured for.
+//ECDSA and RSA PKCS#1 signatures are made over the same serialization as signature.Signer,
+//so they verify with signature.VerifySignature; VerifySignature verifies all of them
+type Signer interface {
+	Public() crypto.PublicKey
+	Algorithm() tls.SignatureAndHashAlgorithm
+	CreateSignature(toBeSigned interface{}) (*ct.DigitallySigned, error)
+}
+
+//a Signer backed by any crypto.Signer, software keys as well as keys held in an HSM
+type cryptoSigner struct {
+	signer		crypto.Signer
+	algorithm	tls.SignatureAndHashAlgorithm
+}
+
+//Creates a Signer from a crypto.Signer, the integration point for keys kept outside the logger, e.g. behind PKCS#11.
+//algorithm names one of the Algorithm constants, empty picks the default for the type of the key
+func NewCryptoSigner(signer crypto.Signer, algorithm string) (Signer, error) {
+	if algorithm == "" {
+		var err error
+		if algorithm, err = defaultAlgorithm(signer.Public()); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkKeyAlgorithm(algorithm, signer.Public()); err != nil {
+		return nil, err
+	}
+	return &cryptoSigner{signer, signatureAlgorithms[algorithm]}, nil
+}
+
+func (s *cryptoSigner) Public() crypto.PublicKey {
+	return s.signer.Public()
+}
+
+func (s *cryptoSigner) Algorithm() tls.SignatureAndHashAlgorithm {
+	return s.algorithm
+}
+
+func (s *cryptoSigner) CreateSignature(toBeSigned interface{}) (*ct.DigitallySigned, error) {
+	data, err := signature.SerializeData(toBeSigned)
+	if err != nil {
+		return nil, fmt.Errorf("error creating signature: %w", err)
+	}
+	var sig []byte
+	switch s.algorithm.Signature {
+	case Ed25519:
+		//Ed25519 hashes the message itself
+		sig, err = s.signer.Sign(rand.Reader, data, crypto.Hash(0))
+	default:
+		digest, hash, hashErr := signature.GenerateHash(s.algorithm.Hash, data)
+		if hashErr != nil {
+			return nil, fmt.Errorf("error creating signature: %w", hashErr)
+		}
+		var opts crypto.SignerOpts = hash
+		if s.algorithm.Signature == RSAPSS {
+			opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+		}
+		sig, err = s.signer.Sign(rand.Reader, digest, opts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating signature: %w", err)
+	}
+	return &ct.DigitallySigned{
+		Algorithm:	s.algorithm,
+		Signature:	sig,
+	}, nil
+}
+
+//returns the base64 DER public key of a signer
+func publicKeyOfSigner(signer Signer) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal public key: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(der), nil
+}
+
+//SignerProvider opens a key held outside the logger from the options of a "signer" entry in the logger config.
+//A build that links a PKCS#11 module (e.g. for SoftHSM) registers one with RegisterSignerProvider
+type SignerProvider func(options map[string]string) (crypto.Signer, error)
+
+var (
+	signerProviders		= make(map[string]SignerProvider)
+	signerProvidersMu	sync.RWMutex
+)
+
+//Makes a SignerProvider available to logger configs under name, usually called from an init function
+func RegisterSignerProvider(name string, provider SignerProvider) {
+	signerProvidersMu.Lock()
+	defer signerProvidersMu.Unlock()
+	if _, ok := signerProviders[name]; ok {
+		panic(fmt.Sprintf("signer provider %q registered twice", name))
+	}
+	signerProviders[name] = provider
+}
+
+func openProviderSigner(config *SignerConfig, algorithm string) (Signer, error) {
+	signerProvidersMu.RLock()
+	provider, ok := signerProviders[config.Provider]
+	signerProvidersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown signer provider %q", config.Provider)
+	}
+	signer, err := provider(config.Options)
+	if err != nil {
+		return nil, fmt.Errorf("signer provider %q failed: %w", config.Provider, err)
+	}
+	return NewCryptoSigner(signer, algorithm)
+}
+
+//Creates the logger signed SRDWithRevData for the CRV of a CA the same way ca.CreateSRDWithRevData does,
+//with the hashes made with the hash of the signer's algorithm
+func createLogSRDWithRevData(crv, deltaCRV *ba.BitArray, timestamp uint64, logID string, signer Signer) (*mtr.SRDWithRevData, error) {
+	hashAlgo := signer.Algorithm().Hash
+	compCRV, err := ctca.CompressCRV(crv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress crv when creating rev digest: %w", err)
+	}
+	crvHash, _, err := signature.GenerateHash(hashAlgo, compCRV)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash crv when creating rev digest: %w", err)
+	}
+	compDeltaCRV, err := ctca.CompressCRV(deltaCRV)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress deltaCRV when creating rev digest: %w", err)
+	}
+	crvDeltaHash, _, err := signature.GenerateHash(hashAlgo, compDeltaCRV)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash deltaCRV when creating rev digest: %w", err)
+	}
+	srd := &mtr.SRDWithRevData{
+		RevData: mtr.RevocationData{
+			EntityID:		logID,
+			RevocationType:	LogRevocationType,
+			Timestamp:		timestamp,
+			CRVDelta:		compDeltaCRV,
+		},
+		SRD: mtr.SignedRevocationDigest{
+			EntityID:	logID,
+			RevDigest:	mtr.RevocationDigest{
+				Timestamp:		timestamp,
+				CRVHash:		crvHash,
+				CRVDeltaHash:	crvDeltaHash,
+			},
+		},
+	}
+	sig, err := signer.CreateSignature(srd.SRD.RevDigest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign revDigest when creating srd: %v", err)
+	}
+	srd.SRD.Signature = *sig
+	return srd, nil
+}
+
+//revocation type of the SRDs the logger signs
+const LogRevocationType = "Let's-Revoke"
+
+//Verifies the signature of a logger signed SRD with the base64 DER public key of the logger.
+//The signature is over the RevDigest, as for SRDs of CAs, so ca.VerifySRDSignature accepts it too
+func VerifyLogSRD(publicKey string, srd *mtr.SRDWithRevData) error {
+	return VerifySignature(publicKey, srd.SRD.RevDigest, srd.SRD.Signature)
+}
@@ -0,0 +1,391 @@
+package logger
+
+import (
+	"fmt"
+	"bytes"
+	"sort"
+	"time"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/golang/glog"
+	ba "github.com/Workiva/go-datastructures/bitarray"
+	ct "github.com/google/certificate-transparency-go"
+	mtr "github.com/n-ct/ct-monitor"
+	el "github.com/n-ct/ct-monitor/entitylist"
+	"github.com/n-ct/ct-monitor/signature"
+	ctca "github.com/n-ct/ct-certificate-authority"
+	ca "github.com/n-ct/ct-certificate-authority/ca"
+)
+
+const (
+	GetStatePath  = "/ct/v1/get-state"
+	PostStatePath = "/ct/v1/post-state"
+)
+
+// Version of the state archive format produced by ExportState
+const StateArchiveVersion uint32 = 2
+
+// StateArchive is the signed, versioned snapshot of everything a Logger holds in memory
+type StateArchive struct {
+	State		LoggerState
+	Signature	ct.DigitallySigned // signature of the logger over State
+	KeyID		string `json:",omitempty"` // key of State.Keys that made Signature
+}
+
+// LoggerState is the signed part of a StateArchive.
+// All slices are sorted so the same logger state always serializes to the same bytes
+type LoggerState struct {
+	Version		uint32
+	LogID		string
+	CAList		*el.CAList
+	CAIDs		[]string
+	CRVs		[]CRVState
+	LogSRDs		[]LogSRDState // sorted by CA ID and revocation type
+	SRDHistory	[]*mtr.SRDWithRevData // CA signed SRDWithRevData in the order they were accepted
+	// sequence number of the first uncompacted SRD of SRDHistory and the number of compacted SRDs before it, see compactHistory
+	SRDHistoryStart		uint64 `json:",omitempty"`
+	SRDHistoryCompacted	int `json:",omitempty"`
+	// key history of the logger, LogSRDs may be signed by any of these keys. Empty in archives made before key rotation
+	Keys		[]LoggerKey `json:",omitempty"`
+	// latest record of the audit log when the archive was made, nil without an audit log. Not imported, see VerifyAuditLogHead
+	AuditHead	*AuditHead `json:",omitempty"`
+}
+
+// CRVState holds the current CRV of a CA for a single revocation type
+type CRVState struct {
+	CAID			string
+	RevocationType	string
+	CRV				[]byte // compressed CRV
+}
+
+// LogSRDState holds the current logger signed SRDWithRevData of a CA for a single revocation type.
+// The SRD names the logger, not the CA its CRV is of
+type LogSRDState struct {
+	CAID			string
+	RevocationType	string
+	SRD				*mtr.SRDWithRevData
+	KeyID			string `json:",omitempty"` // key of Keys that signed SRD
+}
+
+// Create a signed archive of the logger state
+func (this *Logger) ExportState() (*StateArchive, error) {
+	this.RLock()
+	defer this.RUnlock()
+
+	state := LoggerState{
+		Version:	StateArchiveVersion,
+		LogID:		this.LogID,
+		CAList:		this.CAList,
+		CAIDs:		append([]string{}, this.CAIDs...),
+		CRVs:		[]CRVState{},
+		LogSRDs:	[]LogSRDState{},
+		SRDHistory:	append([]*mtr.SRDWithRevData{}, this.SRDHistory...),
+		SRDHistoryStart:	this.historyStart,
+		SRDHistoryCompacted:	this.historyCompacted,
+		Keys:		append([]LoggerKey{}, this.Keys...),
+	}
+	if this.audit != nil {
+		state.AuditHead = this.audit.Head()
+	}
+	sort.Strings(state.CAIDs)
+	for caID, revTypes := range this.CurrentCRVMap {
+		for revType, crv := range revTypes {
+			compCRV, err := ctca.CompressCRV(&crv)
+			if err != nil {
+				return nil, fmt.Errorf("failed to compress CRV of CA (%v): %v", caID, err)
+			}
+			state.CRVs = append(state.CRVs, CRVState{caID, revType, compCRV})
+		}
+	}
+	sort.Slice(state.CRVs, func(i, j int) bool {
+		if state.CRVs[i].CAID != state.CRVs[j].CAID {
+			return state.CRVs[i].CAID < state.CRVs[j].CAID
+		}
+		return state.CRVs[i].RevocationType < state.CRVs[j].RevocationType
+	})
+	for caID, revTypes := range this.LogSRDWithRevDataMap {
+		for revType, srd := range revTypes {
+			state.LogSRDs = append(state.LogSRDs, LogSRDState{caID, revType, srd, this.logSRDKeyIDs[srd]})
+		}
+	}
+	sort.Slice(state.LogSRDs, func(i, j int) bool {
+		if state.LogSRDs[i].CAID != state.LogSRDs[j].CAID {
+			return state.LogSRDs[i].CAID < state.LogSRDs[j].CAID
+		}
+		return state.LogSRDs[i].RevocationType < state.LogSRDs[j].RevocationType
+	})
+
+	key, signer, err := this.signingKeyAt(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	sig, err := signer.CreateSignature(state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign logger state: %v", err)
+	}
+	return &StateArchive{State: state, Signature: *sig, KeyID: key.KeyID}, nil
+}
+
+// Check the version and signature of an archive and that its contents are consistent with each other.
+// publicKey is a trusted key of the logger, the archived key history must chain to it
+func VerifyStateArchive(archive *StateArchive, logID, publicKey string) error {
+	state := &archive.State
+	if state.Version != StateArchiveVersion {
+		return NewError(ErrorCodeInvalidRequest, nil, "unsupported state archive version %v", state.Version)
+	}
+	if state.LogID != logID {
+		return NewError(ErrorCodeInvalidRequest, nil, "state archive of logger (%v) can not be used by logger (%v)", state.LogID, logID)
+	}
+	archiveKey := publicKey
+	if len(state.Keys) > 0 {
+		anchor, err := verifyKeyChain(logID, state.Keys, publicKey)
+		if err != nil {
+			return NewError(ErrorCodeInvalidSignature, err, "invalid key history in state archive")
+		}
+		key, err := findChainedKey(state.Keys, anchor, archive.KeyID)
+		if err != nil {
+			return NewError(ErrorCodeInvalidSignature, err, "state archive is signed by an untrusted key")
+		}
+		archiveKey = key.PublicKey
+	}
+	if err := VerifySignature(archiveKey, *state, archive.Signature); err != nil {
+		return NewError(ErrorCodeInvalidSignature, err, "invalid signature on state archive")
+	}
+	if state.CAList == nil {
+		return NewError(ErrorCodeInvalidRequest, nil, "state archive has no ca list")
+	}
+	if state.SRDHistoryCompacted < 0 || state.SRDHistoryCompacted > len(state.SRDHistory) {
+		return NewError(ErrorCodeInvalidRequest, nil, "state archive has %v compacted SRDs in a history of %v", state.SRDHistoryCompacted, len(state.SRDHistory))
+	}
+
+	crvs := make(map[string] []byte)
+	for _, crvState := range state.CRVs {
+		if state.CAList.FindCAByCAID(crvState.CAID) == nil {
+			return NewError(ErrorCodeInvalidRequest, nil, "CRV of CA (%v) not found in archived ca list", crvState.CAID)
+		}
+		if _, err := ctca.DecompressCRV(crvState.CRV); err != nil {
+			return NewError(ErrorCodeInvalidCompression, err, "invalid compression on CRV of CA (%v)", crvState.CAID)
+		}
+		crvs[crvState.CAID + "/" + crvState.RevocationType] = crvState.CRV
+	}
+	//the latest CA signed SRD of every CA and revocation type, the logger SRD and CRV of each must match it
+	latest := make(map[string] *mtr.SRDWithRevData)
+	for _, srd := range state.SRDHistory {
+		latest[srd.SRD.EntityID + "/" + srd.RevData.RevocationType] = srd
+	}
+	for _, logSRD := range state.LogSRDs {
+		srd := logSRD.SRD
+		if srd == nil || srd.SRD.EntityID != logID {
+			return NewError(ErrorCodeInvalidRequest, nil, "archived SRD of CA (%v) is not signed by logger (%v)", logSRD.CAID, logID)
+		}
+		err := VerifyLogSRD(publicKey, srd)
+		if len(state.Keys) > 0 {
+			err = VerifySRDWithKeys(srd, state.Keys, logSRD.KeyID)
+		}
+		if err != nil {
+			return NewError(ErrorCodeInvalidSignature, err, "invalid signature on archived SRD of CA (%v)", logSRD.CAID)
+		}
+		key := logSRD.CAID + "/" + logSRD.RevocationType
+		crv, ok := crvs[key]
+		caSRD := latest[key]
+		if !ok || caSRD == nil {
+			return NewError(ErrorCodeInvalidRequest, nil, "archived SRD of CA (%v) does not match the archived CRV", logSRD.CAID)
+		}
+		//the CA hashes the CRV after the delta with the hash of its signature, the logger SRD signs the CRV before it
+		crvHash, _, err := signature.GenerateHash(caSRD.SRD.Signature.Algorithm.Hash, crv)
+		if err != nil {
+			return NewError(ErrorCodeInternal, err, "failed to hash CRV of CA (%v)", logSRD.CAID)
+		}
+		if !bytes.Equal(crvHash, caSRD.SRD.RevDigest.CRVHash) || srd.SRD.RevDigest.Timestamp != caSRD.SRD.RevDigest.Timestamp ||
+			!bytes.Equal(srd.RevData.CRVDelta, caSRD.RevData.CRVDelta) {
+			return NewError(ErrorCodeInvalidRequest, nil, "archived SRD of CA (%v) does not match the archived CRV", logSRD.CAID)
+		}
+	}
+	//a follower of the importing logger replays the history, each entry must be signed by its CA
+	for i, srd := range state.SRDHistory {
+		caInfo := state.CAList.FindCAByCAID(srd.SRD.EntityID)
+		if caInfo == nil {
+			return NewError(ErrorCodeInvalidRequest, nil, "SRD %v of the archived history is of CA (%v), not found in archived ca list", i, srd.SRD.EntityID)
+		}
+		if err := ca.VerifySRDSignature(&srd.SRD, caInfo.CAKey); err != nil {
+			return NewError(ErrorCodeInvalidSignature, err, "invalid signature on SRD %v of the archived history of CA (%v)", i, srd.SRD.EntityID)
+		}
+	}
+	return nil
+}
+
+// Verify the archive and replace the state of the logger with it.
+// Only a logger that has not accepted any SRD yet can import a state, so a live logger can never be rolled back.
+// The logger keeps its configured ca list and ca_ids, archived state of CAs it no longer allows is dropped
+func (this *Logger) ImportState(archive *StateArchive) error {
+	this.RLock()
+	publicKey := this.PublicKey //replaced by Reload
+	this.RUnlock()
+	if err := VerifyStateArchive(archive, this.LogID, publicKey); err != nil {
+		return err
+	}
+	state := &archive.State
+	crvMap := make(map[string] map[string] ba.BitArray)
+	for _, crvState := range state.CRVs {
+		crv, err := ctca.DecompressCRV(crvState.CRV)
+		if err != nil {
+			return NewError(ErrorCodeInvalidCompression, err, "invalid compression on CRV of CA (%v)", crvState.CAID)
+		}
+		if crvMap[crvState.CAID] == nil {
+			crvMap[crvState.CAID] = make(map[string] ba.BitArray)
+		}
+		crvMap[crvState.CAID][crvState.RevocationType] = *crv
+	}
+	srdMap := make(map[string] map[string] *mtr.SRDWithRevData)
+	keyIDs := make(map[*mtr.SRDWithRevData] string)
+	for _, logSRD := range state.LogSRDs {
+		srd := logSRD.SRD
+		if srdMap[logSRD.CAID] == nil {
+			srdMap[logSRD.CAID] = make(map[string] *mtr.SRDWithRevData)
+		}
+		srdMap[logSRD.CAID][logSRD.RevocationType] = srd
+		keyID, err := KeyIDFromPublicKey(publicKey)
+		if len(state.Keys) > 0 {
+			var key *LoggerKey
+			if key, err = signingKeyOfSRD(srd, state.Keys, logSRD.KeyID); err == nil {
+				keyID = key.KeyID
+			}
+		}
+		if err != nil {
+			return NewError(ErrorCodeInvalidSignature, err, "unknown signing key of archived SRD of CA (%v)", logSRD.CAID)
+		}
+		keyIDs[srd] = keyID
+	}
+
+	this.Lock()
+	defer this.Unlock()
+	if len(this.CurrentCRVMap) > 0 || len(this.SRDHistory) > 0 {
+		return NewError(ErrorCodeConflict, nil, "logger already holds state, refusing to import")
+	}
+	allowed := func(caID string) bool {
+		return this.allowsCA(caID) && this.CAList.FindCAByCAID(caID) != nil
+	}
+	for caID := range crvMap {
+		if !allowed(caID) {
+			glog.Infof("logger (%v) drops the archived CRVs of removed CA (%v)", this.LogID, caID)
+			delete(crvMap, caID)
+		}
+	}
+	for caID, revTypes := range srdMap {
+		if !allowed(caID) {
+			for _, srd := range revTypes {
+				delete(keyIDs, srd)
+			}
+			delete(srdMap, caID)
+		}
+	}
+	history := []*mtr.SRDWithRevData{}
+	historyStart, historyCompacted := state.SRDHistoryStart, state.SRDHistoryCompacted
+	droppedUncompacted := false
+	for i, srd := range state.SRDHistory {
+		if allowed(srd.SRD.EntityID) {
+			history = append(history, srd)
+		} else if i < state.SRDHistoryCompacted {
+			historyCompacted--
+		} else {
+			droppedUncompacted = true
+		}
+	}
+	//followers of this logger must not be sent a history with gaps, it is served from the end of the archived one on
+	if droppedUncompacted {
+		historyStart += uint64(len(state.SRDHistory) - state.SRDHistoryCompacted)
+		historyCompacted = len(history)
+	}
+	this.CurrentCRVMap = crvMap
+	this.LogSRDWithRevDataMap = srdMap
+	this.logSRDKeyIDs = keyIDs
+	if len(srdMap) == 0 {
+		this.LogSRDWithRevDataMap = nil //keep reporting that SRDs are still being created
+	}
+	this.SRDHistory = history
+	this.historyStart = historyStart
+	this.historyCompacted = historyCompacted
+	this.statePending = false
+	this.readySince = time.Now()
+	return nil
+}
+
+// Write a signed archive of the logger state to a file
+func (this *Logger) ExportStateToFile(fileName string) error {
+	archive, err := this.ExportState()
+	if err != nil {
+		return err
+	}
+	jsonBytes, err := json.Marshal(archive)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state archive: %v", err)
+	}
+	if err := ioutil.WriteFile(fileName, jsonBytes, 0600); err != nil {
+		return fmt.Errorf("failed to write state archive: %v", err)
+	}
+	return nil
+}
+
+// Read a state archive from a file and import it into the logger
+func (this *Logger) ImportStateFromFile(fileName string) error {
+	byteData, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return fmt.Errorf("error reading state archive: %v", err)
+	}
+	var archive StateArchive
+	if err := json.Unmarshal(byteData, &archive); err != nil {
+		return fmt.Errorf("failed to unmarshal state archive: %v", err)
+	}
+	return this.ImportState(&archive)
+}
+
+func (this *Logger) OnGetState(res http.ResponseWriter, req *http.Request) {
+	glog.Infof("new GetState request received")
+	//the archive holds the whole SRD history, it is not served to anyone who asks
+	if err := this.authenticateOperator(req, nil); err != nil {
+		logRejectedOperator(req, err)
+		WriteError(res, NewError(ErrorCodeUnauthorized, err, "Unauthorized"))
+		return
+	}
+	archive, err := this.ExportState()
+	if err != nil {
+		WriteError(res, fmt.Errorf("failed to export state: %w", err))
+		return
+	}
+	jsonBytes, err := json.Marshal(archive)
+	if err != nil {
+		WriteError(res, NewError(ErrorCodeInternal, err, "failed to marshal state archive"))
+		return
+	}
+	res.Write(jsonBytes)
+}
+
+func (this *Logger) OnPostState(res http.ResponseWriter, req *http.Request) {
+	glog.Infof("new PostState request received")
+	body, err := ioutil.ReadAll(req.Body) //keep the raw body, request signatures are made over it
+	if err != nil {
+		WriteError(res, NewError(ErrorCodeInvalidRequest, err, "Invalid data sent via post"))
+		return
+	}
+	if err := this.authenticateOperator(req, body); err != nil {
+		logRejectedOperator(req, err)
+		WriteError(res, NewError(ErrorCodeUnauthorized, err, "Unauthorized"))
+		return
+	}
+	//the archive given with -state is being imported, a second one must not race it
+	if !this.IsReady() {
+		WriteError(res, NewError(ErrorCodeConflict, nil, "logger is importing its state archive, refusing to import another"))
+		return
+	}
+	archive := StateArchive{}
+	if err := json.Unmarshal(body, &archive); err != nil {
+		WriteError(res, NewError(ErrorCodeInvalidRequest, err, "Invalid data sent via post"))
+		return
+	}
+	if err := this.ImportState(&archive); err != nil {
+		WriteError(res, fmt.Errorf("Unable to import state: %w", err))
+		return
+	}
+}
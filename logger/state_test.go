@@ -0,0 +1,131 @@
+package logger
+
+import (
+	"testing"
+	"errors"
+	"time"
+	"encoding/json"
+	"bytes"
+	"path/filepath"
+	ctca "github.com/n-ct/ct-certificate-authority"
+)
+
+//function that returns a logger which accepted two MMDs of revocations
+func mustCreateLoggerWithState(t *testing.T) *Logger {
+	t.Helper()
+	logger, err := mustCreateLogger(t)
+	if err != nil {
+		t.Fatalf("failed to create Logger with config @ (%s): %v", config_filename, err)
+	}
+	err = mustUpdateLogSRDWithRevData(t, logger, ctca.CreateCRV([]uint64{1,3}, 0), ctca.GetCRVDelta([]uint64{1,3}))
+	if err != nil {
+		t.Fatalf("logger not updated correctly 1: %v", err)
+	}
+	err = mustUpdateLogSRDWithRevData(t, logger, ctca.CreateCRV([]uint64{1,3,4,5,7}, 0), ctca.GetCRVDelta([]uint64{4,5,7}))
+	if err != nil {
+		t.Fatalf("logger not updated correctly 2: %v", err)
+	}
+	return logger
+}
+
+func TestExportImportState(t *testing.T) {
+	logger := mustCreateLoggerWithState(t)
+	fileName := filepath.Join(t.TempDir(), "state.json")
+	if err := logger.ExportStateToFile(fileName); err != nil {
+		t.Fatalf("failed to export state: %v", err)
+	}
+
+	standby, _ := mustCreateLogger(t)
+	if err := standby.ImportStateFromFile(fileName); err != nil {
+		t.Fatalf("failed to import state: %v", err)
+	}
+	if len(standby.SRDHistory) != 2 {
+		t.Fatalf("imported SRD history should be of size 2 not %v", len(standby.SRDHistory))
+	}
+
+	//the same state must always serialize to the same bytes
+	exported, _ := logger.ExportState()
+	reExported, err := standby.ExportState()
+	if err != nil {
+		t.Fatalf("failed to export imported state: %v", err)
+	}
+	stateBytes, _ := json.Marshal(exported.State)
+	reStateBytes, _ := json.Marshal(reExported.State)
+	if !bytes.Equal(stateBytes, reStateBytes) {
+		t.Fatalf("imported state does not match the exported state")
+	}
+
+	//the standby must continue from the imported CRV
+	err = mustUpdateLogSRDWithRevData(t, standby, ctca.CreateCRV([]uint64{1,3,4,5,7,8}, 0), ctca.GetCRVDelta([]uint64{8}))
+	if err != nil {
+		t.Fatalf("standby not updated correctly: %v", err)
+	}
+}
+
+func TestImportStateRejectsTamperedArchive(t *testing.T) {
+	logger := mustCreateLoggerWithState(t)
+	archive, err := logger.ExportState()
+	if err != nil {
+		t.Fatalf("failed to export state: %v", err)
+	}
+	archive.State.CRVs[0].CRV, _ = ctca.CompressCRV(ctca.CreateCRV([]uint64{1}, 0))
+
+	standby, _ := mustCreateLogger(t)
+	if err := standby.ImportState(archive); err == nil {
+		t.Fatalf("tampered archive was imported")
+	}
+}
+
+func TestImportStateRejectsLoggerWithState(t *testing.T) {
+	logger := mustCreateLoggerWithState(t)
+	archive, err := logger.ExportState()
+	if err != nil {
+		t.Fatalf("failed to export state: %v", err)
+	}
+	if err := logger.ImportState(archive); err == nil {
+		t.Fatalf("archive was imported into a logger that already holds state")
+	}
+}
+
+func TestImportStateKeepsConfiguredCAs(t *testing.T) {
+	logger := mustCreateLoggerWithState(t)
+	archive, err := logger.ExportState()
+	if err != nil {
+		t.Fatalf("failed to export state: %v", err)
+	}
+
+	//the standby no longer allows the only CA of the archive
+	standby, _ := mustCreateLogger(t)
+	caList := standby.CAList
+	standby.CAIDs = []string{}
+	if err := standby.ImportState(archive); err != nil {
+		t.Fatalf("failed to import state: %v", err)
+	}
+	if standby.CAList != caList || len(standby.CAIDs) != 0 {
+		t.Fatalf("import should keep the configured ca list and ca_ids, got %v", standby.CAIDs)
+	}
+	if len(standby.CurrentCRVMap) != 0 || standby.LogSRDWithRevDataMap != nil || len(standby.SRDHistory) != 0 {
+		t.Fatalf("archived state of a CA that is not allowed should be dropped")
+	}
+}
+
+func TestImportStateRejectsForgedHistory(t *testing.T) {
+	logger := mustCreateLoggerWithState(t)
+	archive, err := logger.ExportState()
+	if err != nil {
+		t.Fatalf("failed to export state: %v", err)
+	}
+	//an SRD the CA never signed, in an archive the logger did sign
+	archive.State.SRDHistory[0].SRD.RevDigest.Timestamp++
+	_, signer, _ := logger.signingKeyAt(time.Now())
+	sig, err := signer.CreateSignature(archive.State)
+	if err != nil {
+		t.Fatalf("failed to sign archive: %v", err)
+	}
+	archive.Signature = *sig
+
+	standby, _ := mustCreateLogger(t)
+	if err := standby.ImportState(archive); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("archive with a forged SRD in its history should be rejected, got %v", err)
+	}
+}
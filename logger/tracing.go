@@ -0,0 +1,400 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"github.com/golang/glog"
+)
+
+//header carrying the W3C trace context, see https://www.w3.org/TR/trace-context/
+const TraceparentHeader = "traceparent"
+
+//kinds of a span, numbered as in OTLP
+type SpanKind int
+
+const (
+	SpanKindInternal	SpanKind = 1
+	SpanKindServer		SpanKind = 2 //a request served by the logger
+	SpanKindClient		SpanKind = 3 //a request the logger sends, e.g. to a CA
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+//identifies a span within its trace, propagated to other services in the traceparent header
+type SpanContext struct {
+	TraceID	TraceID
+	SpanID	SpanID
+	Sampled	bool //spans that are not sampled are not exported
+}
+
+func (c SpanContext) isValid() bool {
+	return c.TraceID != TraceID{} && c.SpanID != SpanID{}
+}
+
+//formats the span context as a traceparent header value
+func (c SpanContext) Traceparent() string {
+	flags := "00"
+	if c.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%x-%x-%v", c.TraceID[:], c.SpanID[:], flags)
+}
+
+//parses a traceparent header value, only version 00 is understood
+func ParseTraceparent(traceparent string) (SpanContext, error) {
+	var c SpanContext
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return c, fmt.Errorf("malformed traceparent %q", traceparent)
+	}
+	traceID, err1 := hex.DecodeString(parts[1])
+	spanID, err2 := hex.DecodeString(parts[2])
+	flags, err3 := hex.DecodeString(parts[3])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return c, fmt.Errorf("malformed traceparent %q", traceparent)
+	}
+	copy(c.TraceID[:], traceID)
+	copy(c.SpanID[:], spanID)
+	c.Sampled = flags[0] & 1 == 1
+	if !c.isValid() {
+		return c, fmt.Errorf("traceparent %q has a zero trace or span ID", traceparent)
+	}
+	return c, nil
+}
+
+//a timed operation of a trace. All methods do nothing on a nil Span, which is what a Logger without a tracer starts
+type Span struct {
+	Name		string
+	Kind		SpanKind
+	Context		SpanContext
+	Parent		SpanID //zero for the root span of a trace
+	Start		time.Time
+	End			time.Time
+	Attributes	map[string] string
+	Err			string //set by SetError, the span failed when not empty
+	tracer		*Tracer
+	mu			sync.Mutex
+	ended		bool
+}
+
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Attributes[key] = value
+}
+
+//marks the span as failed with err, a nil err leaves it as it is
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Err = err.Error()
+}
+
+//ends the span and hands it to the exporter of its tracer, only the first call counts
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+	if s.Context.Sampled {
+		s.tracer.exporter.ExportSpan(s)
+	}
+}
+
+//receives the spans of a tracer as they end
+type SpanExporter interface {
+	ExportSpan(span *Span)
+}
+
+//creates the spans of the loggers and hands them to an exporter
+type Tracer struct {
+	exporter	SpanExporter
+}
+
+func NewTracer(exporter SpanExporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+type spanContextKey struct{}
+
+//returns ctx carrying the span context of a caller, spans started from it belong to the trace of the caller
+func ContextWithRemoteSpanContext(ctx context.Context, remote SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, remote)
+}
+
+//returns the span context of the current span in ctx
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	c, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return c, ok
+}
+
+//returns the context of req with the trace context of its traceparent header, if it has a valid one
+func extractTraceContext(req *http.Request) context.Context {
+	remote, err := ParseTraceparent(req.Header.Get(TraceparentHeader))
+	if err != nil {
+		return req.Context()
+	}
+	return ContextWithRemoteSpanContext(req.Context(), remote)
+}
+
+//sets the traceparent header of an outgoing request to the current span in ctx
+func InjectTraceContext(ctx context.Context, header http.Header) {
+	if c, ok := SpanContextFromContext(ctx); ok && c.isValid() {
+		header.Set(TraceparentHeader, c.Traceparent())
+	}
+}
+
+//starts a span as child of the current span in ctx, or of a new trace. Returns ctx with the span as current span.
+//A nil tracer starts no span and returns ctx and a nil Span
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	span := &Span{Name: name, Kind: kind, Start: time.Now(), Attributes: make(map[string] string), tracer: t}
+	if parent, ok := SpanContextFromContext(ctx); ok && parent.isValid() {
+		span.Context.TraceID = parent.TraceID
+		span.Context.Sampled = parent.Sampled
+		span.Parent = parent.SpanID
+	} else {
+		rand.Read(span.Context.TraceID[:])
+		span.Context.Sampled = true
+	}
+	rand.Read(span.Context.SpanID[:])
+	return ContextWithRemoteSpanContext(ctx, span.Context), span
+}
+
+//Sets the tracer the logger starts its spans with, before the logger is served. A nil tracer turns tracing off
+func (this *Logger) SetTracer(tracer *Tracer) {
+	this.tracer = tracer
+}
+
+//starts a span of the logger, see Tracer.Start
+func (this *Logger) startSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	ctx, span := this.tracer.Start(ctx, name, kind)
+	span.SetAttribute("log_id", this.LogID)
+	return ctx, span
+}
+
+//keeps the spans in memory, for tests
+type InMemoryExporter struct {
+	mu		sync.Mutex
+	spans	[]*Span
+}
+
+func (e *InMemoryExporter) ExportSpan(span *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+//returns the spans exported so far in the order they ended
+func (e *InMemoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*Span{}, e.spans...)
+}
+
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+//how OTLPExporter batches spans
+const (
+	otlpBatchSize		= 512
+	otlpFlushInterval	= 5 * time.Second
+	otlpMaxQueue		= 4096 //spans beyond this are dropped while the collector is unreachable
+)
+
+//sends spans to an OpenTelemetry collector with OTLP over HTTP in the JSON encoding, in batches
+type OTLPExporter struct {
+	Endpoint	string //URL of the collector, spans are posted to Endpoint/v1/traces
+	ServiceName	string
+	HTTPClient	*http.Client
+	mu			sync.Mutex
+	queue		[]*Span
+	flush		chan struct{}
+	done		chan struct{}
+	stopped		chan struct{}
+	closeOnce	sync.Once
+}
+
+//creates an OTLPExporter for the collector at endpoint, e.g. http://localhost:4318, and starts sending batches
+func NewOTLPExporter(endpoint, serviceName string) *OTLPExporter {
+	e := &OTLPExporter{
+		Endpoint:		strings.TrimSuffix(endpoint, "/"),
+		ServiceName:	serviceName,
+		HTTPClient:		&http.Client{Timeout: 10 * time.Second},
+		flush:			make(chan struct{}, 1),
+		done:			make(chan struct{}),
+		stopped:		make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *OTLPExporter) ExportSpan(span *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.queue) >= otlpMaxQueue {
+		return
+	}
+	e.queue = append(e.queue, span)
+	if len(e.queue) >= otlpBatchSize {
+		select {
+		case e.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *OTLPExporter) run() {
+	defer close(e.stopped)
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.flush:
+		case <-e.done:
+			return
+		}
+		if err := e.Flush(context.Background()); err != nil {
+			glog.Warningf("failed to export spans to %v: %v", e.Endpoint, err)
+		}
+	}
+}
+
+//sends the queued spans to the collector
+func (e *OTLPExporter) Flush(ctx context.Context) error {
+	for {
+		e.mu.Lock()
+		batch := e.queue
+		if len(batch) > otlpBatchSize {
+			batch = batch[:otlpBatchSize]
+		}
+		e.queue = e.queue[len(batch):]
+		e.mu.Unlock()
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := e.send(ctx, batch); err != nil {
+			return err
+		}
+	}
+}
+
+//stops the batching and sends the spans still queued
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	e.closeOnce.Do(func() { close(e.done) })
+	select {
+	case <-e.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return e.Flush(ctx)
+}
+
+func (e *OTLPExporter) send(ctx context.Context, spans []*Span) error {
+	body, err := json.Marshal(otlpRequest(e.ServiceName, spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint + "/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := e.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("collector responded with %v", res.Status)
+	}
+	return nil
+}
+
+//the ExportTraceServiceRequest of OTLP in its JSON encoding, IDs are hex and times nanoseconds as strings
+type otlpKeyValue struct {
+	Key		string				`json:"key"`
+	Value	map[string] string	`json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID				string			`json:"traceId"`
+	SpanID				string			`json:"spanId"`
+	ParentSpanID		string			`json:"parentSpanId,omitempty"`
+	Name				string			`json:"name"`
+	Kind				SpanKind		`json:"kind"`
+	StartTimeUnixNano	string			`json:"startTimeUnixNano"`
+	EndTimeUnixNano		string			`json:"endTimeUnixNano"`
+	Attributes			[]otlpKeyValue	`json:"attributes,omitempty"`
+	Status				map[string] interface{}	`json:"status,omitempty"`
+}
+
+func otlpAttributes(attributes map[string] string) []otlpKeyValue {
+	var keyValues []otlpKeyValue
+	for key, value := range attributes {
+		keyValues = append(keyValues, otlpKeyValue{key, map[string] string{"stringValue": value}})
+	}
+	return keyValues
+}
+
+func otlpRequest(serviceName string, spans []*Span) interface{} {
+	otlpSpans := make([]otlpSpan, len(spans))
+	for i, span := range spans {
+		span.mu.Lock()
+		otlpSpans[i] = otlpSpan{
+			TraceID:			hex.EncodeToString(span.Context.TraceID[:]),
+			SpanID:				hex.EncodeToString(span.Context.SpanID[:]),
+			Name:				span.Name,
+			Kind:				span.Kind,
+			StartTimeUnixNano:	fmt.Sprint(span.Start.UnixNano()),
+			EndTimeUnixNano:	fmt.Sprint(span.End.UnixNano()),
+			Attributes:			otlpAttributes(span.Attributes),
+		}
+		if span.Parent != (SpanID{}) {
+			otlpSpans[i].ParentSpanID = hex.EncodeToString(span.Parent[:])
+		}
+		if span.Err != "" {
+			otlpSpans[i].Status = map[string] interface{}{"code": 2, "message": span.Err} //STATUS_CODE_ERROR
+		}
+		span.mu.Unlock()
+	}
+	return map[string] interface{}{
+		"resourceSpans": []interface{}{map[string] interface{}{
+			"resource": map[string] interface{}{
+				"attributes": otlpAttributes(map[string] string{"service.name": serviceName}),
+			},
+			"scopeSpans": []interface{}{map[string] interface{}{
+				"scope":	map[string] string{"name": "github.com/n-ct/ct-logger"},
+				"spans":	otlpSpans,
+			}},
+		}},
+	}
+}
@@ -0,0 +1,137 @@
+package logger
+
+import (
+	"testing"
+	"bytes"
+	"context"
+	"errors"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+)
+
+func TestTraceparent(t *testing.T) {
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	c, err := ParseTraceparent(traceparent)
+	if err != nil {
+		t.Fatalf("failed to parse traceparent: %v", err)
+	}
+	if !c.Sampled || hex.EncodeToString(c.TraceID[:]) != "4bf92f3577b34da6a3ce929d0e0e4736" || c.Traceparent() != traceparent {
+		t.Fatalf("traceparent did not round trip, got %+v", c)
+	}
+	for _, invalid := range []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+	} {
+		if _, err := ParseTraceparent(invalid); err == nil {
+			t.Fatalf("traceparent %q should not parse", invalid)
+		}
+	}
+}
+
+//returns the span named name, failing the test if there is none
+func mustFindSpan(t *testing.T, spans []*Span, name string) *Span {
+	t.Helper()
+	for _, span := range spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	t.Fatalf("no span %v in %v spans", name, len(spans))
+	return nil
+}
+
+func TestRevokeAndProduceSRDSpans(t *testing.T) {
+	logger, _ := mustCreateLogger(t)
+	exporter := &InMemoryExporter{}
+	logger.SetTracer(NewTracer(exporter))
+	var caTraceparent string
+	caServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		caTraceparent = req.Header.Get(TraceparentHeader)
+		res.Write(mustCreateCASRDBody(t))
+	}))
+	defer caServer.Close()
+	logger.CAList.FindCAByCAID(ca_id).CAURL = caServer.URL
+	serveMux, err := NewServeMux([]*Logger{logger}, nil)
+	if err != nil {
+		t.Fatalf("failed to create serve mux: %v", err)
+	}
+	server := httptest.NewServer(serveMux)
+	defer server.Close()
+
+	caller, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req, _ := http.NewRequest("POST", server.URL + RevokeAndProduceSRDPath, bytes.NewReader([]byte(`{"percent_revoked": 10, "total_certs": 100}`)))
+	req.Header.Set(TraceparentHeader, caller.Traceparent())
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to send revoke request: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("revoke request failed with %v", res.Status)
+	}
+
+	spans := exporter.Spans()
+	root := mustFindSpan(t, spans, RevokeAndProduceSRDPath)
+	if root.Kind != SpanKindServer || root.Parent != caller.SpanID || root.Attributes["http.status_code"] != "200" {
+		t.Fatalf("request span should continue the trace of the caller, got %+v", root)
+	}
+	for _, name := range []string{"decode", "forward", "verify-ca-signature", "decompress", "hash", "sign"} {
+		span := mustFindSpan(t, spans, name)
+		if span.Context.TraceID != caller.TraceID || span.Parent != root.Context.SpanID || span.Err != "" {
+			t.Fatalf("span %v should be a child of the request span, got %+v", name, span)
+		}
+		if span.End.Before(span.Start) {
+			t.Fatalf("span %v ends before it starts", name)
+		}
+	}
+	forward := mustFindSpan(t, spans, "forward")
+	if forward.Kind != SpanKindClient || forward.Attributes["ca_id"] != ca_id {
+		t.Fatalf("forward span should be a client span naming the CA, got %+v", forward)
+	}
+	if caTraceparent != forward.Context.Traceparent() {
+		t.Fatalf("CA should get the trace context of the forward span %v, got %q", forward.Context.Traceparent(), caTraceparent)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var received map[string] []struct {
+		ScopeSpans []struct {
+			Spans []otlpSpan `json:"spans"`
+		} `json:"scopeSpans"`
+	}
+	collector := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/traces" || req.Header.Get("Content-Type") != "application/json" {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewDecoder(req.Body).Decode(&received)
+	}))
+	defer collector.Close()
+
+	exporter := NewOTLPExporter(collector.URL, "ct-logger-test")
+	tracer := NewTracer(exporter)
+	ctx, parent := tracer.Start(context.Background(), "parent", SpanKindServer)
+	_, child := tracer.Start(ctx, "child", SpanKindInternal)
+	child.SetError(errors.New("failed"))
+	child.Finish()
+	parent.Finish()
+	if err := exporter.Shutdown(context.Background()); err != nil {
+		t.Fatalf("failed to shut down exporter: %v", err)
+	}
+
+	resourceSpans := received["resourceSpans"]
+	if len(resourceSpans) != 1 || len(resourceSpans[0].ScopeSpans) != 1 || len(resourceSpans[0].ScopeSpans[0].Spans) != 2 {
+		t.Fatalf("collector should receive both spans, got %+v", received)
+	}
+	exported := resourceSpans[0].ScopeSpans[0].Spans[0]
+	if exported.Name != "child" || exported.TraceID != hex.EncodeToString(parent.Context.TraceID[:]) ||
+		exported.ParentSpanID != hex.EncodeToString(parent.Context.SpanID[:]) || exported.Status["message"] != "failed" {
+		t.Fatalf("exported span does not match, got %+v", exported)
+	}
+}
@@ -0,0 +1,122 @@
+package logger
+
+import (
+	"io"
+	"bytes"
+	"math"
+	"mime"
+	"time"
+	"io/ioutil"
+	"net/http"
+	"github.com/google/certificate-transparency-go/tls"
+	mtr "github.com/n-ct/ct-monitor"
+)
+
+// Limits of the requests the logger serves
+const (
+	MaxSRDBodySize		= 1 << 20 //bytes of a posted SRDWithRevData or revoke request, and of the SRD a CA answers with
+	MaxStateBodySize	= 64 << 20 //bytes of a posted state archive, which holds the CRVs of every CA
+	// How far the timestamp of an SRD may be ahead of the logger clock, or behind the MMD of its CA, unless max_timestamp_skew is set
+	DefaultMaxTimestampSkew	= 5 * time.Minute
+)
+
+//sizes of the CRV hashes for the hashes CAs may sign with
+var crvHashSizes = map[tls.HashAlgorithm] int{
+	tls.SHA256:	32,
+	tls.SHA384:	48,
+	tls.SHA512:	64,
+}
+
+//wraps handler so it only serves method. Requests with a body must be JSON of at most maxBodySize bytes,
+//the body is read here so handlers never see more than that
+func validateRequest(method string, maxBodySize int64, handler http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != method && !(method == http.MethodGet && req.Method == http.MethodHead) {
+			res.Header().Set("Allow", method)
+			WriteError(res, NewError(ErrorCodeMethodNotAllowed, nil, "method %v is not allowed, use %v", req.Method, method))
+			return
+		}
+		if method != http.MethodPost {
+			handler(res, req)
+			return
+		}
+		mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			WriteError(res, NewError(ErrorCodeUnsupportedMediaType, nil, "Content-Type must be application/json, got %q", req.Header.Get("Content-Type")))
+			return
+		}
+		if req.ContentLength > maxBodySize {
+			WriteError(res, NewError(ErrorCodeTooLarge, nil, "request body of %v bytes is larger than %v bytes", req.ContentLength, maxBodySize))
+			return
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxBodySize + 1))
+		if err != nil {
+			WriteError(res, NewError(ErrorCodeInvalidRequest, err, "failed to read request body"))
+			return
+		}
+		if int64(len(body)) > maxBodySize {
+			WriteError(res, NewError(ErrorCodeTooLarge, nil, "request body is larger than %v bytes", maxBodySize))
+			return
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		handler(res, req)
+	}
+}
+
+// Checks the structure of a CA signed SRDWithRevData before any of its signatures or hashes are computed:
+// the CA IDs are set and agree, the CRV hashes have the size of the signature hash and the timestamp is a time.
+// Whether the logger signs for the timestamp is checked against its temporal interval and keys later
+func ValidateSRDWithRevData(data *mtr.SRDWithRevData) error {
+	srd := &data.SRD
+	revData := &data.RevData
+	caID := srd.EntityID
+	invalid := func(format string, args ...interface{}) error {
+		return NewError(ErrorCodeInvalidRequest, nil, format, args...).With("ca_id", caID)
+	}
+	if caID == "" {
+		return invalid("SRD has no EntityID")
+	}
+	if revData.EntityID != caID {
+		return invalid("RevData of (%v) does not belong to the SRD of (%v)", revData.EntityID, caID)
+	}
+	if revData.RevocationType == "" {
+		return invalid("RevData has no RevocationType")
+	}
+	if len(revData.CRVDelta) == 0 {
+		return invalid("RevData has no CRVDelta")
+	}
+	hashSize, ok := crvHashSizes[srd.Signature.Algorithm.Hash]
+	if !ok {
+		return invalid("unsupported SRD hash algorithm %v", srd.Signature.Algorithm.Hash)
+	}
+	if len(srd.RevDigest.CRVHash) != hashSize || len(srd.RevDigest.CRVDeltaHash) != hashSize {
+		return invalid("CRV hashes of %v and %v bytes do not match the %v bytes of %v",
+			len(srd.RevDigest.CRVHash), len(srd.RevDigest.CRVDeltaHash), hashSize, srd.Signature.Algorithm.Hash)
+	}
+	if len(srd.Signature.Signature) == 0 {
+		return invalid("SRD has no signature")
+	}
+	timestamp := srd.RevDigest.Timestamp
+	if timestamp == 0 || timestamp > math.MaxInt64 {
+		return invalid("SRD timestamp %v is out of range", timestamp)
+	}
+	return nil
+}
+
+// Checks that the timestamp of an SRD is fresh by the clock of the logger: it may not be more than maxSkew ahead of now,
+// nor older than the MMD of the CA (mmd seconds, unchecked when 0) plus maxSkew
+func ValidateSRDTimestamp(timestamp uint64, mmd uint64, now time.Time, maxSkew time.Duration) error {
+	t := time.Unix(int64(timestamp), 0)
+	if latest := now.Add(maxSkew); t.After(latest) {
+		return NewError(ErrorCodeTimestampOutOfRange, nil, "SRD timestamp %v is more than %v ahead of the logger clock at %v",
+			t.UTC().Format(time.RFC3339), maxSkew, now.UTC().Format(time.RFC3339))
+	}
+	if mmd == 0 {
+		return nil
+	}
+	if oldest := now.Add(-time.Duration(mmd) * time.Second - maxSkew); t.Before(oldest) {
+		return NewError(ErrorCodeTimestampOutOfRange, nil, "SRD timestamp %v is older than the MMD of %vs at %v",
+			t.UTC().Format(time.RFC3339), mmd, now.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
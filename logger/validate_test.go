@@ -0,0 +1,147 @@
+package logger
+
+import (
+	"testing"
+	"bytes"
+	"errors"
+	"time"
+	"net/http"
+	"net/http/httptest"
+	mtr "github.com/n-ct/ct-monitor"
+	"github.com/n-ct/ct-logger/internal/testutil"
+)
+
+func TestValidateRequest(t *testing.T) {
+	served := 0
+	handler := validateRequest(http.MethodPost, 16, func(res http.ResponseWriter, req *http.Request) {
+		served++
+	})
+	tests := []struct {
+		method		string
+		contentType	string
+		body		string
+		status		int
+	}{
+		{"GET", "", "", http.StatusMethodNotAllowed},
+		{"POST", "text/plain", "{}", http.StatusUnsupportedMediaType},
+		{"POST", "", "{}", http.StatusUnsupportedMediaType},
+		{"POST", "application/json", "{\"too\": \"large body\"}", http.StatusRequestEntityTooLarge},
+		{"POST", "application/json; charset=utf-8", "{}", http.StatusOK},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, PostLogSRDWithRevDataPath, bytes.NewReader([]byte(test.body)))
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		res := httptest.NewRecorder()
+		handler(res, req)
+		if res.Code != test.status {
+			t.Fatalf("%v %q with %v bytes: expected %v, got %v", test.method, test.contentType, len(test.body), test.status, res.Code)
+		}
+	}
+	if served != 1 {
+		t.Fatalf("only the valid request should be served, %v were", served)
+	}
+
+	//a body without a Content-Length is cut off at the limit as well
+	req := httptest.NewRequest("POST", PostLogSRDWithRevDataPath, bytes.NewReader(make([]byte, 17)))
+	req.Header.Set("Content-Type", "application/json")
+	req.ContentLength = -1
+	res := httptest.NewRecorder()
+	handler(res, req)
+	if res.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("body without Content-Length beyond the limit should be rejected, got %v", res.Code)
+	}
+}
+
+func TestServeMuxEnforcesMethods(t *testing.T) {
+	_, _, server := mustServeTwoLoggers(t)
+	url := server.URL + "/logs/argon2020"
+	if code := mustGetStatus(t, "GET", url + PostLogSRDWithRevDataPath, nil); code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET of the post endpoint should not be allowed, got %v", code)
+	}
+	if code := mustGetStatus(t, "POST", url + GetKeysPath, []byte("{}")); code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST to a get endpoint should not be allowed, got %v", code)
+	}
+	if code := mustGetStatus(t, "HEAD", url + GetKeysPath, nil); code != http.StatusOK {
+		t.Fatalf("HEAD of a get endpoint should be allowed, got %v", code)
+	}
+}
+
+func TestValidateSRDWithRevData(t *testing.T) {
+	tests := []struct {
+		name	string
+		modify	func(*mtr.SRDWithRevData)
+	}{
+		{"no EntityID", func(data *mtr.SRDWithRevData) { data.SRD.EntityID = "" }},
+		{"RevData of another CA", func(data *mtr.SRDWithRevData) { data.RevData.EntityID = "other" }},
+		{"no RevocationType", func(data *mtr.SRDWithRevData) { data.RevData.RevocationType = "" }},
+		{"no CRVDelta", func(data *mtr.SRDWithRevData) { data.RevData.CRVDelta = nil }},
+		{"short CRVHash", func(data *mtr.SRDWithRevData) { data.SRD.RevDigest.CRVHash = data.SRD.RevDigest.CRVHash[1:] }},
+		{"long CRVDeltaHash", func(data *mtr.SRDWithRevData) { data.SRD.RevDigest.CRVDeltaHash = append(data.SRD.RevDigest.CRVDeltaHash, 0) }},
+		{"unknown hash", func(data *mtr.SRDWithRevData) { data.SRD.Signature.Algorithm.Hash = 0 }},
+		{"no signature", func(data *mtr.SRDWithRevData) { data.SRD.Signature.Signature = nil }},
+		{"no timestamp", func(data *mtr.SRDWithRevData) { data.SRD.RevDigest.Timestamp = 0 }},
+		{"timestamp out of range", func(data *mtr.SRDWithRevData) { data.SRD.RevDigest.Timestamp = 1 << 63 }},
+	}
+	if err := ValidateSRDWithRevData(testutil.CreateCASRD(t, []uint64{1,3}, []uint64{1,3}, time.Now())); err != nil {
+		t.Fatalf("valid SRD should pass: %v", err)
+	}
+	for _, test := range tests {
+		data := testutil.CreateCASRD(t, []uint64{1,3}, []uint64{1,3}, time.Now())
+		test.modify(data)
+		if err := ValidateSRDWithRevData(data); !errors.Is(err, ErrInvalidRequest) {
+			t.Fatalf("%v: expected %v, got %v", test.name, ErrInvalidRequest, err)
+		}
+	}
+
+	//malformed SRDs are rejected before the poster is authenticated
+	logger, _ := mustCreateLogger(t)
+	logger.CAAuthMode = CAAuthSignature
+	res := httptest.NewRecorder()
+	logger.OnPostLogSRDWithRevData(res, newPostRequest(mustCreateModifiedCASRDBody(t, func(data *mtr.SRDWithRevData) {
+		data.SRD.Signature.Signature = nil
+	})))
+	mustGetErrorResponse(t, res, http.StatusBadRequest, ErrorCodeInvalidRequest)
+}
+
+func TestValidateSRDTimestamp(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name		string
+		timestamp	time.Time
+		mmd			uint64
+		valid		bool
+	}{
+		{"now", now, 10, true},
+		{"within skew ahead", now.Add(DefaultMaxTimestampSkew - time.Second), 10, true},
+		{"past skew ahead", now.Add(DefaultMaxTimestampSkew + time.Second), 10, false},
+		{"within mmd and skew", now.Add(-10*time.Second - DefaultMaxTimestampSkew + time.Second), 10, true},
+		{"older than mmd and skew", now.Add(-10*time.Second - DefaultMaxTimestampSkew - time.Second), 10, false},
+		{"old without mmd", now.Add(-24*time.Hour), 0, true},
+	}
+	for _, test := range tests {
+		err := ValidateSRDTimestamp(uint64(test.timestamp.Unix()), test.mmd, now, DefaultMaxTimestampSkew)
+		if test.valid && err != nil {
+			t.Fatalf("%v: timestamp should be valid: %v", test.name, err)
+		}
+		if !test.valid && !errors.Is(err, ErrTimestampOutOfRange) {
+			t.Fatalf("%v: expected %v, got %v", test.name, ErrTimestampOutOfRange, err)
+		}
+	}
+
+	//the logger checks SRDs against its clock before it signs them
+	logger, _ := mustCreateLogger(t)
+	future := testutil.CreateCASRD(t, []uint64{1}, []uint64{1}, now.Add(time.Hour))
+	if err := logger.UpdateLogSRDWithRevData(future); !errors.Is(err, ErrTimestampOutOfRange) {
+		t.Fatalf("SRD an hour ahead should be rejected, got %v", err)
+	}
+	old := testutil.CreateCASRD(t, []uint64{1}, []uint64{1}, now.Add(-time.Hour))
+	if err := logger.UpdateLogSRDWithRevData(old); !errors.Is(err, ErrTimestampOutOfRange) {
+		t.Fatalf("SRD older than the MMD of the CA should be rejected, got %v", err)
+	}
+	logger.MaxTimestampSkew = 2 * time.Hour
+	if err := logger.UpdateLogSRDWithRevData(old); err != nil {
+		t.Fatalf("SRD within max_timestamp_skew should be accepted: %v", err)
+	}
+}
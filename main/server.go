@@ -1,91 +1,269 @@
 package main
 
 import (
+	"fmt"
+	"errors"
 	"flag"
 	"context"
 	"time"
 	"os"
 	"os/signal"
-	"net/http"
+	"strings"
 	"syscall"
 
 	"github.com/golang/glog"
 
 	lgr "github.com/n-ct/ct-logger/logger"
+	"github.com/n-ct/ct-logger/server"
+	"github.com/n-ct/ct-logger/tlsutil"
 )
 func main(){
 	//configName := flag.String("config", "..logger/config.json", "File containing logger config file")
 	//caListName := flag.String("ca_list", "..logger/ca_list.json", "File containing ca list file")
-	configName := flag.String("config", "logger/config.json", "File containing logger config file")
+	configName := flag.String("config", "logger/config.json", "File containing logger config file, a comma separated list serves several logs from one process")
 	caListName := flag.String("calist", "logger/ca_list.json", "File containing ca list file")
 	logListName := flag.String("loglist", "logger/log_list.json", "File containing log list file")
+	stateName := flag.String("state", "", "File containing a state archive to import on startup, one per -config when several are given")
+	leaderURL := flag.String("leader", "", "URL of a leader logger to replicate from, the path prefix of each log is appended. Send SIGUSR1 to promote this logger")
+	tlsCertName := flag.String("tls_cert", "", "File containing the PEM TLS certificate, serves HTTPS when set")
+	tlsKeyName := flag.String("tls_key", "", "File containing the PEM TLS private key")
+	clientCAName := flag.String("tls_client_ca", "", "File containing the PEM CAs of client certificates, CAs must present one to post SRDs when set")
+	caListURL := flag.String("calist_url", "", "URL to fetch the ca list from, -calist is its verified cache. Its detached signature is fetched from the URL with .sig appended")
+	caListKeyName := flag.String("calist_key", "", "File containing the PEM public key the fetched ca list is signed with")
+	logListURL := flag.String("loglist_url", "", "URL to fetch the log list from, -loglist is its verified cache. Its detached signature is fetched from the URL with .sig appended")
+	logListKeyName := flag.String("loglist_key", "", "File containing the PEM public key the fetched log list is signed with")
+	listRefreshInterval := flag.Duration("list_refresh_interval", lgr.DefaultListRefreshInterval, "How often lists are fetched again from -calist_url and -loglist_url")
+	otlpEndpoint := flag.String("otlp_endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "URL of an OpenTelemetry collector to export trace spans to with OTLP over HTTP, e.g. http://localhost:4318. Tracing is off when empty")
+	otlpServiceName := flag.String("otlp_service_name", "ct-logger", "service.name of the exported spans")
+	drainTimeout := flag.Duration("drain_timeout", 30*time.Second, "How long shutdown waits for requests in flight before closing their connections")
+	saveStateName := flag.String("save_state", "", "File to export the state archive of the logger to on shutdown, one per -config when several are given")
+	probeInterval := flag.Duration("probe_interval", lgr.DefaultProbeInterval, "How often the signing key and the CAs are probed, /readyz reports the latest results")
+	reloadInterval := flag.Duration("reload_interval", lgr.DefaultReloadInterval, "How often the config, ca list and log list files are checked for changes, 0 disables it. Send SIGHUP to reload them at once")
 
 	flag.Parse()
 	defer glog.Flush()
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+	// Replication, file watching and list refreshing run until shutdown cancels background
+	background, stopBackground := context.WithCancel(context.Background())
+	failed := make(chan error, 1)
 
-	// Create a logger instance
-	logger, err := lgr.NewLogger(*configName, *caListName, *logListName)
-	if err != nil {
-		// fmt.Printf("Error creating logger: %v", err)	// Only for testing purposes
-		glog.Fatalf("Error creating logger: %v", err)
-		glog.Flush()
-		os.Exit(-1)
+	// Lists fetched from a URL are verified into their cache files before any logger reads them
+	remoteLists := remoteListSetup(*caListURL, *caListKeyName, *caListName, *logListURL, *logListKeyName, *logListName)
+
+	// Create a logger instance for every config, each one is served under the path of its log URL
+	var loggers []*lgr.Logger
+	for _, name := range strings.Split(*configName, ",") {
+		logger, err := lgr.NewLogger(name, *caListName, *logListName)
+		if err != nil {
+			// fmt.Printf("Error creating logger: %v", err)	// Only for testing purposes
+			glog.Fatalf("Error creating logger from %v: %v", name, err)
+			glog.Flush()
+			os.Exit(-1)
+		}
+		loggers = append(loggers, logger)
+	}
+	// State archives are imported before the loggers are served, so no SRD or posted state can race them
+	if *stateName != "" {
+		stateNames := strings.Split(*stateName, ",")
+		if len(stateNames) != len(loggers) {
+			glog.Fatalf("Got %v state archives for %v loggers, -state must list one archive per -config", len(stateNames), len(loggers))
+		}
+		for i, name := range stateNames {
+			if err := loggers[i].ImportStateFromFile(name); err != nil {
+				glog.Fatalf("Error importing state of logger %v: %v", loggers[i].LogID, err)
+			}
+			glog.Infof("Imported state of logger %v from %v", loggers[i].LogID, name)
+		}
+	}
+	var saveStateNames []string
+	if *saveStateName != "" {
+		saveStateNames = strings.Split(*saveStateName, ",")
+		if len(saveStateNames) != len(loggers) {
+			glog.Fatalf("Got %v files to save state to for %v loggers, -save_state must list one file per -config", len(saveStateNames), len(loggers))
+		}
+	}
+	exporter := tracingSetup(loggers, *otlpEndpoint, *otlpServiceName)
+	for _, logger := range loggers {
+		glog.Infof("Starting Logger %v at %v %v%v", logger.LogID, logger.Network, logger.Address, logger.PathPrefix)
 	}
-	glog.Infof("Starting Logger at %v", logger.Address)
 
-	// Create http.Server instance for the CA
-	server := serverSetup(logger)
+	var reloader *tlsutil.CertReloader
+	if *tlsCertName != "" {
+		var err error
+		reloader, err = tlsutil.NewCertReloader(*tlsCertName, *tlsKeyName, *clientCAName)
+		if err != nil {
+			glog.Fatalf("Error loading TLS certificates: %v", err)
+		}
+	}
+
+	// Followers refuse posts from before they are served, they replicate once their state is imported
+	var followers []*lgr.Follower
+	if *leaderURL != "" {
+		for _, logger := range loggers {
+			followers = append(followers, lgr.NewFollower(logger, strings.TrimSuffix(*leaderURL, "/") + logger.PathPrefix))
+		}
+	}
+
+	// Serve the loggers, /healthz and /readyz answer from here on
+	srv, err := server.New(loggers[0], server.Options{Loggers: loggers[1:], TLS: reloader, SaveState: saveStateNames, ProbeInterval: *probeInterval})
+	if err != nil {
+		glog.Exitf("Problem setting up server: %v", err)
+	}
+	if err := srv.Start(context.Background()); err != nil {
+		glog.Exitf("Problem starting server: %v", err)
+	}
 	glog.Infoln("Created logger server")
 
+	reloadSetup(background, loggers, *reloadInterval)
+	// A fetched list changes its cache file, which the file watching reloads. Only without it the refresh reloads itself
+	var onListChange func()
+	if *reloadInterval <= 0 {
+		onListChange = func() { reloadLoggers(loggers) }
+	}
+	for _, remoteList := range remoteLists {
+		go remoteList.Refresh(background, *listRefreshInterval, onListChange)
+	}
+
+	for _, follower := range followers {
+		followerSetup(background, follower, failed)
+	}
+
 	// Handling the stop signal and closing things
-	<-stop
-	glog.Infoln("Received stop signal")
-	shutdownServer(server, 0)
+	code := exitOK
+	select {
+	case sig := <-stop:
+		glog.Infof("Received %v signal", sig)
+	case err := <-failed:
+		glog.Errorf("Stopping: %v", err)
+		code = exitReplicationFailed
+	case err := <-srv.Errors():
+		glog.Errorf("Stopping: %v", err)
+		code = exitFailed
+	}
+	if shutdownCode := shutdown(srv, stopBackground, exporter, *drainTimeout); code == exitOK {
+		code = shutdownCode
+	}
+	glog.Infof("Logger server stopped with exit code %v", code)
+	glog.Flush()
+	os.Exit(code)
 }
 
-// Sets up the basic ca http server
-func serverSetup(l *lgr.Logger) *http.Server{
-	serveMux := handlerSetup(l)
-	server := &http.Server {
-		Addr: l.Address,
-		Handler: serveMux,
+// Reloads the files of every logger on SIGHUP and, unless interval is 0, whenever they change
+func reloadSetup(ctx context.Context, loggers []*lgr.Logger, interval time.Duration) {
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			glog.Infoln("Received reload signal")
+			reloadLoggers(loggers)
+		}
+	}()
+	if interval > 0 {
+		for _, logger := range loggers {
+			go logger.WatchFiles(ctx, interval)
+		}
+	}
+}
+
+func reloadLoggers(loggers []*lgr.Logger) {
+	for _, logger := range loggers {
+		if err := logger.Reload(); err != nil {
+			glog.Errorf("%v", err)
+		}
+	}
+}
+
+// Fetches the lists that have a URL into their cache files, the files the loggers are created from
+func remoteListSetup(caListURL, caListKeyName, caListName, logListURL, logListKeyName, logListName string) []*lgr.RemoteList {
+	var remoteLists []*lgr.RemoteList
+	if caListURL != "" {
+		publicKey, err := lgr.LoadListPublicKey(caListKeyName)
+		if err != nil {
+			glog.Exitf("Problem loading ca list key: %v", err)
+		}
+		remoteLists = append(remoteLists, lgr.NewRemoteCAList(caListURL, publicKey, caListName))
+	}
+	if logListURL != "" {
+		publicKey, err := lgr.LoadListPublicKey(logListKeyName)
+		if err != nil {
+			glog.Exitf("Problem loading log list key: %v", err)
+		}
+		remoteLists = append(remoteLists, lgr.NewRemoteLogList(logListURL, publicKey, logListName))
+	}
+	for _, remoteList := range remoteLists {
+		if err := remoteList.Sync(context.Background()); err != nil {
+			glog.Exitf("Problem fetching list: %v", err)
+		}
 	}
+	return remoteLists
+}
 
-	// start up handles
+// Starts replicating from the leader until SIGUSR1 promotes the logger or ctx is cancelled. If replication fails the error is sent to failed
+func followerSetup(ctx context.Context, follower *lgr.Follower, failed chan<- error) {
+	promote := make(chan os.Signal, 1)
+	signal.Notify(promote, syscall.SIGUSR1)
 	go func() {
-		if err := server.ListenAndServe(); err != nil {
-			glog.Flush()
-			glog.Exitf("Problem serving: %v\n",err)
+		<-promote
+		glog.Infoln("Received promote signal")
+		follower.Promote()
+	}()
+	go func() {
+		glog.Infof("Following leader at %v", follower.LeaderURL)
+		if err := follower.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			select {
+			case failed <- fmt.Errorf("replication of logger %v from leader failed: %w", follower.Logger.LogID, err):
+			default:
+			}
 		}
 	}()
-	return server
 }
 
-// Sets up the handler and the various path handle functions
-func handlerSetup(l *lgr.Logger) (*http.ServeMux) {
-	serveMux := http.NewServeMux()
-	serveMux.HandleFunc(lgr.PostLogSRDWithRevDataPath, l.OnPostLogSRDWithRevData)
-	serveMux.HandleFunc(lgr.GetLogSRDWithRevDataPath, l.OnGetLogSRDWithRevData)
-	serveMux.HandleFunc(lgr.RevokeAndProduceSRDPath, l.OnRevokeAndProduceSRD)
-
-	// Return a 200 on the root so clients can easily check if server is up
-	serveMux.HandleFunc("/", func(resp http.ResponseWriter, req *http.Request) {
-		if req.URL.Path == "/" {
-			resp.WriteHeader(http.StatusOK)
-		} else {
-			resp.WriteHeader(http.StatusNotFound)
-		}
-	})
-	return serveMux
+// Traces the loggers to the OpenTelemetry collector at endpoint, returns nil when endpoint is empty and tracing is off
+func tracingSetup(loggers []*lgr.Logger, endpoint, serviceName string) *lgr.OTLPExporter {
+	if endpoint == "" {
+		return nil
+	}
+	exporter := lgr.NewOTLPExporter(endpoint, serviceName)
+	tracer := lgr.NewTracer(exporter)
+	for _, logger := range loggers {
+		logger.SetTracer(tracer)
+	}
+	glog.Infof("Exporting trace spans to %v", endpoint)
+	return exporter
 }
 
-func shutdownServer(server *http.Server, returnCode int){
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
-	server.Shutdown(ctx)
-	glog.Infoln("Shutting down Server")
-	glog.Flush()
-	os.Exit(returnCode)
+// Exit codes of the logger server. 2 is left to the flag package, which exits with it on usage errors
+const (
+	exitOK					= 0
+	exitFailed				= 1 //flushing failed on shutdown, e.g. saving the state
+	exitDrainTimeout		= 3 //requests were still in flight when the drain timeout passed, their connections were closed
+	exitReplicationFailed	= 4 //the follower stopped because an SRD from the leader failed verification or was compacted away
+)
+
+// Stops the server in order: replication and list refreshing stop, the listener closes and requests in flight finish within
+// drainTimeout, the loggers drain, their state is saved and the audit logs and spans are flushed. Returns the exit code
+func shutdown(srv *server.Server, stopBackground context.CancelFunc, exporter *lgr.OTLPExporter, drainTimeout time.Duration) int {
+	glog.Infof("Shutting down Server, draining for up to %v", drainTimeout)
+	stopBackground()
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	code := exitOK
+	if err := srv.Stop(ctx); err != nil {
+		glog.Errorf("%v", err)
+		code = exitFailed
+		if server.IsDrainTimeout(err) {
+			code = exitDrainTimeout
+		}
+	}
+	if exporter != nil {
+		// spans get a moment of their own even when the drain used up its timeout
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := exporter.Shutdown(flushCtx); err != nil {
+			glog.Warningf("Failed to export the last spans: %v", err)
+		}
+	}
+	return code
 }
@@ -0,0 +1,203 @@
+// Package server serves loggers over HTTP, so the logger server can be embedded and tested
+// without the flags and signal handling of the ct-logger command
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+	"net/http"
+	"github.com/golang/glog"
+
+	lgr "github.com/n-ct/ct-logger/logger"
+	"github.com/n-ct/ct-logger/tlsutil"
+)
+
+// Options of a Server, the zero value serves one logger over HTTP on the address from its log URL
+type Options struct {
+	Loggers		[]*lgr.Logger //further loggers served next to the first, they must share its listen address
+	TLS			*tlsutil.CertReloader //serves HTTPS when set, CAs must present a client certificate to post SRDs when it has a ClientCAFile
+	Network		string //overrides the network of the loggers, "tcp" or "unix"
+	Address		string //overrides the listen address of the loggers, e.g. "127.0.0.1:0" for any free port
+	SaveState	[]string //files the state of each logger is exported to by Stop, one per logger in order, none when empty
+	ProbeInterval	time.Duration //how often the signer and CAs of the loggers are probed for /readyz, lgr.DefaultProbeInterval when 0
+}
+
+// Server serves the endpoints of its loggers, see lgr.NewServeMux
+type Server struct {
+	loggers		[]*lgr.Logger
+	options		Options
+	handler		http.Handler
+	httpServer	*http.Server
+	mu			sync.Mutex
+	listener	net.Listener
+	stopProbes	context.CancelFunc
+	errs		chan error
+}
+
+// Creates a Server for logger and the loggers in options, it does not listen until Start
+func New(logger *lgr.Logger, options Options) (*Server, error) {
+	if logger == nil {
+		return nil, fmt.Errorf("no logger to serve")
+	}
+	loggers := append([]*lgr.Logger{logger}, options.Loggers...)
+	if len(options.SaveState) > 0 && len(options.SaveState) != len(loggers) {
+		return nil, fmt.Errorf("got %v files to save state to for %v loggers, there must be one per logger", len(options.SaveState), len(loggers))
+	}
+	clientCerts := options.TLS != nil && options.TLS.ClientCAFile != ""
+	for _, l := range loggers {
+		//without a client CA no client certificate is ever verified, every CA post would be refused
+		if l.CAAuthMode == lgr.CAAuthMTLS && !clientCerts {
+			return nil, fmt.Errorf("logger %v authenticates CAs with client certificates (ca_auth_mode %q) but no TLS client CA is configured", l.LogID, lgr.CAAuthMTLS)
+		}
+	}
+	var wrapPost func(http.HandlerFunc) http.HandlerFunc
+	if clientCerts {
+		wrapPost = tlsutil.RequireClientCert
+	}
+	serveMux, err := lgr.NewServeMux(loggers, wrapPost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up handlers: %w", err)
+	}
+	if options.Network == "" {
+		options.Network = logger.Network
+	}
+	if options.Address == "" {
+		options.Address = logger.Address
+	}
+	if options.ProbeInterval <= 0 {
+		options.ProbeInterval = lgr.DefaultProbeInterval
+	}
+	return &Server{
+		loggers:	loggers,
+		options:	options,
+		handler:	serveMux,
+		httpServer:	&http.Server{Handler: serveMux},
+		errs:		make(chan error, 1),
+	}, nil
+}
+
+// Handler returns the handler serving the endpoints of the loggers, e.g. for httptest.NewServer
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// Loggers returns the loggers of the server, the one passed to New first
+func (s *Server) Loggers() []*lgr.Logger {
+	return s.loggers
+}
+
+// Starts listening and serving in the background and probes the loggers every Options.ProbeInterval.
+// Returns once the server listens, errors serving later are sent on Errors. ctx only bounds starting, Stop stops the server
+func (s *Server) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener != nil {
+		return fmt.Errorf("server is already started")
+	}
+	network, address := s.options.Network, s.options.Address
+	if network == "unix" {
+		// a socket left behind by a previous run would make Listen fail, a socket another server still answers on
+		// and anything else at the path are left alone
+		if info, err := os.Lstat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			var dialer net.Dialer
+			if conn, err := dialer.DialContext(ctx, network, address); err == nil {
+				conn.Close()
+				return fmt.Errorf("socket %v is in use by another server", address)
+			}
+			if err := os.Remove(address); err != nil {
+				return fmt.Errorf("failed to remove stale socket %v: %w", address, err)
+			}
+		}
+	}
+	var listenConfig net.ListenConfig
+	listener, err := listenConfig.Listen(ctx, network, address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %v %v: %w", network, address, err)
+	}
+	s.listener = listener
+	if s.options.TLS != nil {
+		s.httpServer.TLSConfig = s.options.TLS.TLSConfig()
+	}
+	go func() {
+		var err error
+		if s.options.TLS != nil {
+			err = s.httpServer.ServeTLS(listener, "", "")
+		} else {
+			err = s.httpServer.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			s.errs <- fmt.Errorf("failed to serve: %w", err)
+		}
+	}()
+	probeCtx, stopProbes := context.WithCancel(context.Background())
+	s.stopProbes = stopProbes
+	for _, logger := range s.loggers {
+		go logger.RunProbes(probeCtx, s.options.ProbeInterval)
+	}
+	glog.Infof("Serving %v loggers on %v %v", len(s.loggers), network, listener.Addr())
+	return nil
+}
+
+// Addr returns the address the server listens on, nil before Start
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Errors receives the error that stopped the server from serving, if one does
+func (s *Server) Errors() <-chan error {
+	return s.errs
+}
+
+// Stops the server: the listener closes and requests in flight finish until ctx is done, then their connections are closed.
+// Each logger drains so the update it applies finishes, its state is exported to Options.SaveState and its audit log closed.
+// Returns an error wrapping ctx.Err() when requests were still in flight, or the first error flushing a logger
+func (s *Server) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if s.stopProbes != nil {
+		s.stopProbes()
+	}
+	s.mu.Unlock()
+	var errs []error
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		s.httpServer.Close()
+		errs = append(errs, fmt.Errorf("requests still in flight, closed their connections: %w", err))
+	}
+	// an update still applying, e.g. from a closed connection or a leader, finishes before Drain returns
+	for _, logger := range s.loggers {
+		logger.Drain()
+	}
+	for i, name := range s.options.SaveState {
+		if err := s.loggers[i].ExportStateToFile(name); err != nil {
+			errs = append(errs, fmt.Errorf("failed to save state of logger %v to %v: %w", s.loggers[i].LogID, name, err))
+			continue
+		}
+		glog.Infof("Saved state of logger %v to %v", s.loggers[i].LogID, name)
+	}
+	for _, logger := range s.loggers {
+		if err := logger.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close audit log of logger %v: %w", logger.LogID, err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	for _, err := range errs[1:] {
+		glog.Errorf("%v", err)
+	}
+	return errs[0]
+}
+
+// IsDrainTimeout reports whether err from Stop means requests were still in flight when its ctx was done
+func IsDrainTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
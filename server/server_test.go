@@ -0,0 +1,220 @@
+package server
+
+import (
+	"testing"
+	"context"
+	"strings"
+	"time"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+
+	"github.com/n-ct/ct-monitor/signature"
+	"github.com/n-ct/ct-logger/client"
+	lgr "github.com/n-ct/ct-logger/logger"
+	"github.com/n-ct/ct-logger/internal/testutil"
+	"github.com/n-ct/ct-logger/tlsutil"
+)
+
+const (
+	config_filename  string = "../testdata/config.json"
+	caList_filename  string = "../testdata/ca_list.json"
+	logList_filename string = "../testdata/log_list.json"
+	ca_id			 string = testutil.CAID
+	operator_key	 string = "MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEJbGtvYT9k5HIV+3d7UeC1/5d+SNqOxrgYC1crNx7RH6nOLDZt0JjFSLTFO/CSS8ezkj8RYntgP1/ngfOAi1Nmg=="
+	operator_private_key string = "MHcCAQEEIGzYeqJRHAw+d1Kk/toFvQVX0vrwl0Z3uiPYsThWh1rHoAoGCCqGSM49AwEHoUQDQgAEJbGtvYT9k5HIV+3d7UeC1/5d+SNqOxrgYC1crNx7RH6nOLDZt0JjFSLTFO/CSS8ezkj8RYntgP1/ngfOAi1Nmg=="
+)
+
+func mustCreateLogger(t *testing.T) *lgr.Logger {
+	t.Helper()
+	logger, err := lgr.NewLogger(config_filename, caList_filename, logList_filename)
+	if err != nil {
+		t.Fatalf("failed to create Logger with config @ (%s): %v", config_filename, err)
+	}
+	return logger
+}
+
+//GETs path from url and returns the status code and body
+func mustGet(t *testing.T, url, path string) (int, string) {
+	t.Helper()
+	res, err := http.Get(url + path)
+	if err != nil {
+		t.Fatalf("failed to get %v: %v", path, err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("failed to read %v: %v", path, err)
+	}
+	return res.StatusCode, string(body)
+}
+
+func TestHandler(t *testing.T) {
+	logger := mustCreateLogger(t)
+	srv, err := New(logger, Options{})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	httpServer := httptest.NewServer(srv.Handler())
+	defer httpServer.Close()
+	c := client.NewLoggerClient(httpServer.URL, logger.LogID, logger.PublicKey, nil)
+	ctx := context.Background()
+
+	if err := c.PostLogSRDWithRevData(ctx, testutil.CreateCASRD(t, []uint64{1, 3}, []uint64{1, 3}, time.Now())); err != nil {
+		t.Fatalf("failed to post SRD: %v", err)
+	}
+	srds, err := c.GetLogSRDWithRevDataList(ctx)
+	if err != nil {
+		t.Fatalf("failed to get SRDs: %v", err)
+	}
+	if len(srds) != 1 || srds[0].SRD.EntityID != logger.LogID {
+		t.Fatalf("expected one SRD signed by the logger, got %+v", srds)
+	}
+	if _, err := c.GetState(ctx); err == nil {
+		t.Fatalf("state should only be served to operators")
+	}
+	logger.OperatorKeys = []string{operator_key}
+	if c.RequestSigner, err = signature.NewSigner(operator_private_key); err != nil {
+		t.Fatalf("failed to create operator signer: %v", err)
+	}
+	archive, err := c.GetState(ctx)
+	if err != nil {
+		t.Fatalf("failed to get state: %v", err)
+	}
+	if err := lgr.VerifyStateArchive(archive, logger.LogID, logger.PublicKey); err != nil {
+		t.Fatalf("state archive should verify: %v", err)
+	}
+
+	if code, _ := mustGet(t, httpServer.URL, lgr.HealthzPath); code != http.StatusOK {
+		t.Fatalf("healthz should answer 200, got %v", code)
+	}
+	logger.Probe(ctx)
+	if code, body := mustGet(t, httpServer.URL, lgr.ReadyzPath); code != http.StatusOK {
+		t.Fatalf("readyz should answer 200, got %v: %v", code, body)
+	}
+	if code, body := mustGet(t, httpServer.URL, lgr.MetricsPath); code != http.StatusOK || !strings.Contains(body, ca_id) {
+		t.Fatalf("metrics should count the SRD of the CA, got %v: %v", code, body)
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	logger := mustCreateLogger(t)
+	stateName := filepath.Join(t.TempDir(), "state.json")
+	srv, err := New(logger, Options{Address: "127.0.0.1:0", SaveState: []string{stateName}})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	if srv.Addr() != nil {
+		t.Fatalf("server should have no address before Start")
+	}
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	if err := srv.Start(context.Background()); err == nil {
+		t.Fatalf("server should not start twice")
+	}
+	url := "http://" + srv.Addr().String()
+	//the signer is probed in the background once the server started
+	deadline := time.Now().Add(5 * time.Second)
+	for code, _ := mustGet(t, url, lgr.ReadyzPath); code != http.StatusOK; code, _ = mustGet(t, url, lgr.ReadyzPath) {
+		if time.Now().After(deadline) {
+			t.Fatalf("started server should become ready, got %v", code)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c := client.NewLoggerClient(url, logger.LogID, logger.PublicKey, nil)
+	if err := c.PostLogSRDWithRevData(context.Background(), testutil.CreateCASRD(t, []uint64{1, 3}, []uint64{1, 3}, time.Now())); err != nil {
+		t.Fatalf("failed to post SRD: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Stop(ctx); err != nil {
+		t.Fatalf("failed to stop server: %v", err)
+	}
+	if !logger.IsDraining() {
+		t.Fatalf("stopped server should drain its logger")
+	}
+	if _, err := http.Get(url + lgr.HealthzPath); err == nil {
+		t.Fatalf("stopped server should not answer")
+	}
+	select {
+	case err := <-srv.Errors():
+		t.Fatalf("server should stop without an error, got %v", err)
+	default:
+	}
+
+	//the saved state imports into a fresh logger
+	restored := mustCreateLogger(t)
+	if err := restored.ImportStateFromFile(stateName); err != nil {
+		t.Fatalf("failed to import saved state: %v", err)
+	}
+	srdBytes, err := restored.GetAllLogSrdWithRevDataAsJSONBytes()
+	if err != nil {
+		t.Fatalf("failed to get SRDs of restored logger: %v", err)
+	}
+	if !strings.Contains(string(srdBytes), logger.LogID) {
+		t.Fatalf("restored logger should serve the SRD of the stopped one, got %s", srdBytes)
+	}
+}
+
+func TestStartUnixSocket(t *testing.T) {
+	socketName := filepath.Join(t.TempDir(), "logger.sock")
+
+	//a socket left behind by a run that did not remove it is replaced
+	listener, err := net.Listen("unix", socketName)
+	if err != nil {
+		t.Fatalf("failed to listen on %v: %v", socketName, err)
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+	srv, err := New(mustCreateLogger(t), Options{Network: "unix", Address: socketName})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("server should replace a stale socket: %v", err)
+	}
+	defer srv.Stop(context.Background())
+
+	//a socket another server answers on is kept
+	other, err := New(mustCreateLogger(t), Options{Network: "unix", Address: socketName})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	if err := other.Start(context.Background()); err == nil {
+		other.Stop(context.Background())
+		t.Fatalf("server should not take over a socket in use")
+	}
+	conn, err := net.Dial("unix", socketName)
+	if err != nil {
+		t.Fatalf("first server should still answer on %v: %v", socketName, err)
+	}
+	conn.Close()
+}
+
+func TestNewRejectsSaveStateCount(t *testing.T) {
+	logger := mustCreateLogger(t)
+	if _, err := New(logger, Options{SaveState: []string{"a.json", "b.json"}}); err == nil {
+		t.Fatalf("New should require one file to save state to per logger")
+	}
+	if _, err := New(nil, Options{}); err == nil {
+		t.Fatalf("New should reject a nil logger")
+	}
+}
+
+func TestNewRejectsMTLSWithoutClientCA(t *testing.T) {
+	logger := mustCreateLogger(t)
+	logger.CAAuthMode = lgr.CAAuthMTLS
+	if _, err := New(logger, Options{}); err == nil {
+		t.Fatalf("New should reject ca_auth_mode mtls without TLS")
+	}
+	if _, err := New(logger, Options{TLS: &tlsutil.CertReloader{}}); err == nil {
+		t.Fatalf("New should reject ca_auth_mode mtls without a TLS client CA")
+	}
+	if _, err := New(logger, Options{TLS: &tlsutil.CertReloader{ClientCAFile: "client_ca.pem"}}); err != nil {
+		t.Fatalf("New should accept ca_auth_mode mtls with a TLS client CA: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package tlsutil
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"os"
+	"io/ioutil"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+
+	"github.com/golang/glog"
+	lgr "github.com/n-ct/ct-logger/logger"
+)
+
+// How often the certificate files are checked for changes
+const DefaultReloadInterval = 10 * time.Second
+
+// CertReloader serves the certificate and key found in certFile and keyFile and picks up new versions
+// of them, and of the optional client CA file, without restarting the server
+type CertReloader struct {
+	CertFile		string
+	KeyFile			string
+	ClientCAFile	string // PEM bundle of CAs trusted to issue client certificates, empty disables client verification
+	ReloadInterval	time.Duration
+
+	lock			sync.Mutex
+	cert			*tls.Certificate
+	clientCAs		*x509.CertPool
+	modTimes		map[string] time.Time
+	lastCheck		time.Time
+}
+
+// Create a CertReloader and load the files for the first time
+func NewCertReloader(certFile, keyFile, clientCAFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		CertFile:		certFile,
+		KeyFile:		keyFile,
+		ClientCAFile:	clientCAFile,
+		ReloadInterval:	DefaultReloadInterval,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Load the certificate files. If any of them is invalid the previously loaded ones are kept
+func (r *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	var clientCAs *x509.CertPool
+	if r.ClientCAFile != "" {
+		pemBytes, err := ioutil.ReadFile(r.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA file: %v", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pemBytes) {
+			return fmt.Errorf("no certificates found in client CA file %v", r.ClientCAFile)
+		}
+	}
+	modTimes, err := r.readModTimes()
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	r.lastCheck = time.Now()
+	r.lock.Unlock()
+	return nil
+}
+
+func (r *CertReloader) readModTimes() (map[string] time.Time, error) {
+	modTimes := make(map[string] time.Time)
+	for _, fileName := range []string{r.CertFile, r.KeyFile, r.ClientCAFile} {
+		if fileName == "" {
+			continue
+		}
+		info, err := os.Stat(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %v: %v", fileName, err)
+		}
+		modTimes[fileName] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// Reload the files if the reload interval has passed and any of them changed since the last load
+func (r *CertReloader) maybeReload() {
+	r.lock.Lock()
+	if time.Since(r.lastCheck) < r.ReloadInterval {
+		r.lock.Unlock()
+		return
+	}
+	r.lastCheck = time.Now()
+	oldModTimes := r.modTimes
+	r.lock.Unlock()
+
+	modTimes, err := r.readModTimes()
+	if err != nil {
+		glog.Warningf("failed to check TLS certificate files for changes: %v", err)
+		return
+	}
+	for fileName, modTime := range modTimes {
+		if !modTime.Equal(oldModTimes[fileName]) {
+			if err := r.Reload(); err != nil {
+				glog.Errorf("failed to reload TLS certificates, keeping the old ones: %v", err)
+				return
+			}
+			glog.Infof("reloaded TLS certificates from %v", r.CertFile)
+			return
+		}
+	}
+}
+
+// Return a server TLS config that always uses the most recently loaded files.
+// When a client CA file is set, client certificates are verified if given, use RequireClientCert
+// to require them on specific endpoints
+func (r *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:	tls.VersionTLS12,
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			r.maybeReload()
+			r.lock.Lock()
+			defer r.lock.Unlock()
+			config := &tls.Config{
+				MinVersion:		tls.VersionTLS12,
+				Certificates:	[]tls.Certificate{*r.cert},
+			}
+			if r.clientCAs != nil {
+				config.ClientCAs = r.clientCAs
+				config.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			return config, nil
+		},
+	}
+}
+
+// Wrap the handler so it is only served to clients that presented a verified client certificate
+func RequireClientCert(handler http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+			glog.Warningf("rejected %v request to %v from %v without a verified client certificate", req.Method, req.URL.Path, req.RemoteAddr)
+			lgr.WriteError(res, lgr.NewError(lgr.ErrorCodeUnauthorized, nil, "A verified client certificate is required"))
+			return
+		}
+		handler(res, req)
+	}
+}
@@ -0,0 +1,160 @@
+package tlsutil
+
+import (
+	"testing"
+	"time"
+	"os"
+	"math/big"
+	"path/filepath"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+)
+
+//a certificate and key signed by a test CA
+type testCert struct {
+	cert	*x509.Certificate
+	key		*ecdsa.PrivateKey
+}
+
+//function that creates a certificate, self signed if parent is nil
+func mustCreateCert(t *testing.T, serial int64, parent *testCert, isCA bool, usage x509.ExtKeyUsage) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:			big.NewInt(serial),
+		Subject:				pkix.Name{CommonName: "ct-logger test"},
+		NotBefore:				time.Now().Add(-time.Hour),
+		NotAfter:				time.Now().Add(time.Hour),
+		IPAddresses:			[]net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:					isCA,
+		BasicConstraintsValid:	true,
+		KeyUsage:				x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	if !isCA {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+	}
+	signerCert, signerKey := template, key
+	if parent != nil {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return &testCert{cert, key}
+}
+
+//function that writes the certificate and key as PEM files and returns their names
+func mustWriteCert(t *testing.T, dir, name string, c *testCert) (string, string) {
+	t.Helper()
+	certFile := filepath.Join(dir, name + ".crt")
+	keyFile := filepath.Join(dir, name + ".key")
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600)
+	if err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+//function that starts a TLS server with an open GET endpoint and a POST endpoint that requires a client certificate
+func mustStartServer(t *testing.T) (*httptest.Server, *CertReloader, *testCert, string) {
+	t.Helper()
+	dir := t.TempDir()
+	ca := mustCreateCert(t, 1, nil, true, 0)
+	caFile, _ := mustWriteCert(t, dir, "ca", ca)
+	certFile, keyFile := mustWriteCert(t, dir, "server", mustCreateCert(t, 2, ca, false, x509.ExtKeyUsageServerAuth))
+	reloader, err := NewCertReloader(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("failed to create CertReloader: %v", err)
+	}
+
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc("/get", func(res http.ResponseWriter, req *http.Request) {})
+	serveMux.HandleFunc("/post", RequireClientCert(func(res http.ResponseWriter, req *http.Request) {}))
+	server := httptest.NewUnstartedServer(serveMux)
+	server.TLS = reloader.TLSConfig()
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server, reloader, ca, dir
+}
+
+func newClient(ca *testCert, clientCert *testCert) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	config := &tls.Config{RootCAs: roots}
+	if clientCert != nil {
+		config.Certificates = []tls.Certificate{clientCert.tlsCertificate()}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+}
+
+func TestClientCertificateVerification(t *testing.T) {
+	server, _, ca, _ := mustStartServer(t)
+
+	resp, err := newClient(ca, nil).Get(server.URL + "/get")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET without client certificate failed: %v %v", resp, err)
+	}
+	resp, err = newClient(ca, nil).Post(server.URL + "/post", "application/json", nil)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("POST without client certificate should be unauthorized: %v %v", resp, err)
+	}
+
+	clientCert := mustCreateCert(t, 3, ca, false, x509.ExtKeyUsageClientAuth)
+	resp, err = newClient(ca, clientCert).Post(server.URL + "/post", "application/json", nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST with client certificate failed: %v %v", resp, err)
+	}
+
+	//a certificate from an unknown CA must not be accepted
+	otherCA := mustCreateCert(t, 4, nil, true, 0)
+	_, err = newClient(ca, mustCreateCert(t, 5, otherCA, false, x509.ExtKeyUsageClientAuth)).Post(server.URL + "/post", "application/json", nil)
+	if err == nil {
+		t.Fatalf("handshake with untrusted client certificate succeeded")
+	}
+}
+
+func TestCertificateHotReload(t *testing.T) {
+	server, reloader, ca, dir := mustStartServer(t)
+	reloader.ReloadInterval = 0
+
+	mustWriteCert(t, dir, "server", mustCreateCert(t, 42, ca, false, x509.ExtKeyUsageServerAuth))
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(reloader.CertFile, future, future)
+
+	resp, err := newClient(ca, nil).Get(server.URL + "/get")
+	if err != nil {
+		t.Fatalf("GET after reload failed: %v", err)
+	}
+	if serial := resp.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 42 {
+		t.Fatalf("server still serves certificate %v after reload", serial)
+	}
+}
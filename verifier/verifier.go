@@ -0,0 +1,262 @@
+package verifier
+
+import (
+	"fmt"
+	"bytes"
+	"sort"
+	"encoding/json"
+
+	ba "github.com/Workiva/go-datastructures/bitarray"
+	mtr "github.com/n-ct/ct-monitor"
+	el "github.com/n-ct/ct-monitor/entitylist"
+	"github.com/n-ct/ct-monitor/signature"
+	"github.com/n-ct/ct-monitor/utils"
+	ctca "github.com/n-ct/ct-certificate-authority"
+	lgr "github.com/n-ct/ct-logger/logger"
+)
+
+// Report holds the result of verifying one or more logger SRD bundles
+type Report struct {
+	// CAs maps CA ID to the findings for the SRDs covering that CA
+	CAs			map[string] *CAReport
+	// Problems that could not be attributed to a CA, e.g. undecodable CTObjects
+	Problems	[]string
+}
+
+// CAReport holds the findings for every SRD of a single CA
+type CAReport struct {
+	CAID		string
+	SRDs		int
+	// Problems are inconsistencies that make the bundle invalid
+	Problems	[]string
+	// Warnings are checks that could not be completed with the data in the bundle
+	Warnings	[]string
+}
+
+// OK returns true if no problems were found anywhere in the bundle
+func (r *Report) OK() bool {
+	if len(r.Problems) > 0 {
+		return false
+	}
+	for _, caReport := range r.CAs {
+		if len(caReport.Problems) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Report) caReport(caID string) *CAReport {
+	caReport, ok := r.CAs[caID]
+	if !ok {
+		caReport = &CAReport{CAID: caID}
+		r.CAs[caID] = caReport
+	}
+	return caReport
+}
+
+func (c *CAReport) problemf(format string, a ...interface{}) {
+	c.Problems = append(c.Problems, fmt.Sprintf(format, a...))
+}
+
+func (c *CAReport) warningf(format string, a ...interface{}) {
+	c.Warnings = append(c.Warnings, fmt.Sprintf(format, a...))
+}
+
+// a decoded SRD from the bundle together with its position for reporting
+type entry struct {
+	index	int
+	srd		*mtr.SRDWithRevData
+}
+
+// KeyHistories maps log ID to the verified key history of that logger
+type KeyHistories map[string] []lgr.LoggerKey
+
+// Read key history files, each holding the JSON served at logger.GetKeysPath, and verify them against the log list keys
+func LoadKeyHistories(keyHistoryNames []string, logList *el.LogList) (KeyHistories, error) {
+	keyHistories := make(KeyHistories)
+	for _, keyHistoryName := range keyHistoryNames {
+		byteData, err := utils.FiletoBytes(keyHistoryName)
+		if err != nil {
+			return nil, err
+		}
+		var history lgr.KeyHistory
+		if err := json.Unmarshal(byteData, &history); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal key history from %v: %v", keyHistoryName, err)
+		}
+		logID := history.Keys.LogID
+		logInfo := logList.FindLogByLogID(logID)
+		if logInfo == nil {
+			return nil, fmt.Errorf("logger (%v) of key history %v not found in log list", logID, keyHistoryName)
+		}
+		keys, err := lgr.VerifyKeyHistory(&history, logID, logInfo.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key history %v: %v", keyHistoryName, err)
+		}
+		keyHistories[logID] = keys
+	}
+	return keyHistories, nil
+}
+
+// Verify bundle files, each holding the JSON array returned by GetAllLogSrdWithRevDataAsJSONBytes.
+// Passing several dumps of the same logger taken over time allows the CRV hashes to be recomputed across MMDs.
+// SRDs of loggers with a file in keyHistoryNames are verified against the key history instead of the log list key
+func VerifyBundleFiles(bundleNames, keyHistoryNames []string, caListName, logListName string) (*Report, error) {
+	caList, err := el.NewCAList(caListName)
+	if err != nil {
+		return nil, err
+	}
+	logList, err := el.NewLogList(logListName)
+	if err != nil {
+		return nil, err
+	}
+	keyHistories, err := LoadKeyHistories(keyHistoryNames, logList)
+	if err != nil {
+		return nil, err
+	}
+	var ctObjects []lgr.LogSRDCTObject
+	for _, bundleName := range bundleNames {
+		byteData, err := utils.FiletoBytes(bundleName)
+		if err != nil {
+			return nil, err
+		}
+		var bundle []lgr.LogSRDCTObject
+		if err := json.Unmarshal(byteData, &bundle); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal CTObjects from %v: %v", bundleName, err)
+		}
+		ctObjects = append(ctObjects, bundle...)
+	}
+	return VerifyBundleWithKeys(ctObjects, caList, logList, keyHistories), nil
+}
+
+// Verify every logger signature in the bundle against the log list keys and recompute the CRV hashes from the deltas of each CA
+func VerifyBundle(ctObjects []lgr.LogSRDCTObject, caList *el.CAList, logList *el.LogList) *Report {
+	return VerifyBundleWithKeys(ctObjects, caList, logList, nil)
+}
+
+// Same as VerifyBundle, but SRDs of loggers in keyHistories may be signed by any key of their history
+func VerifyBundleWithKeys(ctObjects []lgr.LogSRDCTObject, caList *el.CAList, logList *el.LogList, keyHistories KeyHistories) *Report {
+	report := &Report{CAs: make(map[string] *CAReport)}
+	entries := make(map[string] map[string] []entry) //map[CA ID][Revocation Type]
+	for i := range ctObjects {
+		srd, err := verifyCTObject(&ctObjects[i], logList, keyHistories)
+		if srd == nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("CTObject %v: %v", i, err))
+			continue
+		}
+		caID := ctObjects[i].CAID //not signed, a logger SRD only names the logger
+		if caID == "" {
+			report.Problems = append(report.Problems, fmt.Sprintf("CTObject %v: does not name the CA of its SRD", i))
+			continue
+		}
+		caReport := report.caReport(caID)
+		caReport.SRDs++
+		if err != nil {
+			caReport.problemf("CTObject %v at timestamp %v: %v", i, srd.SRD.RevDigest.Timestamp, err)
+		}
+		if caList.FindCAByCAID(caID) == nil {
+			caReport.problemf("CTObject %v: CA not found in ca list", i)
+		}
+		if entries[caID] == nil {
+			entries[caID] = make(map[string] []entry)
+		}
+		revType := srd.RevData.RevocationType
+		entries[caID][revType] = append(entries[caID][revType], entry{i, srd})
+	}
+	for caID, revTypes := range entries {
+		for _, revEntries := range revTypes {
+			verifyCRVChain(report.caReport(caID), revEntries)
+		}
+	}
+	return report
+}
+
+// Decode the SRD from the CTObject and check everything that does not depend on other SRDs.
+// Returns a nil SRD if the CTObject could not be decoded at all
+func verifyCTObject(ctObject *lgr.LogSRDCTObject, logList *el.LogList, keyHistories KeyHistories) (*mtr.SRDWithRevData, error) {
+	if ctObject.TypeID != mtr.SRDWithRevDataTypeID {
+		return nil, fmt.Errorf("CTObject of type %v is not a %v", ctObject.TypeID, mtr.SRDWithRevDataTypeID)
+	}
+	var srd mtr.SRDWithRevData
+	if err := json.Unmarshal(ctObject.Blob, &srd); err != nil {
+		return nil, fmt.Errorf("failed to decode SRDWithRevData: %v", err)
+	}
+
+	logID := srd.SRD.EntityID
+	logInfo := logList.FindLogByLogID(logID)
+	if logInfo == nil {
+		return &srd, fmt.Errorf("signing logger (%v) not found in log list", logID)
+	}
+	if keys, ok := keyHistories[logID]; ok {
+		if err := lgr.VerifySRDWithKeys(&srd, keys, ctObject.KeyID); err != nil {
+			return &srd, fmt.Errorf("invalid signature of logger (%v): %v", logID, err)
+		}
+	} else if err := lgr.VerifyLogSRD(logInfo.Key, &srd); err != nil {
+		return &srd, fmt.Errorf("invalid signature of logger (%v): %v", logID, err)
+	}
+	if ctObject.Signer != logID || ctObject.Timestamp != srd.SRD.RevDigest.Timestamp {
+		return &srd, fmt.Errorf("CTObject header does not match the SRD it holds")
+	}
+	hashAlgo := srd.SRD.Signature.Algorithm.Hash
+	digest, _, err := signature.GenerateHash(hashAlgo, ctObject.Blob)
+	if err != nil || !bytes.Equal(digest, ctObject.Digest) {
+		return &srd, fmt.Errorf("CTObject digest does not match its blob")
+	}
+	deltaHash, _, err := signature.GenerateHash(hashAlgo, srd.RevData.CRVDelta)
+	if err != nil || !bytes.Equal(deltaHash, srd.SRD.RevDigest.CRVDeltaHash) {
+		return &srd, fmt.Errorf("CRVDeltaHash does not match the hash of the delta CRV")
+	}
+	return &srd, nil
+}
+
+// Apply the deltas of a single CA and revocation type in timestamp order and compare the CRV hashes.
+// Like the CRV it was made from, the CRVHash of a logger SRD is of the CRV before its delta is applied
+func verifyCRVChain(caReport *CAReport, entries []entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].srd.SRD.RevDigest.Timestamp < entries[j].srd.SRD.RevDigest.Timestamp
+	})
+	var crv *ba.BitArray
+	var previous *entry
+	for i := range entries {
+		e := &entries[i]
+		revDigest := e.srd.SRD.RevDigest
+		if previous != nil && previous.srd.SRD.RevDigest.Timestamp == revDigest.Timestamp {
+			if !bytes.Equal(previous.srd.SRD.RevDigest.CRVHash, revDigest.CRVHash) {
+				caReport.problemf("CTObjects %v and %v are conflicting SRDs for timestamp %v", previous.index, e.index, revDigest.Timestamp)
+			}
+			continue //same SRD from an overlapping dump
+		}
+		previous = e
+
+		deltaCRV, err := ctca.DecompressCRV(e.srd.RevData.CRVDelta)
+		if err != nil {
+			caReport.problemf("CTObject %v: invalid compression on delta CRV: %v", e.index, err)
+			crv = nil
+			continue
+		}
+		oldCRV := crv
+		if oldCRV == nil {
+			//the first SRD of a CA is made from an empty CRV
+			emptyCRV := ba.NewBitArray((*deltaCRV).Capacity())
+			oldCRV = &emptyCRV
+		}
+		compCRV, err := ctca.CompressCRV(oldCRV)
+		if err != nil {
+			caReport.problemf("CTObject %v: failed to compress CRV: %v", e.index, err)
+			crv = nil
+			continue
+		}
+		crvHash, _, err := signature.GenerateHash(e.srd.SRD.Signature.Algorithm.Hash, compCRV)
+		if err != nil || !bytes.Equal(crvHash, revDigest.CRVHash) {
+			if crv == nil {
+				//without the earlier deltas the full CRV is unknown, so no later SRD can be checked either
+				caReport.warningf("CTObject %v at timestamp %v: CRVHash cannot be recomputed, earlier deltas are missing from the bundle", e.index, revDigest.Timestamp)
+				continue
+			}
+			caReport.problemf("CTObject %v at timestamp %v: CRVHash does not match the CRV recomputed from the deltas", e.index, revDigest.Timestamp)
+			crv = nil
+			continue
+		}
+		crv = ctca.ApplyCRVDeltaToCRV(oldCRV, deltaCRV)
+	}
+}
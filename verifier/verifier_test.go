@@ -0,0 +1,172 @@
+package verifier
+
+import (
+	"testing"
+	"strings"
+	"encoding/json"
+	"time"
+	"io/ioutil"
+	"path/filepath"
+
+	mtr "github.com/n-ct/ct-monitor"
+	el "github.com/n-ct/ct-monitor/entitylist"
+	ctca "github.com/n-ct/ct-certificate-authority"
+	lgr "github.com/n-ct/ct-logger/logger"
+	"github.com/n-ct/ct-logger/internal/testutil"
+)
+
+const (
+	config_filename  string = "../testdata/config.json"
+	caList_filename  string = "../testdata/ca_list.json"
+	logList_filename string = "../testdata/log_list.json"
+	ca_id			 string = testutil.CAID
+)
+
+//feed the logger two MMDs of revocations and return the dump taken after each of them
+func mustCreateBundles(t *testing.T) ([]lgr.LogSRDCTObject, []lgr.LogSRDCTObject) {
+	t.Helper()
+	logger, err := lgr.NewLogger(config_filename, caList_filename, logList_filename)
+	if err != nil {
+		t.Fatalf("failed to create Logger: %v", err)
+	}
+	return mustCreateLoggerBundles(t, logger, time.Now())
+}
+
+//feed the given logger two MMDs of revocations made from start on and return the dump taken after each of them
+func mustCreateLoggerBundles(t *testing.T, logger *lgr.Logger, start time.Time) ([]lgr.LogSRDCTObject, []lgr.LogSRDCTObject) {
+	t.Helper()
+	var bundles [][]lgr.LogSRDCTObject
+	for _, srd := range []*mtr.SRDWithRevData{
+		testutil.CreateCASRD(t, []uint64{1, 3}, []uint64{1, 3}, start),
+		testutil.CreateCASRD(t, []uint64{1, 3, 4, 5, 7}, []uint64{4, 5, 7}, start.Add(time.Second)),
+	} {
+		if err := logger.UpdateLogSRDWithRevData(srd); err != nil {
+			t.Fatalf("failed to update logger: %v", err)
+		}
+		jsonBytes, err := logger.GetAllLogSrdWithRevDataAsJSONBytes()
+		if err != nil {
+			t.Fatalf("failed to dump logger: %v", err)
+		}
+		var bundle []lgr.LogSRDCTObject
+		if err := json.Unmarshal(jsonBytes, &bundle); err != nil {
+			t.Fatalf("failed to unmarshal bundle: %v", err)
+		}
+		bundles = append(bundles, bundle)
+	}
+	return bundles[0], bundles[1]
+}
+
+func mustLoadLists(t *testing.T) (*el.CAList, *el.LogList) {
+	t.Helper()
+	caList, err := el.NewCAList(caList_filename)
+	if err != nil {
+		t.Fatalf("failed to load ca list: %v", err)
+	}
+	logList, err := el.NewLogList(logList_filename)
+	if err != nil {
+		t.Fatalf("failed to load log list: %v", err)
+	}
+	return caList, logList
+}
+
+func TestVerifyBundleHistory(t *testing.T) {
+	first, second := mustCreateBundles(t)
+	caList, logList := mustLoadLists(t)
+
+	report := VerifyBundle(append(first, second...), caList, logList)
+	if !report.OK() {
+		t.Fatalf("valid bundle failed verification: %+v", report.CAs[ca_id])
+	}
+	if report.CAs[ca_id].SRDs != 2 || len(report.CAs[ca_id].Warnings) != 0 {
+		t.Fatalf("expected 2 fully checked SRDs for the CA, got %+v", report.CAs[ca_id])
+	}
+}
+
+func TestVerifyBundleWithoutHistory(t *testing.T) {
+	_, second := mustCreateBundles(t)
+	caList, logList := mustLoadLists(t)
+
+	report := VerifyBundle(second, caList, logList)
+	if !report.OK() {
+		t.Fatalf("valid bundle failed verification: %+v", report.CAs[ca_id])
+	}
+	if len(report.CAs[ca_id].Warnings) != 1 {
+		t.Fatalf("expected a warning about missing deltas, got %+v", report.CAs[ca_id])
+	}
+}
+
+func TestVerifyBundleDetectsTampering(t *testing.T) {
+	caList, logList := mustLoadLists(t)
+	tests := []struct {
+		name	string
+		tamper	func(srd *mtr.SRDWithRevData)
+		caID	string
+		want	string
+	}{
+		{"signature", func(srd *mtr.SRDWithRevData) { srd.SRD.RevDigest.Timestamp++ }, ca_id, "invalid signature"},
+		{"delta", func(srd *mtr.SRDWithRevData) {
+			srd.RevData.CRVDelta, _ = ctca.CompressCRV(ctca.GetCRVDelta([]uint64{6}))
+		}, ca_id, "CRVDeltaHash"},
+		{"crv", func(srd *mtr.SRDWithRevData) { srd.SRD.RevDigest.CRVHash[0]++ }, ca_id, "invalid signature"},
+		{"ca", func(srd *mtr.SRDWithRevData) {}, "unknown", "not found in ca list"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			first, second := mustCreateBundles(t)
+			var srd mtr.SRDWithRevData
+			json.Unmarshal(second[0].Blob, &srd)
+			test.tamper(&srd)
+			tampered, err := mtr.ConstructCTObject(&srd)
+			if err != nil {
+				t.Fatalf("failed to construct CTObject: %v", err)
+			}
+
+			report := VerifyBundle(append(first, lgr.LogSRDCTObject{CTObject: *tampered, CAID: test.caID}), caList, logList)
+			if report.OK() {
+				t.Fatalf("tampered bundle passed verification")
+			}
+			found := false
+			for _, caReport := range report.CAs {
+				for _, problem := range caReport.Problems {
+					found = found || strings.Contains(problem, test.want)
+				}
+			}
+			if !found {
+				t.Fatalf("expected a problem containing %q, got %+v", test.want, report.CAs)
+			}
+		})
+	}
+}
+
+func TestVerifyBundleWithRotatedKey(t *testing.T) {
+	//the key of the log list is being rotated out, a key not used by any other entity in the test lists took over
+	configName := testutil.WriteConfig(t, config_filename, testutil.WithRotatedKey(testutil.RotatedPrivateKey))
+	logger, err := lgr.NewLogger(configName, caList_filename, logList_filename)
+	if err != nil {
+		t.Fatalf("failed to create Logger: %v", err)
+	}
+	first, second := mustCreateLoggerBundles(t, logger, time.Now())
+	caList, logList := mustLoadLists(t)
+
+	if report := VerifyBundle(append(first, second...), caList, logList); report.OK() {
+		t.Fatalf("SRDs signed with a rotated key should not verify against the log list key alone")
+	}
+
+	history, err := logger.GetKeyHistory()
+	if err != nil {
+		t.Fatalf("failed to get key history: %v", err)
+	}
+	jsonBytes, _ := json.Marshal(history)
+	keysName := filepath.Join(t.TempDir(), "keys.json")
+	if err := ioutil.WriteFile(keysName, jsonBytes, 0644); err != nil {
+		t.Fatalf("failed to write key history: %v", err)
+	}
+	keyHistories, err := LoadKeyHistories([]string{keysName}, logList)
+	if err != nil {
+		t.Fatalf("failed to load key history: %v", err)
+	}
+	report := VerifyBundleWithKeys(append(first, second...), caList, logList, keyHistories)
+	if !report.OK() {
+		t.Fatalf("bundle signed with a rotated key failed verification: %+v %+v", report.Problems, report.CAs[ca_id])
+	}
+}